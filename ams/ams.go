@@ -7,6 +7,8 @@
 // https://infosys.beckhoff.com/english.php?content=../content/1033/tc3_adsnetref/7312567947.html&id=
 package ams
 
+import "fmt"
+
 // Command ids. Order matters
 const (
 	CmdInvalid uint16 = iota
@@ -21,6 +23,27 @@ const (
 	CmdADSReadWrite
 )
 
+var cmdNames = map[uint16]string{
+	CmdInvalid:                     "Invalid",
+	CmdADSReadDeviceInfo:           "ReadDeviceInfo",
+	CmdADSRead:                     "Read",
+	CmdADSWrite:                    "Write",
+	CmdADSReadState:                "ReadState",
+	CmdADSWriteControl:             "WriteControl",
+	CmdADSAddDeviceNotification:    "AddDeviceNotification",
+	CmdADSDeleteDeviceNotification: "DeleteDeviceNotification",
+	CmdADSDeviceNotification:       "DeviceNotification",
+	CmdADSReadWrite:                "ReadWrite",
+}
+
+// CmdName returns the name of the command id.
+func CmdName(id uint16) string {
+	if s, ok := cmdNames[id]; ok {
+		return s
+	}
+	return fmt.Sprintf("Cmd(%d)", id)
+}
+
 // State Flags
 const (
 	StateResponse        = 1 << 0
@@ -42,10 +65,16 @@ const (
 // IndexGroups
 // https://infosys.beckhoff.com/english.php?content=../content/1033/tcadsdeviceplc/html/tcadsdeviceplc_indexadsservice.htm&id=
 const (
+	IdxReadMWriteM               = 0x00004020
+	IdxReadMXWriteMX             = 0x00004021
 	IdxGetSymHandleByName        = 0x0000F003
 	IdxReserved                  = 0x0000F004
 	IdxReadWriteSymValueByHandle = 0x0000F005
 	IdxReleaseSymHandle          = 0x0000F006
+	IdxSymVersion                = 0x0000F008
+	IdxSymUpload                 = 0x0000F00B
+	IdxDataTypeUpload            = 0x0000F00E
+	IdxSymUploadInfo2            = 0x0000F00F
 	IdxReadIWriteI               = 0x0000F020
 	IdxReadIXWriteIX             = 0x0000F021
 	IdxADSIGRP_IOIMAGE_RISIZE    = 0x0000F025
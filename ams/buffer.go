@@ -55,6 +55,11 @@ func (buf *Buffer) ReadFull(b []byte) {
 	_, buf.err = io.ReadFull(&buf.b, b)
 }
 
+// Len returns the number of unread bytes in the buffer.
+func (buf *Buffer) Len() int {
+	return buf.b.Len()
+}
+
 // ReadN reads n bytes from the buffer.
 // It returns io.ErrUnexpectedEOF if the buffer
 // is too small.
@@ -62,6 +67,10 @@ func (buf *Buffer) ReadN(n int) []byte {
 	if buf.err != nil {
 		return nil
 	}
+	if n < 0 || n > buf.b.Len() {
+		buf.err = io.ErrUnexpectedEOF
+		return nil
+	}
 	b := make([]byte, n)
 	_, buf.err = io.ReadFull(&buf.b, b)
 	if buf.err != nil {
@@ -91,20 +100,40 @@ func (buf *Buffer) ReadFloat32Slice(n int) []float32 {
 	return fa
 }
 
+// ReadUint8 reads a uint8 from the buffer.
+func (buf *Buffer) ReadUint8() uint8 {
+	b := buf.ReadN(1)
+	if buf.err != nil {
+		return 0
+	}
+	return b[0]
+}
+
 // ReadUint16 reads a uint16 from the buffer.
 func (buf *Buffer) ReadUint16() uint16 {
+	b := buf.ReadN(2)
 	if buf.err != nil {
 		return 0
 	}
-	return binary.LittleEndian.Uint16(buf.ReadN(2))
+	return binary.LittleEndian.Uint16(b)
 }
 
 // ReadUint32 reads a uint32 from the buffer.
 func (buf *Buffer) ReadUint32() uint32 {
+	b := buf.ReadN(4)
+	if buf.err != nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+// ReadUint64 reads a uint64 from the buffer.
+func (buf *Buffer) ReadUint64() uint64 {
+	b := buf.ReadN(8)
 	if buf.err != nil {
 		return 0
 	}
-	return binary.LittleEndian.Uint32(buf.ReadN(4))
+	return binary.LittleEndian.Uint64(b)
 }
 
 // ReadUint32Slice reads n uint32 from the buffer.
@@ -112,6 +141,10 @@ func (buf *Buffer) ReadUint32Slice(n int) []uint32 {
 	if buf.err != nil {
 		return nil
 	}
+	if n < 0 || n > buf.b.Len()/4 {
+		buf.err = io.ErrUnexpectedEOF
+		return nil
+	}
 	a := make([]uint32, n)
 	for i := range a {
 		a[i] = buf.ReadUint32()
@@ -163,6 +196,14 @@ func (buf *Buffer) WriteFloat32Slice(a []float32) {
 	buf.WriteUint32Slice(aa)
 }
 
+// WriteUint8 writes a uint8 to the buffer.
+func (buf *Buffer) WriteUint8(n uint8) {
+	if buf.err != nil {
+		return
+	}
+	buf.err = buf.b.WriteByte(n)
+}
+
 // WriteUint16 writes a uint16 to the buffer.
 func (buf *Buffer) WriteUint16(n uint16) {
 	if buf.err != nil {
@@ -183,6 +224,16 @@ func (buf *Buffer) WriteUint32(n uint32) {
 	_, buf.err = buf.b.Write(b)
 }
 
+// WriteUint64 writes a uint64 to the buffer.
+func (buf *Buffer) WriteUint64(n uint64) {
+	if buf.err != nil {
+		return
+	}
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, n)
+	_, buf.err = buf.b.Write(b)
+}
+
 // WriteUint32Slice writes all uint32 without a length
 // encoding to the buffer.
 func (buf *Buffer) WriteUint32Slice(a []uint32) {
@@ -5,11 +5,24 @@
 package ams
 
 import (
+	"io"
 	"testing"
 
 	"github.com/pascaldekloe/goe/verify"
 )
 
+func TestBufferUint8(t *testing.T) {
+	var bw Buffer
+	bw.WriteUint8(0x12)
+	verify.Values(t, "err", bw.Err(), nil)
+	verify.Values(t, "bytes", bw.Bytes(), []byte{0x12})
+
+	br := NewBuffer(bw.Bytes())
+	n := br.ReadUint8()
+	verify.Values(t, "err", br.Err(), nil)
+	verify.Values(t, "data", n, uint8(0x12))
+}
+
 func TestBufferUint16(t *testing.T) {
 	var bw Buffer
 	bw.WriteUint16(0x1234)
@@ -34,6 +47,18 @@ func TestBufferUint32(t *testing.T) {
 	verify.Values(t, "data", n, uint32(0x1234))
 }
 
+func TestBufferUint64(t *testing.T) {
+	var bw Buffer
+	bw.WriteUint64(0x123456789a)
+	verify.Values(t, "err", bw.Err(), nil)
+	verify.Values(t, "bytes", bw.Bytes(), []byte{0x9a, 0x78, 0x56, 0x34, 0x12, 0x0, 0x0, 0x0})
+
+	br := NewBuffer(bw.Bytes())
+	n := br.ReadUint64()
+	verify.Values(t, "err", br.Err(), nil)
+	verify.Values(t, "data", n, uint64(0x123456789a))
+}
+
 func TestBufferUint32Slice(t *testing.T) {
 	var bw Buffer
 	bw.WriteUint32Slice([]uint32{0xa, 0xb})
@@ -75,3 +100,17 @@ func TestBufferFloat32Slice(t *testing.T) {
 	verify.Values(t, "err", br.Err(), nil)
 	verify.Values(t, "data", a, []float32{1, 2})
 }
+
+func TestBufferReadNTooLarge(t *testing.T) {
+	br := NewBuffer([]byte{0x1, 0x2})
+	verify.Values(t, "data", br.ReadN(0x7fffffff), []byte(nil))
+	verify.Values(t, "err", br.Err(), io.ErrUnexpectedEOF)
+
+	br = NewBuffer([]byte{0x1})
+	verify.Values(t, "uint16", br.ReadUint16(), uint16(0))
+	verify.Values(t, "err", br.Err(), io.ErrUnexpectedEOF)
+
+	br = NewBuffer([]byte{0x1, 0x2, 0x3, 0x4})
+	verify.Values(t, "slice", br.ReadUint32Slice(0x40000000), []uint32(nil))
+	verify.Values(t, "err", br.Err(), io.ErrUnexpectedEOF)
+}
@@ -5,6 +5,10 @@
 package ams
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
 	"reflect"
 	"testing"
 
@@ -46,6 +50,33 @@ var (
 	}
 )
 
+// tcpHeaderN returns tcpHeader with the length set
+// for a payload of n bytes.
+func tcpHeaderN(n uint32) TCPHeader {
+	h := tcpHeader
+	h.Length = amsHeaderLen + n
+	return h
+}
+
+// amsHeaderN returns amsHeader with the length set
+// for a payload of n bytes.
+func amsHeaderN(n uint32) AMSHeader {
+	h := amsHeader
+	h.Length = n
+	return h
+}
+
+// frameBytes returns the encoded headers with the length
+// set for the payload followed by the payload.
+func frameBytes(payload []byte) []byte {
+	var b []byte
+	b = append(b, tcpHeaderBytes...)
+	b = append(b, amsHeaderBytes...)
+	binary.LittleEndian.PutUint32(b[2:6], amsHeaderLen+uint32(len(payload)))
+	binary.LittleEndian.PutUint32(b[26:30], uint32(len(payload)))
+	return append(b, payload...)
+}
+
 type codec interface {
 	Encoder
 	Decoder
@@ -93,3 +124,67 @@ func codecTest(t *testing.T, c codec, b []byte) {
 
 	verify.Values(t, "compare", cnew, c)
 }
+
+func TestDecodeInvalidLength(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Decoder
+		b    []byte
+	}{
+		{
+			name: "Header",
+			p:    &Header{},
+			b: func() []byte {
+				b := frameBytes(nil)
+				b[2]++ // TCPHeader.Length
+				return b
+			}(),
+		},
+		{
+			name: "ReadResponse too short",
+			p:    &ReadResponse{},
+			b: func() []byte {
+				b := frameBytes([]byte{0, 0, 0, 0, 2, 0, 0, 0, 1, 2})
+				b[26] = 9 // AMSHeader.Length
+				b[2] = amsHeaderLen + 9
+				return b
+			}(),
+		},
+		{
+			name: "WriteResponse trailing data",
+			p:    &WriteResponse{},
+			b:    frameBytes(make([]byte, 5)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.Decode(NewBuffer(tt.b))
+			if !errors.Is(err, ErrInvalidLength) {
+				t.Fatalf("got %v want %v", err, ErrInvalidLength)
+			}
+		})
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	frame := frameBytes([]byte{1, 2, 3})
+	r := bytes.NewReader(append(append([]byte{}, frame...), frame...))
+	for i := 0; i < 2; i++ {
+		b, err := ReadFrame(r, DefaultMaxFrameLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verify.Values(t, "frame", b, frame)
+	}
+	if _, err := ReadFrame(r, DefaultMaxFrameLen); err != io.EOF {
+		t.Fatalf("got %v want %v", err, io.EOF)
+	}
+
+	if _, err := ReadFrame(bytes.NewReader(frame), amsHeaderLen+2); !errors.Is(err, ErrInvalidLength) {
+		t.Fatalf("got %v want %v", err, ErrInvalidLength)
+	}
+	if _, err := ReadFrame(bytes.NewReader(frame[:10]), DefaultMaxFrameLen); err != io.ErrUnexpectedEOF {
+		t.Fatalf("got %v want %v", err, io.ErrUnexpectedEOF)
+	}
+}
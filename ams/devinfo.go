@@ -0,0 +1,109 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ams
+
+import "bytes"
+
+// deviceNameLen is the length of the device name in a ReadDeviceInfo response.
+const deviceNameLen = 16
+
+// ReadDeviceInfoRequest is the packet for an AMS ReadDeviceInfo request.
+type ReadDeviceInfoRequest struct {
+	tcpHeader TCPHeader
+	amsHeader AMSHeader
+}
+
+func NewReadDeviceInfoRequest(target, sender Addr) *ReadDeviceInfoRequest {
+	return &ReadDeviceInfoRequest{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSReadDeviceInfo,
+			StateFlags: StateADSCommand,
+		},
+	}
+}
+
+func (r *ReadDeviceInfoRequest) Header() *AMSHeader {
+	return &r.amsHeader
+}
+
+func (r *ReadDeviceInfoRequest) Encode(b *Buffer) error {
+	b.WriteStruct(&r.tcpHeader)
+	b.WriteStruct(&r.amsHeader)
+	return b.Err()
+}
+
+func (r *ReadDeviceInfoRequest) Decode(b *Buffer) error {
+	b.ReadStruct(&r.tcpHeader)
+	b.ReadStruct(&r.amsHeader)
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, 0)
+}
+
+// ReadDeviceInfoResponse is the packet for an AMS ReadDeviceInfo response.
+type ReadDeviceInfoResponse struct {
+	tcpHeader    TCPHeader
+	amsHeader    AMSHeader
+	Result       uint32
+	MajorVersion uint8
+	MinorVersion uint8
+	VersionBuild uint16
+	DeviceName   string
+}
+
+func NewReadDeviceInfoResponse(target, sender Addr, result uint32, major, minor uint8, build uint16, name string) *ReadDeviceInfoResponse {
+	return &ReadDeviceInfoResponse{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + 24,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSReadDeviceInfo,
+			StateFlags: StateADSCommand | StateResponse,
+			Length:     24,
+		},
+		Result:       result,
+		MajorVersion: major,
+		MinorVersion: minor,
+		VersionBuild: build,
+		DeviceName:   name,
+	}
+}
+
+func (r *ReadDeviceInfoResponse) Header() *AMSHeader {
+	return &r.amsHeader
+}
+
+func (r *ReadDeviceInfoResponse) Encode(b *Buffer) error {
+	b.WriteStruct(&r.tcpHeader)
+	b.WriteStruct(&r.amsHeader)
+	b.WriteUint32(r.Result)
+	b.WriteUint8(r.MajorVersion)
+	b.WriteUint8(r.MinorVersion)
+	b.WriteUint16(r.VersionBuild)
+	name := make([]byte, deviceNameLen)
+	copy(name, r.DeviceName)
+	b.Write(name)
+	return b.Err()
+}
+
+func (r *ReadDeviceInfoResponse) Decode(b *Buffer) error {
+	b.ReadStruct(&r.tcpHeader)
+	b.ReadStruct(&r.amsHeader)
+	r.Result = b.ReadUint32()
+	r.MajorVersion = b.ReadUint8()
+	r.MinorVersion = b.ReadUint8()
+	r.VersionBuild = b.ReadUint16()
+	name := b.ReadN(deviceNameLen)
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	r.DeviceName = string(name)
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, 24)
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ams
+
+import (
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestNewReadDeviceInfoResponse(t *testing.T) {
+	got := NewReadDeviceInfoResponse(target, sender, 0x1, 3, 1, 4024, "Plc30 App")
+	want := &ReadDeviceInfoResponse{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + 24,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSReadDeviceInfo,
+			StateFlags: StateADSCommand | StateResponse,
+			Length:     24,
+		},
+		Result:       0x1,
+		MajorVersion: 3,
+		MinorVersion: 1,
+		VersionBuild: 4024,
+		DeviceName:   "Plc30 App",
+	}
+	verify.Values(t, "", got, want)
+}
+
+func TestReadDeviceInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		p    codec
+		b    []byte
+	}{
+		{
+			name: "ReadDeviceInfoRequest",
+			p: &ReadDeviceInfoRequest{
+				tcpHeader: tcpHeaderN(0),
+				amsHeader: amsHeaderN(0),
+			},
+			b: frameBytes(nil),
+		},
+		{
+			name: "ReadDeviceInfoResponse",
+			p: &ReadDeviceInfoResponse{
+				tcpHeader:    tcpHeaderN(24),
+				amsHeader:    amsHeaderN(24),
+				Result:       0x12345678,
+				MajorVersion: 0x3,
+				MinorVersion: 0x1,
+				VersionBuild: 0x0fb8,
+				DeviceName:   "Plc30 App",
+			},
+			b: func() []byte {
+				data := []byte{
+					0x78, 0x56, 0x34, 0x12, // Result
+					0x03,       // MajorVersion
+					0x01,       // MinorVersion
+					0xb8, 0x0f, // VersionBuild
+					'P', 'l', 'c', '3', '0', ' ', 'A', 'p', 'p', 0, 0, 0, 0, 0, 0, 0, // DeviceName
+				}
+				return frameBytes(data)
+			}(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codecTest(t, tt.p, tt.b)
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ams
+
+import "fmt"
+
+// ADS return codes of the AMS header and of the result field of the
+// responses. NoError and TargetMachineNotFound are defined above.
+//
+// https://infosys.beckhoff.com/english.php?content=../content/1033/tc3_ads_intro/374277003.html&id=
+const (
+	InternalError        = 0x1
+	MailboxFull          = 0x4
+	TargetPortNotFound   = 0x6
+	UnknownCmdID         = 0x8
+	PortNotConnected     = 0xD
+	InvalidAMSLength     = 0xE
+	InvalidAMSNetID      = 0xF
+	PortDisabled         = 0x12
+	PortAlreadyConnected = 0x13
+	SyncTimeout          = 0x15
+	NoMemory             = 0x19
+	TCPSendError         = 0x1A
+	HostUnreachable      = 0x1B
+
+	RouterMailboxFull    = 0x502
+	RouterNotInitialized = 0x505
+	RouterNotRegistered  = 0x507
+	RouterNotActivated   = 0x50A
+
+	DeviceError                 = 0x700
+	DeviceServiceNotSupported   = 0x701
+	DeviceInvalidGroup          = 0x702
+	DeviceInvalidOffset         = 0x703
+	DeviceInvalidAccess         = 0x704
+	DeviceInvalidSize           = 0x705
+	DeviceInvalidData           = 0x706
+	DeviceNotReady              = 0x707
+	DeviceBusy                  = 0x708
+	DeviceNoMemory              = 0x70A
+	DeviceInvalidParam          = 0x70B
+	DeviceNotFound              = 0x70C
+	DeviceSymbolNotFound        = 0x710
+	DeviceSymbolVersionInvalid  = 0x711
+	DeviceInvalidState          = 0x712
+	DeviceTransModeNotSupported = 0x713
+	DeviceNotifyHandleInvalid   = 0x714
+	DeviceNotInitialized        = 0x718
+	DeviceTimeout               = 0x719
+	DevicePending               = 0x71E
+	DeviceAborted               = 0x71F
+	DeviceInvalidArrayIndex     = 0x721
+	DeviceSymbolNotActive       = 0x722
+	DeviceAccessDenied          = 0x723
+	DeviceLicenseNotFound       = 0x724
+	ClientSyncTimeout           = 0x745
+	ClientPortNotOpen           = 0x748
+)
+
+var errorNames = map[uint32]string{
+	NoError:                     "NoError",
+	InternalError:               "InternalError",
+	MailboxFull:                 "MailboxFull",
+	TargetPortNotFound:          "TargetPortNotFound",
+	TargetMachineNotFound:       "TargetMachineNotFound",
+	UnknownCmdID:                "UnknownCmdID",
+	PortNotConnected:            "PortNotConnected",
+	InvalidAMSLength:            "InvalidAMSLength",
+	InvalidAMSNetID:             "InvalidAMSNetID",
+	PortDisabled:                "PortDisabled",
+	PortAlreadyConnected:        "PortAlreadyConnected",
+	SyncTimeout:                 "SyncTimeout",
+	NoMemory:                    "NoMemory",
+	TCPSendError:                "TCPSendError",
+	HostUnreachable:             "HostUnreachable",
+	RouterMailboxFull:           "RouterMailboxFull",
+	RouterNotInitialized:        "RouterNotInitialized",
+	RouterNotRegistered:         "RouterNotRegistered",
+	RouterNotActivated:          "RouterNotActivated",
+	DeviceError:                 "DeviceError",
+	DeviceServiceNotSupported:   "DeviceServiceNotSupported",
+	DeviceInvalidGroup:          "DeviceInvalidGroup",
+	DeviceInvalidOffset:         "DeviceInvalidOffset",
+	DeviceInvalidAccess:         "DeviceInvalidAccess",
+	DeviceInvalidSize:           "DeviceInvalidSize",
+	DeviceInvalidData:           "DeviceInvalidData",
+	DeviceNotReady:              "DeviceNotReady",
+	DeviceBusy:                  "DeviceBusy",
+	DeviceNoMemory:              "DeviceNoMemory",
+	DeviceInvalidParam:          "DeviceInvalidParam",
+	DeviceNotFound:              "DeviceNotFound",
+	DeviceSymbolNotFound:        "DeviceSymbolNotFound",
+	DeviceSymbolVersionInvalid:  "DeviceSymbolVersionInvalid",
+	DeviceInvalidState:          "DeviceInvalidState",
+	DeviceTransModeNotSupported: "DeviceTransModeNotSupported",
+	DeviceNotifyHandleInvalid:   "DeviceNotifyHandleInvalid",
+	DeviceNotInitialized:        "DeviceNotInitialized",
+	DeviceTimeout:               "DeviceTimeout",
+	DevicePending:               "DevicePending",
+	DeviceAborted:               "DeviceAborted",
+	DeviceInvalidArrayIndex:     "DeviceInvalidArrayIndex",
+	DeviceSymbolNotActive:       "DeviceSymbolNotActive",
+	DeviceAccessDenied:          "DeviceAccessDenied",
+	DeviceLicenseNotFound:       "DeviceLicenseNotFound",
+	ClientSyncTimeout:           "ClientSyncTimeout",
+	ClientPortNotOpen:           "ClientPortNotOpen",
+}
+
+// ErrorName returns the name of an ADS return code.
+func ErrorName(code uint32) string {
+	if s, ok := errorNames[code]; ok {
+		return s
+	}
+	return fmt.Sprintf("Error(0x%x)", code)
+}
@@ -0,0 +1,16 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ams
+
+import (
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestErrorName(t *testing.T) {
+	verify.Values(t, "known", ErrorName(DeviceBusy), "DeviceBusy")
+	verify.Values(t, "unknown", ErrorName(0x7ff), "Error(0x7ff)")
+}
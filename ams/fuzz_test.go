@@ -0,0 +1,157 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package ams
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// fuzzDecode decodes the input into a new value of the type of p
+// and verifies that a successfully decoded value survives an
+// encode and decode round trip.
+func fuzzDecode(f *testing.F, p codec, seeds ...[]byte) {
+	for _, b := range seeds {
+		f.Add(b)
+	}
+	typ := reflect.TypeOf(p).Elem()
+	f.Fuzz(func(t *testing.T, b []byte) {
+		x := reflect.New(typ).Interface().(codec)
+		buf := NewBuffer(b)
+		if err := x.Decode(buf); err != nil {
+			return
+		}
+
+		var out Buffer
+		if err := x.Encode(&out); err != nil {
+			t.Fatalf("encode: %s", err)
+		}
+		y := reflect.New(typ).Interface().(codec)
+		if err := y.Decode(NewBuffer(out.Bytes())); err != nil {
+			t.Fatalf("decode: %s", err)
+		}
+		if !reflect.DeepEqual(x, y) {
+			t.Fatalf("got %#v want %#v", y, x)
+		}
+	})
+}
+
+func FuzzAddr(f *testing.F) {
+	fuzzDecode(f, &Addr{}, amsHeaderBytes[:8])
+}
+
+func FuzzTCPHeader(f *testing.F) {
+	fuzzDecode(f, &TCPHeader{}, tcpHeaderBytes)
+}
+
+func FuzzAMSHeader(f *testing.F) {
+	fuzzDecode(f, &AMSHeader{}, amsHeaderBytes)
+}
+
+func FuzzHeader(f *testing.F) {
+	fuzzDecode(f, &Header{}, frameBytes(nil), frameBytes([]byte{1, 2, 3}))
+}
+
+func FuzzReadRequest(f *testing.F) {
+	fuzzDecode(f, &ReadRequest{}, frameBytes(make([]byte, 12)))
+}
+
+func FuzzReadResponse(f *testing.F) {
+	fuzzDecode(f, &ReadResponse{}, frameBytes([]byte{0, 0, 0, 0, 2, 0, 0, 0, 1, 2}))
+}
+
+func FuzzWriteRequest(f *testing.F) {
+	fuzzDecode(f, &WriteRequest{}, frameBytes([]byte{0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1}))
+}
+
+func FuzzWriteResponse(f *testing.F) {
+	fuzzDecode(f, &WriteResponse{}, frameBytes(make([]byte, 4)))
+}
+
+func FuzzReadWriteRequest(f *testing.F) {
+	fuzzDecode(f, &ReadWriteRequest{}, frameBytes([]byte{0, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 1, 0, 0, 0, 1}))
+}
+
+func FuzzReadWriteResponse(f *testing.F) {
+	fuzzDecode(f, &ReadWriteResponse{}, frameBytes([]byte{0, 0, 0, 0, 2, 0, 0, 0, 1, 2}))
+}
+
+func FuzzReadStateRequest(f *testing.F) {
+	fuzzDecode(f, &ReadStateRequest{}, frameBytes(nil))
+}
+
+func FuzzReadStateResponse(f *testing.F) {
+	fuzzDecode(f, &ReadStateResponse{}, frameBytes(make([]byte, 8)))
+}
+
+func FuzzReadDeviceInfoRequest(f *testing.F) {
+	fuzzDecode(f, &ReadDeviceInfoRequest{}, frameBytes(nil))
+}
+
+func FuzzReadDeviceInfoResponse(f *testing.F) {
+	fuzzDecode(f, &ReadDeviceInfoResponse{}, frameBytes(append([]byte{0, 0, 0, 0, 3, 1, 0xb8, 0x0f}, "Plc30 App\x00\x00\x00\x00\x00\x00\x00"...)))
+}
+
+func FuzzWriteControlRequest(f *testing.F) {
+	fuzzDecode(f, &WriteControlRequest{}, frameBytes([]byte{5, 0, 0, 0, 1, 0, 0, 0, 1}))
+}
+
+func FuzzWriteControlResponse(f *testing.F) {
+	fuzzDecode(f, &WriteControlResponse{}, frameBytes(make([]byte, 4)))
+}
+
+func FuzzAddDeviceNotificationRequest(f *testing.F) {
+	fuzzDecode(f, &AddDeviceNotificationRequest{}, frameBytes(make([]byte, 40)))
+}
+
+func FuzzAddDeviceNotificationResponse(f *testing.F) {
+	fuzzDecode(f, &AddDeviceNotificationResponse{}, frameBytes(make([]byte, 8)))
+}
+
+func FuzzDeleteDeviceNotificationRequest(f *testing.F) {
+	fuzzDecode(f, &DeleteDeviceNotificationRequest{}, frameBytes(make([]byte, 4)))
+}
+
+func FuzzDeleteDeviceNotificationResponse(f *testing.F) {
+	fuzzDecode(f, &DeleteDeviceNotificationResponse{}, frameBytes(make([]byte, 4)))
+}
+
+func FuzzDeviceNotificationRequest(f *testing.F) {
+	fuzzDecode(f, &DeviceNotificationRequest{}, frameBytes([]byte{
+		0x17, 0, 0, 0, 1, 0, 0, 0,
+		1, 2, 3, 4, 5, 6, 7, 8, 1, 0, 0, 0,
+		1, 0, 0, 0, 2, 0, 0, 0, 0xa, 0xb,
+	}))
+}
+
+func FuzzRawPacket(f *testing.F) {
+	fuzzDecode(f, &RawPacket{}, frameBytes([]byte{1, 2, 3}))
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add(frameBytes(nil))
+	f.Add(frameBytes(make([]byte, 12)))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		Decode(b)
+	})
+}
+
+func FuzzReadFrame(f *testing.F) {
+	f.Add(frameBytes(nil), uint32(DefaultMaxFrameLen))
+	f.Add(frameBytes([]byte{1, 2, 3}), uint32(34))
+	f.Fuzz(func(t *testing.T, b []byte, max uint32) {
+		frame, err := ReadFrame(bytes.NewReader(b), max)
+		if err != nil {
+			return
+		}
+		if len(frame) > tcpHeaderLen+int(max) || !bytes.HasPrefix(b, frame) {
+			t.Fatalf("invalid frame % x", frame)
+		}
+	})
+}
@@ -4,6 +4,17 @@
 
 package ams
 
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrInvalidLength is returned when the length fields of a packet
+// do not match its content or exceed the maximum frame size.
+var ErrInvalidLength = errors.New("invalid length")
+
 // TCPHeader is the AMS/TCP packet header.
 //
 // https://infosys.beckhoff.com/english.php?content=../content/1033/tf7xxx_tc3_vision/27021602095817483.html&id=
@@ -76,5 +87,56 @@ func (r *Header) Encode(b *Buffer) error {
 func (r *Header) Decode(b *Buffer) error {
 	b.ReadStruct(&r.TCPHeader)
 	b.ReadStruct(&r.AMSHeader)
-	return b.Err()
+	if b.Err() != nil {
+		return b.Err()
+	}
+	if r.TCPHeader.Length != amsHeaderLen+r.AMSHeader.Length {
+		return fmt.Errorf("%w: tcp header %d ams header %d", ErrInvalidLength, r.TCPHeader.Length, r.AMSHeader.Length)
+	}
+	return nil
+}
+
+// checkLength verifies that the length fields of the headers
+// match the length n of the decoded payload.
+func checkLength(b *Buffer, tcp *TCPHeader, ams *AMSHeader, n int) error {
+	if b.Err() != nil {
+		return b.Err()
+	}
+	if tcp.Length != amsHeaderLen+ams.Length || int64(ams.Length) != int64(n) {
+		return fmt.Errorf("%w: tcp header %d ams header %d payload %d", ErrInvalidLength, tcp.Length, ams.Length, n)
+	}
+	return nil
+}
+
+// tcpHeaderLen is the length of the AMS/TCP header.
+const tcpHeaderLen = 6
+
+// HeaderLen is the length of the AMS/TCP and AMS header.
+const HeaderLen = tcpHeaderLen + amsHeaderLen
+
+// DefaultMaxFrameLen is the default for the maximum
+// length of an AMS/TCP frame.
+const DefaultMaxFrameLen = 8 << 20
+
+// ReadFrame reads a single AMS/TCP frame including the
+// AMS/TCP header from r. Frames with a length which exceeds
+// max or which are too short for the AMS header are rejected.
+func ReadFrame(r io.Reader, max uint32) ([]byte, error) {
+	hdr := make([]byte, tcpHeaderLen)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(hdr[2:])
+	if n < amsHeaderLen || n > max {
+		return nil, fmt.Errorf("%w: frame length %d", ErrInvalidLength, n)
+	}
+	b := make([]byte, tcpHeaderLen+int(n))
+	copy(b, hdr)
+	if _, err := io.ReadFull(r, b[tcpHeaderLen:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
 }
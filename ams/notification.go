@@ -0,0 +1,364 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ams
+
+import "time"
+
+// Transmission modes for device notifications.
+//
+// https://infosys.beckhoff.com/english.php?content=../content/1033/tc3_adsdll2/9007199379576075.html&id=
+const (
+	TransModeNoTrans         = 0
+	TransModeClientCycle     = 1
+	TransModeClientOnChange  = 2
+	TransModeServerCycle     = 3
+	TransModeServerOnChange  = 4
+	TransModeServerCycle2    = 5
+	TransModeServerOnChange2 = 6
+	TransModeClient1Req      = 10
+)
+
+// AddDeviceNotificationRequest is the packet for an AMS AddDeviceNotification request.
+type AddDeviceNotificationRequest struct {
+	tcpHeader        TCPHeader
+	amsHeader        AMSHeader
+	IndexGroup       uint32
+	IndexOffset      uint32
+	Length           uint32
+	TransmissionMode uint32
+	MaxDelay         uint32 // in ms
+	CycleTime        uint32 // in ms
+}
+
+func NewAddDeviceNotificationRequest(target, sender Addr, group, offset, length, mode, maxDelay, cycleTime uint32) *AddDeviceNotificationRequest {
+	return &AddDeviceNotificationRequest{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + 40,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSAddDeviceNotification,
+			StateFlags: StateADSCommand,
+			Length:     40,
+		},
+		IndexGroup:       group,
+		IndexOffset:      offset,
+		Length:           length,
+		TransmissionMode: mode,
+		MaxDelay:         maxDelay,
+		CycleTime:        cycleTime,
+	}
+}
+
+func (r *AddDeviceNotificationRequest) Header() *AMSHeader {
+	return &r.amsHeader
+}
+
+func (r *AddDeviceNotificationRequest) Encode(b *Buffer) error {
+	b.WriteStruct(&r.tcpHeader)
+	b.WriteStruct(&r.amsHeader)
+	b.WriteUint32(r.IndexGroup)
+	b.WriteUint32(r.IndexOffset)
+	b.WriteUint32(r.Length)
+	b.WriteUint32(r.TransmissionMode)
+	b.WriteUint32(r.MaxDelay)
+	b.WriteUint32(r.CycleTime)
+	b.Write(make([]byte, 16)) // reserved
+	return b.Err()
+}
+
+func (r *AddDeviceNotificationRequest) Decode(b *Buffer) error {
+	b.ReadStruct(&r.tcpHeader)
+	b.ReadStruct(&r.amsHeader)
+	r.IndexGroup = b.ReadUint32()
+	r.IndexOffset = b.ReadUint32()
+	r.Length = b.ReadUint32()
+	r.TransmissionMode = b.ReadUint32()
+	r.MaxDelay = b.ReadUint32()
+	r.CycleTime = b.ReadUint32()
+	b.ReadN(16) // reserved
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, 40)
+}
+
+// AddDeviceNotificationResponse is the packet for an AMS AddDeviceNotification response.
+type AddDeviceNotificationResponse struct {
+	tcpHeader          TCPHeader
+	amsHeader          AMSHeader
+	Result             uint32
+	NotificationHandle uint32
+}
+
+func NewAddDeviceNotificationResponse(target, sender Addr, result, handle uint32) *AddDeviceNotificationResponse {
+	return &AddDeviceNotificationResponse{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + 8,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSAddDeviceNotification,
+			StateFlags: StateADSCommand | StateResponse,
+			Length:     8,
+		},
+		Result:             result,
+		NotificationHandle: handle,
+	}
+}
+
+func (r *AddDeviceNotificationResponse) Header() *AMSHeader {
+	return &r.amsHeader
+}
+
+func (r *AddDeviceNotificationResponse) Encode(b *Buffer) error {
+	b.WriteStruct(&r.tcpHeader)
+	b.WriteStruct(&r.amsHeader)
+	b.WriteUint32(r.Result)
+	b.WriteUint32(r.NotificationHandle)
+	return b.Err()
+}
+
+func (r *AddDeviceNotificationResponse) Decode(b *Buffer) error {
+	b.ReadStruct(&r.tcpHeader)
+	b.ReadStruct(&r.amsHeader)
+	r.Result = b.ReadUint32()
+	r.NotificationHandle = b.ReadUint32()
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, 8)
+}
+
+// DeleteDeviceNotificationRequest is the packet for an AMS DeleteDeviceNotification request.
+type DeleteDeviceNotificationRequest struct {
+	tcpHeader          TCPHeader
+	amsHeader          AMSHeader
+	NotificationHandle uint32
+}
+
+func NewDeleteDeviceNotificationRequest(target, sender Addr, handle uint32) *DeleteDeviceNotificationRequest {
+	return &DeleteDeviceNotificationRequest{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + 4,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSDeleteDeviceNotification,
+			StateFlags: StateADSCommand,
+			Length:     4,
+		},
+		NotificationHandle: handle,
+	}
+}
+
+func (r *DeleteDeviceNotificationRequest) Header() *AMSHeader {
+	return &r.amsHeader
+}
+
+func (r *DeleteDeviceNotificationRequest) Encode(b *Buffer) error {
+	b.WriteStruct(&r.tcpHeader)
+	b.WriteStruct(&r.amsHeader)
+	b.WriteUint32(r.NotificationHandle)
+	return b.Err()
+}
+
+func (r *DeleteDeviceNotificationRequest) Decode(b *Buffer) error {
+	b.ReadStruct(&r.tcpHeader)
+	b.ReadStruct(&r.amsHeader)
+	r.NotificationHandle = b.ReadUint32()
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, 4)
+}
+
+// DeleteDeviceNotificationResponse is the packet for an AMS DeleteDeviceNotification response.
+type DeleteDeviceNotificationResponse struct {
+	tcpHeader TCPHeader
+	amsHeader AMSHeader
+	Result    uint32
+}
+
+func NewDeleteDeviceNotificationResponse(target, sender Addr, result uint32) *DeleteDeviceNotificationResponse {
+	return &DeleteDeviceNotificationResponse{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + 4,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSDeleteDeviceNotification,
+			StateFlags: StateADSCommand | StateResponse,
+			Length:     4,
+		},
+		Result: result,
+	}
+}
+
+func (r *DeleteDeviceNotificationResponse) Header() *AMSHeader {
+	return &r.amsHeader
+}
+
+func (r *DeleteDeviceNotificationResponse) Encode(b *Buffer) error {
+	b.WriteStruct(&r.tcpHeader)
+	b.WriteStruct(&r.amsHeader)
+	b.WriteUint32(r.Result)
+	return b.Err()
+}
+
+func (r *DeleteDeviceNotificationResponse) Decode(b *Buffer) error {
+	b.ReadStruct(&r.tcpHeader)
+	b.ReadStruct(&r.amsHeader)
+	r.Result = b.ReadUint32()
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, 4)
+}
+
+// NotificationSample is the value of a single notification.
+type NotificationSample struct {
+	NotificationHandle uint32
+	Data               []byte
+}
+
+func (s *NotificationSample) Encode(b *Buffer) error {
+	b.WriteUint32(s.NotificationHandle)
+	b.WriteUint32(uint32(len(s.Data)))
+	b.Write(s.Data)
+	return b.Err()
+}
+
+func (s *NotificationSample) Decode(b *Buffer) error {
+	s.NotificationHandle = b.ReadUint32()
+	s.Data = b.ReadN(int(b.ReadUint32()))
+	return b.Err()
+}
+
+func (s *NotificationSample) len() int {
+	return 8 + len(s.Data)
+}
+
+// minSampleLen is the encoded length of an empty sample.
+const minSampleLen = 8
+
+// NotificationStamp contains all samples with the same timestamp.
+type NotificationStamp struct {
+	Timestamp uint64 // Windows FILETIME
+	Samples   []NotificationSample
+}
+
+// fileTimeEpoch is the offset between the Windows FILETIME
+// epoch (1601-01-01) and the unix epoch in 100ns intervals.
+const fileTimeEpoch = 116444736000000000
+
+// Time returns the timestamp as time.Time.
+func (s *NotificationStamp) Time() time.Time {
+	return time.Unix(0, int64(s.Timestamp-fileTimeEpoch)*100).UTC()
+}
+
+func (s *NotificationStamp) Encode(b *Buffer) error {
+	b.WriteUint64(s.Timestamp)
+	b.WriteUint32(uint32(len(s.Samples)))
+	for i := range s.Samples {
+		b.WriteStruct(&s.Samples[i])
+	}
+	return b.Err()
+}
+
+func (s *NotificationStamp) Decode(b *Buffer) error {
+	s.Timestamp = b.ReadUint64()
+	n := int(b.ReadUint32())
+	if b.Err() != nil {
+		return b.Err()
+	}
+	if n > b.Len()/minSampleLen {
+		return ErrInvalidLength
+	}
+	s.Samples = make([]NotificationSample, n)
+	for i := range s.Samples {
+		b.ReadStruct(&s.Samples[i])
+	}
+	return b.Err()
+}
+
+func (s *NotificationStamp) len() int {
+	n := 12
+	for i := range s.Samples {
+		n += s.Samples[i].len()
+	}
+	return n
+}
+
+// minStampLen is the encoded length of a stamp without samples.
+const minStampLen = 12
+
+// DeviceNotificationRequest is the packet for an AMS DeviceNotification
+// request. The server sends it to the client and it has no response.
+type DeviceNotificationRequest struct {
+	tcpHeader TCPHeader
+	amsHeader AMSHeader
+	Length    uint32
+	Stamps    []NotificationStamp
+}
+
+func NewDeviceNotificationRequest(target, sender Addr, stamps []NotificationStamp) *DeviceNotificationRequest {
+	n := uint32(4)
+	for i := range stamps {
+		n += uint32(stamps[i].len())
+	}
+	return &DeviceNotificationRequest{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + n + 4,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSDeviceNotification,
+			StateFlags: StateADSCommand,
+			Length:     n + 4,
+		},
+		Length: n,
+		Stamps: stamps,
+	}
+}
+
+func (r *DeviceNotificationRequest) Header() *AMSHeader {
+	return &r.amsHeader
+}
+
+func (r *DeviceNotificationRequest) Encode(b *Buffer) error {
+	b.WriteStruct(&r.tcpHeader)
+	b.WriteStruct(&r.amsHeader)
+	b.WriteUint32(r.Length)
+	b.WriteUint32(uint32(len(r.Stamps)))
+	for i := range r.Stamps {
+		b.WriteStruct(&r.Stamps[i])
+	}
+	return b.Err()
+}
+
+func (r *DeviceNotificationRequest) Decode(b *Buffer) error {
+	b.ReadStruct(&r.tcpHeader)
+	b.ReadStruct(&r.amsHeader)
+	r.Length = b.ReadUint32()
+	n := int(b.ReadUint32())
+	if b.Err() != nil {
+		return b.Err()
+	}
+	if n > b.Len()/minStampLen {
+		return ErrInvalidLength
+	}
+	r.Stamps = make([]NotificationStamp, n)
+	size := 4
+	for i := range r.Stamps {
+		b.ReadStruct(&r.Stamps[i])
+		size += r.Stamps[i].len()
+	}
+	if err := checkLength(b, &r.tcpHeader, &r.amsHeader, 4+size); err != nil {
+		return err
+	}
+	if int64(r.Length) != int64(size) {
+		return ErrInvalidLength
+	}
+	return nil
+}
+
+// IsDeviceNotificationRequest returns true if the packet is a DeviceNotification request.
+func IsDeviceNotificationRequest(h AMSHeader) bool {
+	return h.CmdID == CmdADSDeviceNotification && !HasState(h, StateResponse)
+}
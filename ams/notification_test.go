@@ -0,0 +1,160 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ams
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestNewDeviceNotificationRequest(t *testing.T) {
+	stamps := []NotificationStamp{
+		{
+			Timestamp: 0x1,
+			Samples: []NotificationSample{
+				{NotificationHandle: 0x2, Data: []byte{0x3, 0x4}},
+			},
+		},
+	}
+	got := NewDeviceNotificationRequest(target, sender, stamps)
+	want := &DeviceNotificationRequest{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + 30,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSDeviceNotification,
+			StateFlags: StateADSCommand,
+			Length:     30,
+		},
+		Length: 26,
+		Stamps: stamps,
+	}
+	verify.Values(t, "", got, want)
+}
+
+func TestNotificationStampTime(t *testing.T) {
+	s := NotificationStamp{Timestamp: 132539328000000000}
+	verify.Values(t, "", s.Time(), time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+}
+
+func TestNotification(t *testing.T) {
+	tests := []struct {
+		name string
+		p    codec
+		b    []byte
+	}{
+		{
+			name: "AddDeviceNotificationRequest",
+			p: &AddDeviceNotificationRequest{
+				tcpHeader:        tcpHeaderN(40),
+				amsHeader:        amsHeaderN(40),
+				IndexGroup:       0x12345678,
+				IndexOffset:      0x23456789,
+				Length:           0x4,
+				TransmissionMode: TransModeServerOnChange,
+				MaxDelay:         0x10,
+				CycleTime:        0x20,
+			},
+			b: func() []byte {
+				data := []byte{
+					0x78, 0x56, 0x34, 0x12, // IndexGroup
+					0x89, 0x67, 0x45, 0x23, // IndexOffset
+					0x04, 0x00, 0x00, 0x00, // Length
+					0x04, 0x00, 0x00, 0x00, // TransmissionMode
+					0x10, 0x00, 0x00, 0x00, // MaxDelay
+					0x20, 0x00, 0x00, 0x00, // CycleTime
+					0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // Reserved
+				}
+				return frameBytes(data)
+			}(),
+		},
+		{
+			name: "AddDeviceNotificationResponse",
+			p: &AddDeviceNotificationResponse{
+				tcpHeader:          tcpHeaderN(8),
+				amsHeader:          amsHeaderN(8),
+				Result:             0x12345678,
+				NotificationHandle: 0x23456789,
+			},
+			b: func() []byte {
+				data := []byte{
+					0x78, 0x56, 0x34, 0x12, // Result
+					0x89, 0x67, 0x45, 0x23, // NotificationHandle
+				}
+				return frameBytes(data)
+			}(),
+		},
+		{
+			name: "DeleteDeviceNotificationRequest",
+			p: &DeleteDeviceNotificationRequest{
+				tcpHeader:          tcpHeaderN(4),
+				amsHeader:          amsHeaderN(4),
+				NotificationHandle: 0x12345678,
+			},
+			b: func() []byte {
+				data := []byte{
+					0x78, 0x56, 0x34, 0x12, // NotificationHandle
+				}
+				return frameBytes(data)
+			}(),
+		},
+		{
+			name: "DeleteDeviceNotificationResponse",
+			p: &DeleteDeviceNotificationResponse{
+				tcpHeader: tcpHeaderN(4),
+				amsHeader: amsHeaderN(4),
+				Result:    0x12345678,
+			},
+			b: func() []byte {
+				data := []byte{
+					0x78, 0x56, 0x34, 0x12, // Result
+				}
+				return frameBytes(data)
+			}(),
+		},
+		{
+			name: "DeviceNotificationRequest",
+			p: &DeviceNotificationRequest{
+				tcpHeader: tcpHeaderN(39),
+				amsHeader: amsHeaderN(39),
+				Length:    35,
+				Stamps: []NotificationStamp{
+					{
+						Timestamp: 0x0102030405060708,
+						Samples: []NotificationSample{
+							{NotificationHandle: 0x1, Data: []byte{0xa}},
+							{NotificationHandle: 0x2, Data: []byte{0xb, 0xc}},
+						},
+					},
+				},
+			},
+			b: func() []byte {
+				data := []byte{
+					0x23, 0x00, 0x00, 0x00, // Length
+					0x01, 0x00, 0x00, 0x00, // Stamps
+					0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01, // Timestamp
+					0x02, 0x00, 0x00, 0x00, // Samples
+					0x01, 0x00, 0x00, 0x00, // NotificationHandle
+					0x01, 0x00, 0x00, 0x00, // SampleSize
+					0x0a,                   // Data
+					0x02, 0x00, 0x00, 0x00, // NotificationHandle
+					0x02, 0x00, 0x00, 0x00, // SampleSize
+					0x0b, 0x0c, // Data
+				}
+				return frameBytes(data)
+			}(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codecTest(t, tt.p, tt.b)
+		})
+	}
+}
@@ -50,7 +50,7 @@ func (r *ReadRequest) Decode(b *Buffer) error {
 	r.IndexGroup = b.ReadUint32()
 	r.IndexOffset = b.ReadUint32()
 	r.Length = b.ReadUint32()
-	return b.Err()
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, 12)
 }
 
 // ReadResponse is the packet for an AMS Read response.
@@ -62,6 +62,25 @@ type ReadResponse struct {
 	Data      []byte
 }
 
+func NewReadResponse(target, sender Addr, result uint32, data []byte) *ReadResponse {
+	dataLen := uint32(len(data))
+	return &ReadResponse{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + dataLen + 8,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSRead,
+			StateFlags: StateADSCommand | StateResponse,
+			Length:     dataLen + 8,
+		},
+		Result: result,
+		Length: dataLen,
+		Data:   data,
+	}
+}
+
 func (r *ReadResponse) Header() *AMSHeader {
 	return &r.amsHeader
 }
@@ -81,7 +100,7 @@ func (r *ReadResponse) Decode(b *Buffer) error {
 	r.Result = b.ReadUint32()
 	r.Length = b.ReadUint32()
 	r.Data = b.ReadN(int(r.Length))
-	return b.Err()
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, 8+len(r.Data))
 }
 
 // IsReadResponse returns true if the packet is a read response.
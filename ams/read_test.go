@@ -26,6 +26,26 @@ func TestNewReadRequest(t *testing.T) {
 	verify.Values(t, "", got, want)
 }
 
+func TestNewReadResponse(t *testing.T) {
+	got := NewReadResponse(target, sender, 0x1, []byte{0x2, 0x3})
+	want := &ReadResponse{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + 10,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSRead,
+			StateFlags: StateADSCommand | StateResponse,
+			Length:     10,
+		},
+		Result: 0x1,
+		Length: 0x2,
+		Data:   []byte{0x2, 0x3},
+	}
+	verify.Values(t, "", got, want)
+}
+
 func TestRead(t *testing.T) {
 	tests := []struct {
 		name string
@@ -35,8 +55,8 @@ func TestRead(t *testing.T) {
 		{
 			name: "ReadRequest",
 			p: &ReadRequest{
-				tcpHeader:   tcpHeader,
-				amsHeader:   amsHeader,
+				tcpHeader:   tcpHeaderN(12),
+				amsHeader:   amsHeaderN(12),
 				IndexGroup:  0x12345678,
 				IndexOffset: 0x23456789,
 				Length:      0x34567890,
@@ -47,14 +67,14 @@ func TestRead(t *testing.T) {
 					0x89, 0x67, 0x45, 0x23, // IndexOffset
 					0x90, 0x78, 0x56, 0x34, // Length
 				}
-				return append(append(tcpHeaderBytes, amsHeaderBytes...), data...)
+				return frameBytes(data)
 			}(),
 		},
 		{
 			name: "ReadResponse",
 			p: &ReadResponse{
-				tcpHeader: tcpHeader,
-				amsHeader: amsHeader,
+				tcpHeader: tcpHeaderN(11),
+				amsHeader: amsHeaderN(11),
 				Result:    0x12345678,
 				Length:    0x3,
 				Data:      []byte{0x00, 0x01, 0x02},
@@ -65,7 +85,7 @@ func TestRead(t *testing.T) {
 					0x03, 0x00, 0x00, 0x00, // Length
 					0x00, 0x01, 0x02, // Data
 				}
-				return append(append(tcpHeaderBytes, amsHeaderBytes...), data...)
+				return frameBytes(data)
 			}(),
 		},
 	}
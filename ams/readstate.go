@@ -32,7 +32,7 @@ func (r *ReadStateRequest) Encode(b *Buffer) error {
 func (r *ReadStateRequest) Decode(b *Buffer) error {
 	b.ReadStruct(&r.tcpHeader)
 	b.ReadStruct(&r.amsHeader)
-	return b.Err()
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, 0)
 }
 
 func IsReadStateRequest(h AMSHeader) bool {
@@ -83,5 +83,5 @@ func (r *ReadStateResponse) Decode(b *Buffer) error {
 	r.Result = b.ReadUint32()
 	r.ADSState = b.ReadUint16()
 	r.DeviceState = b.ReadUint16()
-	return b.Err()
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, 8)
 }
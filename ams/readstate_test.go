@@ -35,16 +35,16 @@ func TestReadState(t *testing.T) {
 		{
 			name: "ReadStateRequest",
 			p: &ReadStateRequest{
-				tcpHeader: tcpHeader,
-				amsHeader: amsHeader,
+				tcpHeader: tcpHeaderN(0),
+				amsHeader: amsHeaderN(0),
 			},
-			b: append(tcpHeaderBytes, amsHeaderBytes...),
+			b: frameBytes(nil),
 		},
 		{
 			name: "ReadStateResponse",
 			p: &ReadStateResponse{
-				tcpHeader:   tcpHeader,
-				amsHeader:   amsHeader,
+				tcpHeader:   tcpHeaderN(8),
+				amsHeader:   amsHeaderN(8),
 				Result:      0x12345678,
 				ADSState:    0x5678,
 				DeviceState: 0x9012,
@@ -55,7 +55,7 @@ func TestReadState(t *testing.T) {
 					0x78, 0x56, // ADSState
 					0x12, 0x90, // DeviceState
 				}
-				return append(append(tcpHeaderBytes, amsHeaderBytes...), data...)
+				return frameBytes(data)
 			}(),
 		},
 	}
@@ -59,7 +59,7 @@ func (r *ReadWriteRequest) Decode(b *Buffer) error {
 	r.ReadLength = b.ReadUint32()
 	r.WriteLength = b.ReadUint32()
 	r.Data = b.ReadN(int(r.WriteLength))
-	return b.Err()
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, 16+len(r.Data))
 }
 
 // ReadWriteResponse is the packet for an AMS ReadWrite response.
@@ -71,6 +71,25 @@ type ReadWriteResponse struct {
 	Data      []byte
 }
 
+func NewReadWriteResponse(target, sender Addr, result uint32, data []byte) *ReadWriteResponse {
+	dataLen := uint32(len(data))
+	return &ReadWriteResponse{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + dataLen + 8,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSReadWrite,
+			StateFlags: StateADSCommand | StateResponse,
+			Length:     dataLen + 8,
+		},
+		Result: result,
+		Length: dataLen,
+		Data:   data,
+	}
+}
+
 func (r *ReadWriteResponse) Header() *AMSHeader {
 	return &r.amsHeader
 }
@@ -90,10 +109,10 @@ func (r *ReadWriteResponse) Decode(b *Buffer) error {
 	r.Result = b.ReadUint32()
 	r.Length = b.ReadUint32()
 	r.Data = b.ReadN(int(r.Length))
-	return b.Err()
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, 8+len(r.Data))
 }
 
-//
+// IsReadWriteResponse returns true if the packet is a ReadWrite response.
 func IsReadWriteResponse(h AMSHeader) bool {
 	return h.CmdID == CmdADSReadWrite && HasState(h, StateResponse)
 }
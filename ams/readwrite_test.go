@@ -28,6 +28,26 @@ func TestNewReadWriteRequest(t *testing.T) {
 	verify.Values(t, "", got, want)
 }
 
+func TestNewReadWriteResponse(t *testing.T) {
+	got := NewReadWriteResponse(target, sender, 0x1, []byte{0x2, 0x3})
+	want := &ReadWriteResponse{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + 10,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSReadWrite,
+			StateFlags: StateADSCommand | StateResponse,
+			Length:     10,
+		},
+		Result: 0x1,
+		Length: 0x2,
+		Data:   []byte{0x2, 0x3},
+	}
+	verify.Values(t, "", got, want)
+}
+
 func TestReadWrite(t *testing.T) {
 	tests := []struct {
 		name string
@@ -37,8 +57,8 @@ func TestReadWrite(t *testing.T) {
 		{
 			name: "ReadWriteRequest",
 			p: &ReadWriteRequest{
-				tcpHeader:   tcpHeader,
-				amsHeader:   amsHeader,
+				tcpHeader:   tcpHeaderN(19),
+				amsHeader:   amsHeaderN(19),
 				IndexGroup:  0x12345678,
 				IndexOffset: 0x23456789,
 				ReadLength:  0x34567890,
@@ -53,14 +73,14 @@ func TestReadWrite(t *testing.T) {
 					0x03, 0x00, 0x00, 0x00, // WriteLength
 					0x00, 0x01, 0x02, // Data
 				}
-				return append(append(tcpHeaderBytes, amsHeaderBytes...), data...)
+				return frameBytes(data)
 			}(),
 		},
 		{
 			name: "ReadWriteResponse",
 			p: &ReadWriteResponse{
-				tcpHeader: tcpHeader,
-				amsHeader: amsHeader,
+				tcpHeader: tcpHeaderN(11),
+				amsHeader: amsHeaderN(11),
 				Result:    0x12345678,
 				Length:    0x3,
 				Data:      []byte{0x00, 0x01, 0x02},
@@ -71,7 +91,7 @@ func TestReadWrite(t *testing.T) {
 					0x03, 0x00, 0x00, 0x00, // Length
 					0x00, 0x01, 0x02, // Data
 				}
-				return append(append(tcpHeaderBytes, amsHeaderBytes...), data...)
+				return frameBytes(data)
 			}(),
 		},
 	}
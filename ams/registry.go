@@ -0,0 +1,130 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ams
+
+import "sync"
+
+// Packet is the interface for AMS packets which can be
+// encoded and decoded including their headers.
+type Packet interface {
+	Header() *AMSHeader
+	Encoder
+	Decoder
+}
+
+// registryKey identifies a packet type.
+type registryKey struct {
+	cmdID    uint16
+	response bool
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[registryKey]func() Packet{}
+)
+
+func init() {
+	Register(CmdADSReadDeviceInfo, false, func() Packet { return &ReadDeviceInfoRequest{} })
+	Register(CmdADSReadDeviceInfo, true, func() Packet { return &ReadDeviceInfoResponse{} })
+	Register(CmdADSRead, false, func() Packet { return &ReadRequest{} })
+	Register(CmdADSRead, true, func() Packet { return &ReadResponse{} })
+	Register(CmdADSWrite, false, func() Packet { return &WriteRequest{} })
+	Register(CmdADSWrite, true, func() Packet { return &WriteResponse{} })
+	Register(CmdADSReadState, false, func() Packet { return &ReadStateRequest{} })
+	Register(CmdADSReadState, true, func() Packet { return &ReadStateResponse{} })
+	Register(CmdADSWriteControl, false, func() Packet { return &WriteControlRequest{} })
+	Register(CmdADSWriteControl, true, func() Packet { return &WriteControlResponse{} })
+	Register(CmdADSAddDeviceNotification, false, func() Packet { return &AddDeviceNotificationRequest{} })
+	Register(CmdADSAddDeviceNotification, true, func() Packet { return &AddDeviceNotificationResponse{} })
+	Register(CmdADSDeleteDeviceNotification, false, func() Packet { return &DeleteDeviceNotificationRequest{} })
+	Register(CmdADSDeleteDeviceNotification, true, func() Packet { return &DeleteDeviceNotificationResponse{} })
+	Register(CmdADSDeviceNotification, false, func() Packet { return &DeviceNotificationRequest{} })
+	Register(CmdADSReadWrite, false, func() Packet { return &ReadWriteRequest{} })
+	Register(CmdADSReadWrite, true, func() Packet { return &ReadWriteResponse{} })
+}
+
+// Register registers the constructor for the packet type with the
+// given command id. response selects whether the packet type is
+// used for requests or responses. Registering a command id again
+// replaces the previous registration which allows to override the
+// standard packet types.
+func Register(cmdID uint16, response bool, f func() Packet) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[registryKey{cmdID, response}] = f
+}
+
+// Decode decodes an AMS/TCP frame into the packet type registered
+// for the command id and the response flag of the frame.
+//
+// Frames for unknown command ids and error responses without a
+// payload are returned as *RawPacket.
+func Decode(frame []byte) (Packet, error) {
+	var hdr Header
+	if err := hdr.Decode(NewBuffer(frame)); err != nil {
+		return nil, err
+	}
+
+	registryMu.RLock()
+	f := registry[registryKey{hdr.CmdID, HasState(hdr.AMSHeader, StateResponse)}]
+	registryMu.RUnlock()
+
+	var p Packet
+	switch {
+	case f == nil:
+		p = &RawPacket{}
+	case hdr.ErrorCode != NoError && hdr.AMSHeader.Length == 0:
+		p = &RawPacket{}
+	default:
+		p = f()
+	}
+
+	if err := p.Decode(NewBuffer(frame)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// RawPacket is a packet with an undecoded payload.
+type RawPacket struct {
+	tcpHeader TCPHeader
+	amsHeader AMSHeader
+	Data      []byte
+}
+
+func NewRawPacket(target, sender Addr, cmdID, stateFlags uint16, data []byte) *RawPacket {
+	dataLen := uint32(len(data))
+	return &RawPacket{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + dataLen,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      cmdID,
+			StateFlags: stateFlags,
+			Length:     dataLen,
+		},
+		Data: data,
+	}
+}
+
+func (r *RawPacket) Header() *AMSHeader {
+	return &r.amsHeader
+}
+
+func (r *RawPacket) Encode(b *Buffer) error {
+	b.WriteStruct(&r.tcpHeader)
+	b.WriteStruct(&r.amsHeader)
+	b.Write(r.Data)
+	return b.Err()
+}
+
+func (r *RawPacket) Decode(b *Buffer) error {
+	b.ReadStruct(&r.tcpHeader)
+	b.ReadStruct(&r.amsHeader)
+	r.Data = b.ReadN(int(r.amsHeader.Length))
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, len(r.Data))
+}
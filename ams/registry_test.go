@@ -0,0 +1,96 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ams
+
+import (
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// encode returns the encoded packet.
+func encode(t *testing.T, p Packet) []byte {
+	t.Helper()
+	var b Buffer
+	if err := p.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Packet
+	}{
+		{"ReadDeviceInfoRequest", NewReadDeviceInfoRequest(target, sender)},
+		{"ReadDeviceInfoResponse", NewReadDeviceInfoResponse(target, sender, 0, 3, 1, 4024, "Plc30 App")},
+		{"ReadRequest", NewReadRequest(target, sender, 0x1, 0x2, 0x3)},
+		{"ReadResponse", NewReadResponse(target, sender, 0x1, []byte{0x2})},
+		{"WriteRequest", NewWriteRequest(target, sender, 0x1, 0x2, []byte{0x3})},
+		{"WriteResponse", NewWriteResponse(target, sender, 0x1)},
+		{"ReadStateRequest", NewReadStateRequest(target, sender)},
+		{"ReadStateResponse", NewReadStateResponse(target, sender, 0x1, 0x2, 0x3)},
+		{"WriteControlRequest", NewWriteControlRequest(target, sender, 0x1, 0x2, []byte{0x3})},
+		{"WriteControlResponse", NewWriteControlResponse(target, sender, 0x1)},
+		{"AddDeviceNotificationRequest", NewAddDeviceNotificationRequest(target, sender, 0x1, 0x2, 0x3, 0x4, 0x5, 0x6)},
+		{"AddDeviceNotificationResponse", NewAddDeviceNotificationResponse(target, sender, 0x1, 0x2)},
+		{"DeleteDeviceNotificationRequest", NewDeleteDeviceNotificationRequest(target, sender, 0x1)},
+		{"DeleteDeviceNotificationResponse", NewDeleteDeviceNotificationResponse(target, sender, 0x1)},
+		{"DeviceNotificationRequest", NewDeviceNotificationRequest(target, sender, []NotificationStamp{{Timestamp: 0x1, Samples: []NotificationSample{}}})},
+		{"ReadWriteRequest", NewReadWriteRequest(target, sender, 0x1, 0x2, 0x3, []byte{0x4})},
+		{"ReadWriteResponse", NewReadWriteResponse(target, sender, 0x1, []byte{0x2})},
+		{"unknown command", NewRawPacket(target, sender, 0x1234, StateADSCommand, []byte{0x1, 0x2})},
+		{"unknown response", NewRawPacket(target, sender, 0x1234, StateADSCommand|StateResponse, []byte{})},
+		{
+			name: "error response without payload",
+			p: func() Packet {
+				p := NewRawPacket(target, sender, CmdADSRead, StateADSCommand|StateResponse, []byte{})
+				p.Header().ErrorCode = 0x6
+				return p
+			}(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Decode(encode(t, tt.p))
+			if err != nil {
+				t.Fatal(err)
+			}
+			verify.Values(t, "", p, tt.p)
+		})
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	b := encode(t, NewReadResponse(target, sender, 0, []byte{0x1}))
+	if _, err := Decode(b[:len(b)-1]); err == nil {
+		t.Fatal("got nil want error")
+	}
+}
+
+// vendorPacket is a custom packet type for testing the registry.
+type vendorPacket struct {
+	RawPacket
+}
+
+func TestRegister(t *testing.T) {
+	const cmdID = 0x8001
+	Register(cmdID, false, func() Packet { return &vendorPacket{} })
+	defer func() {
+		registryMu.Lock()
+		delete(registry, registryKey{cmdID, false})
+		registryMu.Unlock()
+	}()
+
+	p, err := Decode(encode(t, NewRawPacket(target, sender, cmdID, StateADSCommand, []byte{0x1})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.(*vendorPacket); !ok {
+		t.Fatalf("got %T want *vendorPacket", p)
+	}
+}
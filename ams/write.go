@@ -55,7 +55,7 @@ func (r *WriteRequest) Decode(b *Buffer) error {
 	r.IndexOffset = b.ReadUint32()
 	r.Length = b.ReadUint32()
 	r.Data = b.ReadN(int(r.Length))
-	return b.Err()
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, 12+len(r.Data))
 }
 
 // WriteResponse is the packet for an AMS write response.
@@ -65,6 +65,22 @@ type WriteResponse struct {
 	Result    uint32
 }
 
+func NewWriteResponse(target, sender Addr, result uint32) *WriteResponse {
+	return &WriteResponse{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + 4,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSWrite,
+			StateFlags: StateADSCommand | StateResponse,
+			Length:     4,
+		},
+		Result: result,
+	}
+}
+
 func (r *WriteResponse) Header() *AMSHeader {
 	return &r.amsHeader
 }
@@ -80,7 +96,7 @@ func (r *WriteResponse) Decode(b *Buffer) error {
 	b.ReadStruct(&r.tcpHeader)
 	b.ReadStruct(&r.amsHeader)
 	r.Result = b.ReadUint32()
-	return b.Err()
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, 4)
 }
 
 // IsWriteResponse returns true if the packet is an AMS Write response.
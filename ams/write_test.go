@@ -27,6 +27,24 @@ func TestNewWriteRequest(t *testing.T) {
 	verify.Values(t, "", got, want)
 }
 
+func TestNewWriteResponse(t *testing.T) {
+	got := NewWriteResponse(target, sender, 0x1)
+	want := &WriteResponse{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + 4,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSWrite,
+			StateFlags: StateADSCommand | StateResponse,
+			Length:     4,
+		},
+		Result: 0x1,
+	}
+	verify.Values(t, "", got, want)
+}
+
 func TestWrite(t *testing.T) {
 	tests := []struct {
 		name string
@@ -36,8 +54,8 @@ func TestWrite(t *testing.T) {
 		{
 			name: "WriteRequest",
 			p: &WriteRequest{
-				tcpHeader:   tcpHeader,
-				amsHeader:   amsHeader,
+				tcpHeader:   tcpHeaderN(15),
+				amsHeader:   amsHeaderN(15),
 				IndexGroup:  0x12345678,
 				IndexOffset: 0x23456789,
 				Length:      0x3,
@@ -50,21 +68,21 @@ func TestWrite(t *testing.T) {
 					0x03, 0x00, 0x00, 0x00, // Length
 					0x00, 0x01, 0x02, // Data
 				}
-				return append(append(tcpHeaderBytes, amsHeaderBytes...), data...)
+				return frameBytes(data)
 			}(),
 		},
 		{
 			name: "WriteResponse",
 			p: &WriteResponse{
-				tcpHeader: tcpHeader,
-				amsHeader: amsHeader,
+				tcpHeader: tcpHeaderN(4),
+				amsHeader: amsHeaderN(4),
 				Result:    0x12345678,
 			},
 			b: func() []byte {
 				data := []byte{
 					0x78, 0x56, 0x34, 0x12, // Result
 				}
-				return append(append(tcpHeaderBytes, amsHeaderBytes...), data...)
+				return frameBytes(data)
 			}(),
 		},
 	}
@@ -0,0 +1,100 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ams
+
+// WriteControlRequest is the packet for an AMS WriteControl request.
+type WriteControlRequest struct {
+	tcpHeader   TCPHeader
+	amsHeader   AMSHeader
+	ADSState    uint16
+	DeviceState uint16
+	Length      uint32
+	Data        []byte
+}
+
+func NewWriteControlRequest(target, sender Addr, adsState, deviceState uint16, data []byte) *WriteControlRequest {
+	dataLen := uint32(len(data))
+	return &WriteControlRequest{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + dataLen + 8,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSWriteControl,
+			StateFlags: StateADSCommand,
+			Length:     dataLen + 8,
+		},
+		ADSState:    adsState,
+		DeviceState: deviceState,
+		Length:      dataLen,
+		Data:        data,
+	}
+}
+
+func (r *WriteControlRequest) Header() *AMSHeader {
+	return &r.amsHeader
+}
+
+func (r *WriteControlRequest) Encode(b *Buffer) error {
+	b.WriteStruct(&r.tcpHeader)
+	b.WriteStruct(&r.amsHeader)
+	b.WriteUint16(r.ADSState)
+	b.WriteUint16(r.DeviceState)
+	b.WriteUint32(r.Length)
+	b.WriteN(r.Data, r.Length)
+	return b.Err()
+}
+
+func (r *WriteControlRequest) Decode(b *Buffer) error {
+	b.ReadStruct(&r.tcpHeader)
+	b.ReadStruct(&r.amsHeader)
+	r.ADSState = b.ReadUint16()
+	r.DeviceState = b.ReadUint16()
+	r.Length = b.ReadUint32()
+	r.Data = b.ReadN(int(r.Length))
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, 8+len(r.Data))
+}
+
+// WriteControlResponse is the packet for an AMS WriteControl response.
+type WriteControlResponse struct {
+	tcpHeader TCPHeader
+	amsHeader AMSHeader
+	Result    uint32
+}
+
+func NewWriteControlResponse(target, sender Addr, result uint32) *WriteControlResponse {
+	return &WriteControlResponse{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + 4,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSWriteControl,
+			StateFlags: StateADSCommand | StateResponse,
+			Length:     4,
+		},
+		Result: result,
+	}
+}
+
+func (r *WriteControlResponse) Header() *AMSHeader {
+	return &r.amsHeader
+}
+
+func (r *WriteControlResponse) Encode(b *Buffer) error {
+	b.WriteStruct(&r.tcpHeader)
+	b.WriteStruct(&r.amsHeader)
+	b.WriteUint32(r.Result)
+	return b.Err()
+}
+
+func (r *WriteControlResponse) Decode(b *Buffer) error {
+	b.ReadStruct(&r.tcpHeader)
+	b.ReadStruct(&r.amsHeader)
+	r.Result = b.ReadUint32()
+	return checkLength(b, &r.tcpHeader, &r.amsHeader, 4)
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package ams
+
+import (
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestNewWriteControlRequest(t *testing.T) {
+	got := NewWriteControlRequest(target, sender, ADSStateRun, 0x2, []byte{0x3})
+	want := &WriteControlRequest{
+		tcpHeader: TCPHeader{
+			Length: amsHeaderLen + 9,
+		},
+		amsHeader: AMSHeader{
+			Target:     target,
+			Sender:     sender,
+			CmdID:      CmdADSWriteControl,
+			StateFlags: StateADSCommand,
+			Length:     9,
+		},
+		ADSState:    ADSStateRun,
+		DeviceState: 0x2,
+		Length:      0x1,
+		Data:        []byte{0x3},
+	}
+	verify.Values(t, "", got, want)
+}
+
+func TestWriteControl(t *testing.T) {
+	tests := []struct {
+		name string
+		p    codec
+		b    []byte
+	}{
+		{
+			name: "WriteControlRequest",
+			p: &WriteControlRequest{
+				tcpHeader:   tcpHeaderN(11),
+				amsHeader:   amsHeaderN(11),
+				ADSState:    0x1234,
+				DeviceState: 0x5678,
+				Length:      0x3,
+				Data:        []byte{0x00, 0x01, 0x02},
+			},
+			b: func() []byte {
+				data := []byte{
+					0x34, 0x12, // ADSState
+					0x78, 0x56, // DeviceState
+					0x03, 0x00, 0x00, 0x00, // Length
+					0x00, 0x01, 0x02, // Data
+				}
+				return frameBytes(data)
+			}(),
+		},
+		{
+			name: "WriteControlResponse",
+			p: &WriteControlResponse{
+				tcpHeader: tcpHeaderN(4),
+				amsHeader: amsHeaderN(4),
+				Result:    0x12345678,
+			},
+			b: func() []byte {
+				data := []byte{
+					0x78, 0x56, 0x34, 0x12, // Result
+				}
+				return frameBytes(data)
+			}(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codecTest(t, tt.p, tt.b)
+		})
+	}
+}
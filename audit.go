@@ -0,0 +1,284 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// AuditRecord is the audit log entry of a single write. A sum write
+// has a record for every contained write.
+type AuditRecord struct {
+	Time        time.Time `json:"time"`
+	Identity    string    `json:"identity,omitempty"`
+	Target      string    `json:"target"`
+	Cmd         string    `json:"cmd"`
+	Symbol      string    `json:"symbol,omitempty"`
+	IndexGroup  uint32    `json:"index_group"`
+	IndexOffset uint32    `json:"index_offset"`
+	OldValue    []byte    `json:"old_value,omitempty"`
+	NewValue    []byte    `json:"new_value"`
+	Result      uint32    `json:"result"`
+	Err         string    `json:"error,omitempty"`
+	DryRun      bool      `json:"dry_run,omitempty"`
+
+	// PrevHash and Hash chain the records of an AuditLog.
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// hash returns the hash of the record without the Hash field.
+func (r AuditRecord) hash() (string, error) {
+	r.Hash = ""
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Auditor records the writes of a client.
+type Auditor interface {
+	Audit(rec *AuditRecord) error
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a context with the identity of the user or
+// service on whose behalf the requests are sent. The identity is
+// recorded in the audit log.
+func WithIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// Identity returns the identity of the context or an empty string.
+func Identity(ctx context.Context) string {
+	s, _ := ctx.Value(identityKey{}).(string)
+	return s
+}
+
+// audit is the interceptor of a client with an Auditor. It records
+// every write after it was sent.
+func (c *Client) audit(a Auditor) Interceptor {
+	return func(ctx context.Context, req ams.Packet, invoke Invoker) (ams.Response, error) {
+		writes := c.writes(req)
+		if len(writes) == 0 {
+			return invoke(ctx, req)
+		}
+
+		var old [][]byte
+		if c.AuditOldValues {
+			old = c.readOldValues(ctx, req.Header(), writes, invoke)
+		}
+		start := time.Now().UTC()
+		r, err := invoke(ctx, req)
+
+		hdr := req.Header()
+		for i, w := range writes {
+			rec := &AuditRecord{
+				Time:        start,
+				Identity:    Identity(ctx),
+				Target:      hdr.Target.String(),
+				Cmd:         ams.CmdName(hdr.CmdID),
+				Symbol:      w.symbol,
+				IndexGroup:  w.group,
+				IndexOffset: w.offset,
+				NewValue:    w.data,
+			}
+			if old != nil {
+				rec.OldValue = old[i]
+			}
+			if err != nil {
+				rec.Err = err.Error()
+			} else {
+				rec.Result = writeResult(req, r, w)
+				rec.DryRun = c.WritePolicy != nil && c.WritePolicy.DryRun
+			}
+			if aerr := a.Audit(rec); aerr != nil {
+				c.log(ctx, LevelError, "audit failed", append(logArgs(hdr, nil), "err", aerr)...)
+			}
+		}
+		return r, err
+	}
+}
+
+// readOldValues reads the current values of the writes. The value of
+// a write is nil if it could not be read.
+func (c *Client) readOldValues(ctx context.Context, hdr *ams.AMSHeader, writes []writeAccess, invoke Invoker) [][]byte {
+	old := make([][]byte, len(writes))
+	for i, w := range writes {
+		if w.control {
+			continue
+		}
+		r, err := invoke(ctx, ams.NewReadRequest(hdr.Target, hdr.Sender, w.group, w.offset, w.length))
+		if err != nil {
+			c.log(ctx, LevelWarn, "audit read failed", append(logArgs(hdr, nil), "err", err)...)
+			continue
+		}
+		if rr, ok := r.(*ams.ReadResponse); ok && rr.Result == ams.NoError && rr.Header().ErrorCode == ams.NoError {
+			old[i] = rr.Data
+		}
+	}
+	return old
+}
+
+// writeResult returns the ADS result of a write. Sum requests
+// contain a result for every write.
+func writeResult(req ams.Packet, r ams.Response, w writeAccess) uint32 {
+	if code := r.Header().ErrorCode; code != ams.NoError {
+		return code
+	}
+	result := adsResult(r)
+	rw, ok := req.(*ams.ReadWriteRequest)
+	resp, ok2 := r.(*ams.ReadWriteResponse)
+	if result != ams.NoError || !ok || !ok2 {
+		return result
+	}
+	var off int
+	switch rw.IndexGroup {
+	case ams.IdxADSIGRP_SUMUP_WRITE:
+		off = 4 * w.index
+	case ams.IdxADSIGRP_SUMUP_READWRITE:
+		// result and read length
+		off = 8 * w.index
+	default:
+		return result
+	}
+	if off+4 > len(resp.Data) {
+		return result
+	}
+	return binary.LittleEndian.Uint32(resp.Data[off:])
+}
+
+// ErrAuditTampered is returned when the hash chain of an audit log
+// is broken.
+var ErrAuditTampered = errors.New("audit log was modified")
+
+// AuditLogError describes an invalid record of an audit log.
+type AuditLogError struct {
+	Line int
+	Err  error
+}
+
+func (e *AuditLogError) Error() string {
+	return fmt.Sprintf("audit log line %d: %s", e.Line, e.Err)
+}
+
+func (e *AuditLogError) Unwrap() error { return e.Err }
+
+// AuditLog is an Auditor which appends the records to a JSON Lines
+// file. Every record contains the hash of the previous record so that
+// a modified, inserted or removed record breaks the chain. Removing
+// records from the end of the file cannot be detected from the file
+// alone. Keep LastHash in a separate place for this.
+type AuditLog struct {
+	mu   sync.Mutex
+	f    *os.File
+	last string
+}
+
+// OpenAuditLog opens or creates an audit log file. The records of an
+// existing file are verified and new records continue its chain.
+func OpenAuditLog(name string) (*AuditLog, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	last, err := verifyAuditLog(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &AuditLog{f: f, last: last}, nil
+}
+
+// Audit appends the record to the file. It sets the hash fields of
+// the record.
+func (l *AuditLog) Audit(rec *AuditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rec.PrevHash = l.last
+	h, err := rec.hash()
+	if err != nil {
+		return err
+	}
+	rec.Hash = h
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.last = h
+	return nil
+}
+
+// LastHash returns the hash of the last record.
+func (l *AuditLog) LastHash() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+// Close closes the file.
+func (l *AuditLog) Close() error {
+	return l.f.Close()
+}
+
+// VerifyAuditLog verifies the hash chain of an audit log and returns
+// the number of records. A broken chain is reported as
+// *AuditLogError with ErrAuditTampered.
+func VerifyAuditLog(r io.Reader) (int, error) {
+	var n int
+	_, err := scanAuditLog(r, func(*AuditRecord) { n++ })
+	return n, err
+}
+
+func verifyAuditLog(r io.Reader) (string, error) {
+	return scanAuditLog(r, func(*AuditRecord) {})
+}
+
+// scanAuditLog verifies the records of an audit log, calls f for
+// every record and returns the hash of the last record.
+func scanAuditLog(r io.Reader, f func(*AuditRecord)) (string, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 16<<20)
+	var last string
+	for line := 1; s.Scan(); line++ {
+		var rec AuditRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			return "", &AuditLogError{Line: line, Err: err}
+		}
+		h, err := rec.hash()
+		if err != nil {
+			return "", &AuditLogError{Line: line, Err: err}
+		}
+		if rec.PrevHash != last || rec.Hash != h {
+			return "", &AuditLogError{Line: line, Err: ErrAuditTampered}
+		}
+		last = h
+		f(&rec)
+	}
+	return last, s.Err()
+}
@@ -0,0 +1,162 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// memAuditor keeps the records without the time.
+type memAuditor struct {
+	mu   sync.Mutex
+	recs []AuditRecord
+}
+
+func (a *memAuditor) Audit(rec *AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := *rec
+	r.Time = time.Time{}
+	a.recs = append(a.recs, r)
+	return nil
+}
+
+func TestAuditWrite(t *testing.T) {
+	s := newTestServer(t)
+	s.mem[0x4020] = []byte{1, 2}
+	a := &memAuditor{}
+	c := s.dial(t, func(c *Client) {
+		c.Auditor = a
+		c.AuditOldValues = true
+		c.WritePolicy = &WritePolicy{
+			DenySymbols: []string{"MAIN.locked"},
+			AllowRanges: []AddrRange{{IndexGroup: 0x4020}},
+		}
+	})
+	ctx := WithIdentity(context.Background(), "alice")
+
+	if _, err := c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, 0x4020, 0, []byte{3, 4})); err != nil {
+		t.Fatal(err)
+	}
+	h, err := c.GetSymHandleByName(ctx, testTarget, testSender, "MAIN.locked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, ams.IdxReadWriteSymValueByHandle, h, []byte{5}))
+	if !errors.Is(err, ErrSymbolNotAllowed) {
+		t.Fatalf("got %v want %v", err, ErrSymbolNotAllowed)
+	}
+
+	verify.Values(t, "records", a.recs, []AuditRecord{
+		{
+			Identity:    "alice",
+			Target:      "1.2.3.4.5.6:851",
+			Cmd:         "Write",
+			IndexGroup:  0x4020,
+			IndexOffset: 0,
+			OldValue:    []byte{1, 2},
+			NewValue:    []byte{3, 4},
+		},
+		{
+			Identity:    "alice",
+			Target:      "1.2.3.4.5.6:851",
+			Cmd:         "Write",
+			Symbol:      "MAIN.locked",
+			IndexGroup:  ams.IdxReadWriteSymValueByHandle,
+			IndexOffset: h,
+			OldValue:    []byte{0},
+			NewValue:    []byte{5},
+			Err:         err.Error(),
+		},
+	})
+}
+
+func TestAuditSumWrite(t *testing.T) {
+	s := newTestServer(t)
+	s.setHandler(func(req ams.Packet) ams.Packet {
+		hdr := req.Header()
+		// the second write fails
+		return ams.NewReadWriteResponse(hdr.Sender, hdr.Target, ams.NoError, []byte{0, 0, 0, 0, 0x05, 0x07, 0, 0})
+	})
+	a := &memAuditor{}
+	c := s.dial(t, func(c *Client) { c.Auditor = a })
+
+	var b ams.Buffer
+	for _, w := range [][3]uint32{{0x4020, 10, 1}, {0x4020, 20, 2}} {
+		b.WriteUint32(w[0])
+		b.WriteUint32(w[1])
+		b.WriteUint32(w[2])
+	}
+	b.WriteN([]byte{1, 2, 3}, 3)
+	if _, err := c.ReadWrite(context.Background(), ams.NewReadWriteRequest(testTarget, testSender, ams.IdxADSIGRP_SUMUP_WRITE, 2, 8, b.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	verify.Values(t, "records", a.recs, []AuditRecord{
+		{Target: "1.2.3.4.5.6:851", Cmd: "ReadWrite", IndexGroup: 0x4020, IndexOffset: 10, NewValue: []byte{1}},
+		{Target: "1.2.3.4.5.6:851", Cmd: "ReadWrite", IndexGroup: 0x4020, IndexOffset: 20, NewValue: []byte{2, 3}, Result: ams.DeviceInvalidSize},
+	})
+}
+
+func TestAuditLog(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := OpenAuditLog(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := l.Audit(&AuditRecord{Time: time.Now(), Target: "1.2.3.4.5.6:851", NewValue: []byte{byte(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last := l.LastHash()
+	l.Close()
+
+	// the chain continues after reopening
+	l, err = OpenAuditLog(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &AuditRecord{Time: time.Now(), Target: "1.2.3.4.5.6:851", NewValue: []byte{2}}
+	if err := l.Audit(rec); err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "prev hash", rec.PrevHash, last)
+	l.Close()
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := VerifyAuditLog(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "records", n, 3)
+
+	// change the value of the second record
+	b = bytes.Replace(b, []byte(`"new_value":"AQ=="`), []byte(`"new_value":"Ag=="`), 1)
+	_, err = VerifyAuditLog(bytes.NewReader(b))
+	var lerr *AuditLogError
+	if !errors.As(err, &lerr) || lerr.Line != 2 || lerr.Err != ErrAuditTampered {
+		t.Fatalf("got %v want tampered line 2", err)
+	}
+	if err := os.WriteFile(name, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenAuditLog(name); !errors.Is(err, ErrAuditTampered) {
+		t.Fatalf("got %v want %v", err, ErrAuditTampered)
+	}
+}
@@ -7,11 +7,12 @@
 package twincat
 
 import (
+	"bufio"
 	"context"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -22,16 +23,115 @@ import (
 
 var ErrTimeout = errors.New("timeout")
 
-// Client implements a Twincat3 TCP client.
+// ErrNotConnected is returned for requests before the client has
+// dialed.
+var ErrNotConnected = errors.New("not connected")
+
+// Client implements a Twincat3 ADS client. The connection is
+// created by the Dialer and uses TCP by default.
 type Client struct {
-	Addr        string
+	Addr string
+
+	// ReadTimeout limits the time to wait for a response. If the
+	// context of a request has an earlier deadline, the context
+	// ends the request with its error. Otherwise the request fails
+	// with ErrTimeout. If zero, only the context limits the time.
 	ReadTimeout time.Duration
 
+	// MaxFrameLen is the maximum length of a frame received from
+	// the server. If zero, ams.DefaultMaxFrameLen is used.
+	MaxFrameLen uint32
+
+	// Dialer creates the connection to Addr. If nil, a TCPDialer
+	// is used. Use a SecureADS for encrypted connections, a
+	// UDPDialer for ADS over UDP or ConnDialer for an existing
+	// connection.
+	Dialer Dialer
+
+	// WrapConn is called with the connection after dialing
+	// and the returned connection is used instead. It can be
+	// used to record or inspect the traffic of any dialer.
+	WrapConn func(net.Conn) net.Conn
+
+	// Logger receives the log messages of the client. If nil,
+	// messages are written to the standard logger of the log
+	// package.
+	Logger Logger
+
+	// LogLevel is the minimum level of messages which are logged.
+	// LevelTrace also logs hex dumps of all frames.
+	LogLevel Level
+
+	// Instrumentation receives callbacks for metrics and tracing.
+	Instrumentation Instrumentation
+
+	// MaxInFlight limits the number of requests which wait for a
+	// response. Further requests wait in a queue in the order of
+	// their arrival until a response arrives or their context is
+	// done. If zero, there is no limit.
+	MaxInFlight int
+
+	// RateLimit limits the request rate per target. If nil, there
+	// is no limit.
+	RateLimit *RateLimit
+
+	// Heartbeat enables periodic ReadState requests which monitor
+	// the health of the connection. See Health.
+	Heartbeat *Heartbeat
+
+	// OnEvent is called for changes of the connection and the target.
+	// It is called synchronously and must not block.
+	OnEvent func(Event)
+
+	// Retry retries requests which failed with a transient error.
+	// If nil, requests are not retried.
+	Retry *RetryPolicy
+
+	// WritePolicy restricts the writes of the client. It is checked
+	// after the interceptors. If nil, all writes are sent.
+	WritePolicy *WritePolicy
+
+	// Auditor records every write of the client after it was sent,
+	// including denied writes. If nil, writes are not recorded.
+	Auditor Auditor
+
+	// AuditOldValues reads the value before every write for the
+	// audit record.
+	AuditOldValues bool
+
+	// Interceptors are called for every request in order. The first
+	// interceptor is the outermost one. See Interceptor.
+	Interceptors []Interceptor
+
+	// SymbolWatch enables the monitoring of the symbol version of a
+	// target to detect online changes. If nil, cached handles and
+	// symbol tables are kept until they are rejected.
+	SymbolWatch *SymbolWatch
+
 	conn         net.Conn
 	nextInvokeID uint32 // atomic
 
-	mu      sync.Mutex
-	handler map[uint32]chan ams.Response
+	// mu protects conn, connErr, run, stop, handler, abandoned and
+	// hb.
+	mu        sync.Mutex
+	connErr   error // read error of conn after the receiver has exited
+	run       context.Context
+	stop      context.CancelFunc
+	handler   map[uint32]*handler
+	abandoned abandoned
+	hb        *heartbeat
+
+	limitOnce  sync.Once
+	queue      *queue
+	rate       *rateLimiter
+	queueMu    sync.Mutex
+	queueDepth int
+
+	handles symbolHandles
+	tables  symbolTables
+	vars    variables
+	subs    subscriptions
+	watch   symbolWatch
 
 	adsState    atomic.Value // uint16
 	deviceState atomic.Value // uint16
@@ -53,137 +153,229 @@ func (c *Client) SetDeviceState(s uint16) {
 	c.deviceState.Store(s)
 }
 
-// Dial connects to a Twincat server.
+// Dial connects to a Twincat server. ctx only limits the dial. The
+// connection and the work after dialing use a context of the client
+// which ends with Close.
 func (c *Client) Dial(ctx context.Context) error {
 	atomic.AddUint32(&c.nextInvokeID, 1)
 
 	c.SetADSState(ams.ADSStateStart)
 	c.SetDeviceState(ams.ADSStateStart)
 
-	d := &net.Dialer{}
-	conn, err := d.DialContext(ctx, "tcp", c.Addr)
+	redial := c.connection() != nil
+
+	conn, err := c.dial(ctx)
+	if redial {
+		c.instr().Reconnect(c.Addr, err)
+	}
 	if err != nil {
 		return err
 	}
-	c.conn = conn
-	go c.receive(ctx)
+	if c.WrapConn != nil {
+		conn = c.WrapConn(conn)
+	}
+	c.mu.Lock()
+	c.conn, c.connErr = conn, nil
+	c.mu.Unlock()
+	go c.receive(c.runContext(), conn)
+	c.startHeartbeat()
+	go c.connected(redial)
 	return nil
 }
 
+// workTimeout limits the requests which the client sends on its own
+// after dialing or a change of the target, e.g. to restore the
+// subscriptions.
+const workTimeout = 30 * time.Second
+
+// workContext returns a context for the work of the client which ends
+// after workTimeout or with Close.
+func (c *Client) workContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(c.runContext(), workTimeout)
+}
+
+// connected restores the subscriptions after a redial and starts the
+// symbol watch. It runs after Dial has returned.
+func (c *Client) connected(redial bool) {
+	ctx, cancel := c.workContext()
+	defer cancel()
+	if redial {
+		c.restoreSubscriptions(ctx)
+	}
+	c.startSymbolWatch(ctx)
+}
+
+// runContext returns the context of the client. It is created by the
+// first Dial after the client was created or closed.
+func (c *Client) runContext() context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.run == nil || c.run.Err() != nil {
+		c.run, c.stop = context.WithCancel(context.Background())
+	}
+	return c.run
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	d := c.Dialer
+	if d == nil {
+		d = &TCPDialer{}
+	}
+	return d.DialContext(ctx, c.Addr)
+}
+
 func (c *Client) Close() error {
-	if c.conn == nil {
+	c.stopHeartbeat()
+	c.mu.Lock()
+	if c.stop != nil {
+		c.stop()
+	}
+	c.mu.Unlock()
+	conn := c.connection()
+	if conn == nil {
 		return nil
 	}
-	return c.conn.Close()
+	return conn.Close()
+}
+
+// handler receives the response of a request. If the connection of
+// the request is lost before the response arrives, err is set and ch
+// is closed without a response.
+type handler struct {
+	ch   chan ams.Response
+	conn net.Conn
+	err  error
 }
 
-type responseDecoder interface {
-	ams.Response
-	ams.Decoder
+// connection returns the current connection.
+func (c *Client) connection() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
 }
 
-func (c *Client) receive(ctx context.Context) error {
+func (c *Client) receive(ctx context.Context, conn net.Conn) (err error) {
 	c.SetADSState(ams.ADSStateRun)
 	c.SetDeviceState(ams.ADSStateRun)
-	defer c.SetADSState(ams.ADSStateStop)
-	defer c.SetDeviceState(ams.ADSStateStop)
+	defer func() {
+		// the connection may have been replaced by a reconnect
+		if c.lost(conn, err) {
+			c.SetADSState(ams.ADSStateStop)
+			c.SetDeviceState(ams.ADSStateStop)
+		}
+	}()
 
-	// We assume that a packet fits into a single packet.
-	// This is probably wrong but I haven't found anything on length
-	// would probably have to read the header first, alloc and then read
-	// the rest to fix this probably. This works for now.
-	const packetSize = 1500
+	maxLen := c.MaxFrameLen
+	if maxLen == 0 {
+		maxLen = ams.DefaultMaxFrameLen
+	}
+	r := bufio.NewReader(conn)
 
 	for {
 		// read the next packet
-		data := make([]byte, packetSize)
-		n, err := c.conn.Read(data)
+		data, err := ams.ReadFrame(r, maxLen)
 		if err != nil {
 			return err
 		}
-
-		// truncate the buffer to the correct length
-		data = data[:n]
-		// log.Printf("read %d bytes", n)
-
-		// decode just the header
-		var hdr ams.Header
-		if err := hdr.Decode(ams.NewBuffer(data)); err != nil {
-			return err
+		if c.enabled(LevelTrace) {
+			c.log(ctx, LevelTrace, "received frame", "frame", hex.Dump(data))
 		}
 
-		// figure out the packet type
-		var pkt packet
-		switch {
-		case ams.IsReadResponse(hdr.AMSHeader):
-			pkt = &ams.ReadResponse{}
-		case ams.IsWriteResponse(hdr.AMSHeader):
-			pkt = &ams.WriteResponse{}
-		case ams.IsReadWriteResponse(hdr.AMSHeader):
-			pkt = &ams.ReadWriteResponse{}
-		case ams.IsReadStateRequest(hdr.AMSHeader):
-			pkt = &ams.ReadStateRequest{}
-		default:
-			log.Printf("client: unknown packet: %#v", hdr)
+		// decode the packet with the registered packet type.
+		// A frame which cannot be decoded does not affect the
+		// framing of the following packets.
+		pkt, err := ams.Decode(data)
+		if err != nil {
+			c.log(ctx, LevelError, "failed to decode", "err", err)
 			continue
 		}
 
-		// decode the full packet with the header
-		if err := pkt.Decode(ams.NewBuffer(data)); err != nil {
-			log.Printf("client: failed to decode: %s", err)
-			return err
-		}
-
-		switch req := pkt.(type) {
-		// handle incoming requests
-		case *ams.ReadStateRequest:
-			if err := c.handleReadStateRequest(ctx, req); err != nil {
-				return err
+		hdr := pkt.Header()
+		if !ams.HasState(*hdr, ams.StateResponse) {
+			switch req := pkt.(type) {
+			// handle incoming requests
+			case *ams.ReadStateRequest:
+				if err := c.handleReadStateRequest(ctx, conn, req); err != nil {
+					return err
+				}
+			case *ams.DeviceNotificationRequest:
+				c.notify(ctx, req)
+			default:
+				c.log(ctx, LevelWarn, "unknown packet", logArgs(hdr, hdr)...)
+				c.instr().UnknownPacket(*hdr)
 			}
+			continue
+		}
 
 		// forward responses to handlers
-		default:
-			// find the handler channel for packet
-			invokeID := hdr.AMSHeader.InvokeID
-			c.mu.Lock()
-			if c.handler == nil {
-				c.handler = make(map[uint32]chan ams.Response)
-			}
-			h := c.handler[invokeID]
-			delete(c.handler, invokeID)
-			c.mu.Unlock()
+		// find the handler channel for packet
+		invokeID := hdr.InvokeID
+		c.mu.Lock()
+		h := c.handler[invokeID]
+		delete(c.handler, invokeID)
+		inFlight := len(c.handler)
+		c.mu.Unlock()
+		if h != nil {
+			c.instr().InFlight(c.Addr, inFlight)
+		}
 
-			// if there is no handler then drop the packet
-			if h == nil {
-				log.Printf("client: no handler for %d", invokeID)
+		// if there is no handler then drop the packet. Responses
+		// of canceled or timed out requests are expected.
+		if h == nil {
+			if c.late(invokeID) {
+				c.log(ctx, LevelDebug, "late response", logArgs(hdr, hdr)...)
+				c.instr().LateResponse(*hdr)
 				continue
 			}
+			c.log(ctx, LevelWarn, "no handler", logArgs(hdr, hdr)...)
+			continue
+		}
 
-			// otherwise send the response to the handler.
-			// here we assume that h is buffered and can hold
-			// one response. So this call should never block.
-			select {
-			case <-ctx.Done():
-			case h <- pkt:
-				close(h)
-			}
+		// otherwise send the response to the handler.
+		// h is buffered and holds one response and the handler
+		// was removed above. So this call never blocks. ctx is
+		// the context of the client and must not drop the
+		// response when it is done.
+		h.ch <- pkt
+		close(h.ch)
+	}
+}
+
+// lost fails the pending requests of a connection whose receiver has
+// exited with err. Further requests fail with err until the client has
+// dialed again. It returns false if conn is no longer the connection
+// of the client.
+func (c *Client) lost(conn net.Conn, err error) bool {
+	c.mu.Lock()
+	current := c.conn == conn
+	if current {
+		c.connErr = err
+	}
+	var failed bool
+	for id, h := range c.handler {
+		if h.conn != conn {
+			continue
 		}
+		delete(c.handler, id)
+		h.err = err
+		close(h.ch)
+		failed = true
+	}
+	inFlight := len(c.handler)
+	c.mu.Unlock()
+	if failed {
+		c.instr().InFlight(c.Addr, inFlight)
 	}
+	return current
 }
 
-func (c *Client) handleReadStateRequest(ctx context.Context, req *ams.ReadStateRequest) error {
+func (c *Client) handleReadStateRequest(ctx context.Context, conn net.Conn, req *ams.ReadStateRequest) error {
 	hdr := req.Header()
 	resp := ams.NewReadStateResponse(hdr.Sender, hdr.Target, ams.NoError, c.ADSState(), c.DeviceState())
-	return c.sendResponse(ctx, req, resp)
-}
-
-type packet interface {
-	Header() *ams.AMSHeader
-	Decode(b *ams.Buffer) error
-	Encode(b *ams.Buffer) error
+	return c.sendResponse(ctx, conn, req, resp)
 }
 
-func (c *Client) sendResponse(ctx context.Context, req ams.Request, pkt packet) error {
+func (c *Client) sendResponse(ctx context.Context, conn net.Conn, req ams.Request, pkt ams.Packet) error {
 	// set the invoke id from the request
 	pkt.Header().InvokeID = req.Header().InvokeID
 
@@ -194,53 +386,142 @@ func (c *Client) sendResponse(ctx context.Context, req ams.Request, pkt packet)
 	}
 
 	// send the response
-	_, err := c.conn.Write(b.Bytes())
+	if c.enabled(LevelTrace) {
+		c.log(ctx, LevelTrace, "sent frame", "frame", hex.Dump(b.Bytes()))
+	}
+	_, err := conn.Write(b.Bytes())
 	return err
 }
 
-// send sends a request to the server and sets up a handler channel
-// for the callback.
-func (c *Client) send(ctx context.Context, pkt packet, cb func(ams.Response) error) error {
+// logArgs returns the log fields for a request and its
+// response. resp can be nil.
+func logArgs(req, resp *ams.AMSHeader) []interface{} {
+	args := []interface{}{
+		"cmd", ams.CmdName(req.CmdID),
+		"invoke_id", req.InvokeID,
+		"target", req.Target,
+	}
+	if resp != nil {
+		args = append(args, "error_code", resp.ErrorCode)
+	}
+	return args
+}
+
+// send passes a request through the interceptors and calls cb with
+// the response. A response with an error code in the AMS header is
+// returned as *ADSError since it has no payload to decode.
+func (c *Client) send(ctx context.Context, pkt ams.Packet, cb func(ams.Response) error) error {
+	r, err := c.invoker()(ctx, pkt)
+	if err != nil {
+		return err
+	}
+	if code := r.Header().ErrorCode; code != ams.NoError {
+		return &ADSError{Code: code}
+	}
+	return cb(r)
+}
+
+// roundTrip sends a request to the server and waits for the response
+// on a handler channel.
+func (c *Client) roundTrip(ctx context.Context, pkt ams.Packet) (ams.Response, error) {
 	// set a unique invoke id for the request
 	pkt.Header().InvokeID = atomic.AddUint32(&c.nextInvokeID, 1)
 
 	// encode the request
 	var b ams.Buffer
 	if err := pkt.Encode(&b); err != nil {
-		return err
+		return nil, err
+	}
+
+	// wait for the rate and in-flight limits
+	done, err := c.admit(ctx, pkt.Header().Target)
+	if err != nil {
+		c.log(ctx, LevelDebug, "request canceled while queued", append(logArgs(pkt.Header(), nil), "err", err)...)
+		return nil, err
 	}
+	defer done()
 
+	info := requestInfo(pkt, len(b.Bytes()))
+	ctx = c.instr().RequestStart(ctx, info)
+
+	// register the handler unless the connection is gone.
+	c.mu.Lock()
+	conn, err := c.conn, c.connErr
+	if conn == nil {
+		err = ErrNotConnected
+	}
+	if err != nil {
+		c.mu.Unlock()
+		c.log(ctx, LevelDebug, "request failed", append(logArgs(pkt.Header(), nil), "err", err)...)
+		c.instr().RequestEnd(ctx, info, RequestResult{Err: err})
+		return nil, err
+	}
 	// create a handler channel for the response
 	// make sure that the channel is buffered
 	// so that we don't need a separate go routine for
 	// sending the resposne.
-	h := make(chan ams.Response, 1)
-
-	// register the handler.
-	c.mu.Lock()
+	h := &handler{ch: make(chan ams.Response, 1), conn: conn}
 	if c.handler == nil {
-		c.handler = make(map[uint32]chan ams.Response)
+		c.handler = make(map[uint32]*handler)
 	}
 	c.handler[pkt.Header().InvokeID] = h
+	inFlight := len(c.handler)
 	c.mu.Unlock()
+	c.instr().InFlight(c.Addr, inFlight)
 
 	// send the request
-	_, err := c.conn.Write(b.Bytes())
+	start := time.Now()
+	if c.enabled(LevelTrace) {
+		c.log(ctx, LevelTrace, "sent frame", "frame", hex.Dump(b.Bytes()))
+	}
+	_, err = conn.Write(b.Bytes())
 	if err != nil {
 		c.mu.Lock()
 		delete(c.handler, pkt.Header().InvokeID)
+		inFlight := len(c.handler)
 		c.mu.Unlock()
-		return err
+		c.instr().InFlight(c.Addr, inFlight)
+		c.log(ctx, LevelError, "request failed", append(logArgs(pkt.Header(), nil), "err", err)...)
+		c.instr().RequestEnd(ctx, info, RequestResult{Latency: time.Since(start), Err: err})
+		return nil, err
 	}
 
-	// wait for the response or timeout.
+	// wait for the response, the client timeout or the end of ctx.
+	// Whichever comes first ends the request.
+	var timeout <-chan time.Time
+	if c.ReadTimeout > 0 {
+		t := time.NewTimer(c.ReadTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
-	case <-time.After(c.ReadTimeout):
-		return ErrTimeout
-	case r := <-h:
-		return cb(r)
+		c.abandon(pkt.Header().InvokeID)
+		c.log(ctx, LevelDebug, "request canceled", append(logArgs(pkt.Header(), nil), "latency", time.Since(start), "err", ctx.Err())...)
+		c.instr().RequestEnd(ctx, info, RequestResult{Latency: time.Since(start), Err: ctx.Err()})
+		return nil, ctx.Err()
+	case <-timeout:
+		c.abandon(pkt.Header().InvokeID)
+		c.log(ctx, LevelWarn, "request timed out", append(logArgs(pkt.Header(), nil), "latency", time.Since(start))...)
+		c.instr().Timeout(info)
+		c.instr().RequestEnd(ctx, info, RequestResult{Latency: time.Since(start), Err: ErrTimeout})
+		return nil, ErrTimeout
+	case r, ok := <-h.ch:
+		latency := time.Since(start)
+		if !ok {
+			c.log(ctx, LevelWarn, "request failed", append(logArgs(pkt.Header(), nil), "latency", latency, "err", h.err)...)
+			c.instr().RequestEnd(ctx, info, RequestResult{Latency: latency, Err: h.err})
+			return nil, h.err
+		}
+		c.log(ctx, LevelDebug, "request", append(logArgs(pkt.Header(), r.Header()), "latency", latency)...)
+		c.instr().RequestEnd(ctx, info, RequestResult{
+			Bytes:     ams.HeaderLen + int(r.Header().Length),
+			ErrorCode: r.Header().ErrorCode,
+			Result:    adsResult(r),
+			Latency:   latency,
+		})
+		c.trackHandles(pkt, r)
+		return r, nil
 	}
 }
 
@@ -257,6 +538,88 @@ func (c *Client) Read(ctx context.Context, r *ams.ReadRequest) (*ams.ReadRespons
 	return resp, err
 }
 
+// maxAbandoned is the number of abandoned invoke ids which are
+// remembered to recognize late responses.
+const maxAbandoned = 1024
+
+// abandoned is a bounded set of invoke ids of requests which were
+// canceled or timed out. The oldest ids are forgotten first.
+type abandoned struct {
+	ids   map[uint32]bool
+	order []uint32 // ring buffer
+	next  int
+}
+
+func (a *abandoned) add(id uint32) {
+	if a.ids == nil {
+		a.ids = map[uint32]bool{}
+		a.order = make([]uint32, 0, maxAbandoned)
+	}
+	if len(a.order) < maxAbandoned {
+		a.order = append(a.order, id)
+	} else {
+		delete(a.ids, a.order[a.next])
+		a.order[a.next] = id
+		a.next = (a.next + 1) % maxAbandoned
+	}
+	a.ids[id] = true
+}
+
+// remove returns true if the id was abandoned and forgets it.
+func (a *abandoned) remove(id uint32) bool {
+	if !a.ids[id] {
+		return false
+	}
+	delete(a.ids, id)
+	return true
+}
+
+// canceler is implemented by connections which keep state for a
+// request until its response arrives, e.g. the retransmits of ADS
+// over UDP.
+type canceler interface {
+	cancel(invokeID uint32)
+}
+
+// abandon deregisters the handler of a canceled or timed out request.
+func (c *Client) abandon(invokeID uint32) {
+	c.mu.Lock()
+	h, ok := c.handler[invokeID]
+	if !ok {
+		// the response has arrived in the meantime
+		c.mu.Unlock()
+		return
+	}
+	delete(c.handler, invokeID)
+	c.abandoned.add(invokeID)
+	inFlight := len(c.handler)
+	c.mu.Unlock()
+	if cc, ok := h.conn.(canceler); ok {
+		cc.cancel(invokeID)
+	}
+	c.instr().InFlight(c.Addr, inFlight)
+}
+
+// late returns true if invokeID belongs to an abandoned request.
+func (c *Client) late(invokeID uint32) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.abandoned.remove(invokeID)
+}
+
+// ReadState sends a ReadState request to the server.
+func (c *Client) ReadState(ctx context.Context, r *ams.ReadStateRequest) (*ams.ReadStateResponse, error) {
+	var resp *ams.ReadStateResponse
+	err := c.send(ctx, r, func(r ams.Response) error {
+		if x, ok := r.(*ams.ReadStateResponse); ok {
+			resp = x
+			return nil
+		}
+		return fmt.Errorf("got %T want %T", r, resp)
+	})
+	return resp, err
+}
+
 // ReadWrite sends a ReadWrite request to the server.
 func (c *Client) ReadWrite(ctx context.Context, r *ams.ReadWriteRequest) (*ams.ReadWriteResponse, error) {
 	var resp *ams.ReadWriteResponse
@@ -286,13 +649,10 @@ func (c *Client) Write(ctx context.Context, r *ams.WriteRequest) (*ams.WriteResp
 // GetSymHandleByName returns the offset of a variable.
 func (c *Client) GetSymHandleByName(ctx context.Context, targetID, senderID ams.Addr, name string) (uint32, error) {
 	req := ams.NewReadWriteRequest(targetID, senderID, ams.IdxGetSymHandleByName, 0, 4, []byte(name))
-	res, err := c.ReadWrite(ctx, req)
+	res, err := c.ReadWrite(Idempotent(ctx), req)
 	if err != nil {
 		return 0, fmt.Errorf("failed GetSymHandleByName %s: %s", name, err)
 	}
-	if res.Header().ErrorCode != ams.NoError {
-		return 0, fmt.Errorf("failed ReadWrite: %d", res.Header().ErrorCode)
-	}
 	if len(res.Data) < 4 {
 		return 0, fmt.Errorf("not enough data: %d", len(res.Data))
 	}
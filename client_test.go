@@ -0,0 +1,243 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// delayed returns a server handler which delays the responses by d.
+func delayed(s *testServer, d time.Duration) func(ams.Packet) ams.Packet {
+	return func(req ams.Packet) ams.Packet {
+		time.Sleep(d)
+		return s.respond(req)
+	}
+}
+
+// handlers returns the number of registered response handlers.
+func (c *Client) handlers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.handler)
+}
+
+func TestClientZeroReadTimeout(t *testing.T) {
+	s := newTestServer(t)
+	s.setHandler(delayed(s, 20*time.Millisecond))
+	c := s.dial(t, func(c *Client) { c.ReadTimeout = 0 })
+
+	if _, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientTimeouts(t *testing.T) {
+	tests := []struct {
+		name        string
+		readTimeout time.Duration
+		ctxTimeout  time.Duration
+		err         error
+	}{
+		{"client timeout first", 20 * time.Millisecond, time.Second, ErrTimeout},
+		{"context deadline first", time.Second, 20 * time.Millisecond, context.DeadlineExceeded},
+		{"only context deadline", 0, 20 * time.Millisecond, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.setHandler(func(ams.Packet) ams.Packet { return nil })
+			c := s.dial(t, func(c *Client) { c.ReadTimeout = tt.readTimeout })
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.ctxTimeout)
+			defer cancel()
+			start := time.Now()
+			_, err := c.Read(ctx, ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1))
+			if err != tt.err {
+				t.Fatalf("got %v want %v", err, tt.err)
+			}
+			if d := time.Since(start); d > 500*time.Millisecond {
+				t.Fatalf("request took %v", d)
+			}
+			verify.Values(t, "handlers", c.handlers(), 0)
+		})
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	s := newTestServer(t)
+	s.setHandler(func(ams.Packet) ams.Packet { return nil })
+	c := s.dial(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := c.Read(ctx, ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1)); err != context.Canceled {
+		t.Fatalf("got %v want %v", err, context.Canceled)
+	}
+	verify.Values(t, "handlers", c.handlers(), 0)
+}
+
+func TestClientLateResponse(t *testing.T) {
+	s := newTestServer(t)
+	s.setHandler(delayed(s, 30*time.Millisecond))
+	m := &memInstrumentation{}
+	l := &memLogger{}
+	c := s.dial(t, func(c *Client) {
+		c.ReadTimeout = 10 * time.Millisecond
+		c.Instrumentation = m
+		c.Logger = l
+		c.LogLevel = LevelWarn
+	})
+
+	if _, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1)); err != ErrTimeout {
+		t.Fatalf("got %v want %v", err, ErrTimeout)
+	}
+	waitFor(t, func() bool {
+		for _, e := range m.list() {
+			if e == "late Read" {
+				return true
+			}
+		}
+		return false
+	})
+	// the timeout is logged but not the late response
+	verify.Values(t, "log", l.msgs(), []string{"WARN request timed out"})
+}
+
+func TestClientConnectionLost(t *testing.T) {
+	s := newTestServer(t)
+	s.setHandler(func(ams.Packet) ams.Packet { return nil })
+	c := s.dial(t, func(c *Client) { c.ReadTimeout = 0 })
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1))
+		errc <- err
+	}()
+	waitFor(t, func() bool { return c.handlers() == 1 })
+	c.Close()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("got %v want %v", err, net.ErrClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending request did not fail")
+	}
+	verify.Values(t, "handlers", c.handlers(), 0)
+
+	// further requests fail without waiting for a response
+	waitFor(t, func() bool { return c.ADSState() == ams.ADSStateStop })
+	if _, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("got %v want %v", err, net.ErrClosed)
+	}
+}
+
+func TestClientDialContext(t *testing.T) {
+	s := newTestServer(t)
+	c := &Client{Addr: s.l.Addr().String(), ReadTimeout: time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := c.Dial(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cancel()
+
+	// the connection outlives the context of the dial
+	if _, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	run := c.runContext()
+	if run.Err() != nil {
+		t.Fatal("client context ended with the dial")
+	}
+	c.Close()
+	if run.Err() == nil {
+		t.Fatal("client context not ended by Close")
+	}
+}
+
+func TestClientNotConnected(t *testing.T) {
+	c := &Client{}
+	if _, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1)); err != ErrNotConnected {
+		t.Fatalf("got %v want %v", err, ErrNotConnected)
+	}
+}
+
+func TestClientErrorResponse(t *testing.T) {
+	s := newTestServer(t)
+	s.setHandler(func(req ams.Packet) ams.Packet {
+		// error responses have no payload
+		hdr := req.Header()
+		r := ams.NewRawPacket(hdr.Sender, hdr.Target, hdr.CmdID, ams.StateADSCommand|ams.StateResponse, nil)
+		r.Header().ErrorCode = ams.DeviceServiceNotSupported
+		return r
+	})
+	c := s.dial(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"Read", func() error {
+			_, err := c.Read(ctx, ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1))
+			return err
+		}},
+		{"Write", func() error {
+			_, err := c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, 0x4020, 0, []byte{1}))
+			return err
+		}},
+		{"ReadWrite", func() error {
+			_, err := c.ReadWrite(ctx, ams.NewReadWriteRequest(testTarget, testSender, 0x4020, 0, 1, []byte{1}))
+			return err
+		}},
+		{"ReadState", func() error {
+			_, err := c.ReadState(ctx, ams.NewReadStateRequest(testTarget, testSender))
+			return err
+		}},
+		{"AddDeviceNotification", func() error {
+			_, err := c.AddDeviceNotification(ctx, ams.NewAddDeviceNotificationRequest(testTarget, testSender, 0x4020, 0, 1, ams.TransModeServerOnChange, 0, 0))
+			return err
+		}},
+		{"DeleteDeviceNotification", func() error {
+			_, err := c.DeleteDeviceNotification(ctx, ams.NewDeleteDeviceNotificationRequest(testTarget, testSender, 1))
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var aerr *ADSError
+			if err := tt.call(); !errors.As(err, &aerr) {
+				t.Fatalf("got %v want *ADSError", err)
+			}
+			verify.Values(t, "code", aerr.Code, uint32(ams.DeviceServiceNotSupported))
+		})
+	}
+}
+
+func TestAbandoned(t *testing.T) {
+	var a abandoned
+	for id := uint32(0); id < maxAbandoned+10; id++ {
+		a.add(id)
+	}
+	verify.Values(t, "size", len(a.ids), maxAbandoned)
+	if a.remove(9) {
+		t.Fatal("oldest id is not forgotten")
+	}
+	if !a.remove(10) || a.remove(10) {
+		t.Fatal("id is not removed once")
+	}
+	if !a.remove(maxAbandoned + 9) {
+		t.Fatal("newest id is forgotten")
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/gotwincat/twincat/discovery"
+)
+
+func discover(args []string) error {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	addr := fs.String("addr", discovery.BroadcastAddr, "address of the discovery request")
+	timeout := fs.Duration("timeout", discovery.DefaultTimeout, "time to wait for replies")
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	devices, err := discovery.Discover(ctx, *addr)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NETID\tIP\tHOSTNAME\tTWINCAT\tOS\tFINGERPRINT")
+	for _, d := range devices {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", d.NetIDString(), d.IP, d.Hostname, d.TCVersion, d.OSVersion, d.Fingerprint)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Command twincat is a command line tool for TwinCAT devices.
+//
+// Usage:
+//
+//	twincat discover [-addr 255.255.255.255:48899] [-timeout 2s]
+//	twincat route add -addr <plc> -name <route> -netid <netid> -host <client> [-user u] [-password p]
+//	twincat route del -addr <plc> -name <route> -netid <netid> [-user u] [-password p]
+//	twincat snapshot -addr <plc> -target <ams addr> -sender <ams addr> [-o file] [-format json|yaml] <symbol or glob>...
+//	twincat restore -addr <plc> -target <ams addr> -sender <ams addr> [-partial] [-dry-run] [-format json|yaml] <file>
+//	twincat diff [-format json|yaml] <old> <new>
+//	twincat diff -addr <plc> -target <ams addr> -sender <ams addr> [-format json|yaml] <old>
+//
+// Snapshot files ending in .yaml or .yml are YAML and other files JSON
+// unless -format is set.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// commands maps the name of a sub command to its implementation.
+// The function is called with the remaining arguments.
+var commands = map[string]func(args []string) error{
+	"diff":     diff,
+	"discover": discover,
+	"restore":  restore,
+	"route":    route,
+	"snapshot": snapshot,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: twincat <command> [flags]\n\ncommands:\n")
+	fmt.Fprintf(os.Stderr, "  discover    find TwinCAT devices in the local network\n")
+	fmt.Fprintf(os.Stderr, "  route       add or remove an ADS route on a device\n")
+	fmt.Fprintf(os.Stderr, "  snapshot    save the values of PLC variables to a file\n")
+	fmt.Fprintf(os.Stderr, "  restore     write the values of a snapshot to the PLC\n")
+	fmt.Fprintf(os.Stderr, "  diff        compare two snapshots or a snapshot with the PLC\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "twincat %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
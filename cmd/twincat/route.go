@@ -0,0 +1,66 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/gotwincat/twincat/discovery"
+)
+
+func route(args []string) error {
+	if len(args) < 1 || (args[0] != "add" && args[0] != "del") {
+		return errors.New("usage: twincat route add|del -addr <plc> [flags]")
+	}
+	op := args[0]
+
+	fs := flag.NewFlagSet("route "+op, flag.ExitOnError)
+	addr := fs.String("addr", "", "IP address or hostname of the device")
+	name := fs.String("name", "", "name of the route on the device")
+	netID := fs.String("netid", "", "AMS NetID of the client, e.g. 192.168.0.10.1.1")
+	host := fs.String("host", "", "IP address or hostname of the client")
+	user := fs.String("user", "Administrator", "user name on the device")
+	password := fs.String("password", "", "password on the device, defaults to $TWINCAT_PASSWORD")
+	timeout := fs.Duration("timeout", discovery.DefaultTimeout, "time to wait for the reply")
+	fs.Parse(args[1:])
+
+	if *addr == "" || *name == "" || *netID == "" || (op == "add" && *host == "") {
+		fs.Usage()
+		return errors.New("addr, name, netid and for add also host are required")
+	}
+	a, err := ams.ParseAddr(*netID + ":0")
+	if err != nil {
+		return fmt.Errorf("invalid netid: %s", *netID)
+	}
+	if *password == "" {
+		*password = os.Getenv("TWINCAT_PASSWORD")
+	}
+
+	r := discovery.Route{
+		Name:     *name,
+		NetID:    a.NetID,
+		Host:     *host,
+		UserName: *user,
+		Password: *password,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if op == "add" {
+		err = discovery.AddRoute(ctx, *addr, r)
+	} else {
+		err = discovery.DelRoute(ctx, *addr, r)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("route %q %s on %s\n", *name, map[string]string{"add": "added", "del": "removed"}[op], *addr)
+	return nil
+}
@@ -0,0 +1,283 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gotwincat/twincat"
+	"github.com/gotwincat/twincat/ams"
+)
+
+// plc contains the connection flags of the commands which talk to
+// a PLC.
+type plc struct {
+	addr    *string
+	target  *string
+	sender  *string
+	timeout *time.Duration
+}
+
+func plcFlags(fs *flag.FlagSet) *plc {
+	return &plc{
+		addr:    fs.String("addr", "", "address of the device, the port defaults to 48898"),
+		target:  fs.String("target", "", "AMS address of the PLC, e.g. 192.168.0.2.1.1:851"),
+		sender:  fs.String("sender", "", "AMS address of this client, e.g. 192.168.0.10.1.1:32000"),
+		timeout: fs.Duration("timeout", 10*time.Second, "time limit of the command"),
+	}
+}
+
+// connect dials the device and uploads the symbol table of the PLC.
+func (p *plc) connect(ctx context.Context, policy *twincat.WritePolicy) (*twincat.Client, ams.Addr, ams.Addr, *twincat.SymbolTable, error) {
+	var target, sender ams.Addr
+	if *p.addr == "" || *p.target == "" || *p.sender == "" {
+		return nil, target, sender, nil, errors.New("addr, target and sender are required")
+	}
+	target, err := ams.ParseAddr(*p.target)
+	if err != nil {
+		return nil, target, sender, nil, fmt.Errorf("invalid target: %s", *p.target)
+	}
+	sender, err = ams.ParseAddr(*p.sender)
+	if err != nil {
+		return nil, target, sender, nil, fmt.Errorf("invalid sender: %s", *p.sender)
+	}
+	addr := *p.addr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "48898")
+	}
+
+	c := &twincat.Client{Addr: addr, WritePolicy: policy}
+	if err := c.Dial(ctx); err != nil {
+		return nil, target, sender, nil, err
+	}
+	t, err := c.UploadSymbols(ctx, target, sender)
+	if err != nil {
+		c.Close()
+		return nil, target, sender, nil, err
+	}
+	return c, target, sender, t, nil
+}
+
+// snapshotFormat returns the format of a snapshot file. The format
+// flag has priority over the extension of the file name.
+func snapshotFormat(name, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case "json":
+		return "json", nil
+	case "yaml", "yml":
+		return "yaml", nil
+	case "":
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".yaml", ".yml":
+			return "yaml", nil
+		}
+		return "json", nil
+	default:
+		return "", fmt.Errorf("unknown format %q, want json or yaml", format)
+	}
+}
+
+func snapshot(args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	p := plcFlags(fs)
+	out := fs.String("o", "", "output file, defaults to stdout")
+	format := fs.String("format", "", "json or yaml, defaults to the extension of the output file or json")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no symbols")
+	}
+	typ, err := snapshotFormat(*out, *format)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *p.timeout)
+	defer cancel()
+	c, target, sender, t, err := p.connect(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	s, err := c.Snapshot(ctx, target, sender, t, fs.Args()...)
+	if err != nil {
+		return err
+	}
+	write := s.WriteTo
+	if typ == "yaml" {
+		write = s.WriteYAML
+	}
+	if *out == "" {
+		_, err = write(os.Stdout)
+		return err
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if _, err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readSnapshot(name, format string) (*twincat.Snapshot, error) {
+	typ, err := snapshotFormat(name, format)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if typ == "yaml" {
+		return twincat.ReadSnapshotYAML(f)
+	}
+	return twincat.ReadSnapshot(f)
+}
+
+func restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	p := plcFlags(fs)
+	partial := fs.Bool("partial", false, "write the valid items even if other items are invalid")
+	dryRun := fs.Bool("dry-run", false, "validate the items but do not write them")
+	batch := fs.Int("batch", 0, "maximum number of items per sum write, defaults to 500")
+	batchBytes := fs.Int("batch-bytes", 0, "maximum number of bytes per sum write, defaults to 65536")
+	format := fs.String("format", "", "json or yaml, defaults to the extension of the file")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("usage: twincat restore [flags] <file>")
+	}
+
+	s, err := readSnapshot(fs.Arg(0), *format)
+	if err != nil {
+		return err
+	}
+	var policy *twincat.WritePolicy
+	if *dryRun {
+		policy = &twincat.WritePolicy{DryRun: true}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *p.timeout)
+	defer cancel()
+	c, target, sender, t, err := p.connect(ctx, policy)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	results, err := c.Restore(ctx, target, sender, t, s, twincat.RestoreOptions{Partial: *partial, BatchSize: *batch, BatchBytes: *batchBytes})
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tRESULT")
+	for _, r := range results {
+		result := "ok"
+		if r.Err != nil {
+			result = r.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\n", r.Path, result)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return err
+}
+
+func diff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	p := plcFlags(fs)
+	format := fs.String("format", "", "json or yaml, defaults to the extension of the files")
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return errors.New("usage: twincat diff <old> <new> or twincat diff -addr <plc> [flags] <old>")
+	}
+
+	a, err := readSnapshot(fs.Arg(0), *format)
+	if err != nil {
+		return err
+	}
+	var b *twincat.Snapshot
+	if fs.NArg() == 2 {
+		if b, err = readSnapshot(fs.Arg(1), *format); err != nil {
+			return err
+		}
+	} else {
+		// compare with the current values of the PLC
+		ctx, cancel := context.WithTimeout(context.Background(), *p.timeout)
+		defer cancel()
+		c, target, sender, t, err := p.connect(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		b = liveSnapshot(ctx, c, target, sender, t, a)
+	}
+
+	diffs, err := twincat.DiffSnapshots(a, b)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tOLD\tNEW")
+	for _, d := range diffs {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", d.Path, diffValue(d.OldType, d.Old), diffValue(d.NewType, d.New))
+	}
+	return w.Flush()
+}
+
+// liveSnapshot reads the current values of the items of s. Items which
+// cannot be resolved or read are reported on stderr and left out so
+// that the diff shows them as removed.
+func liveSnapshot(ctx context.Context, c *twincat.Client, target, sender ams.Addr, t *twincat.SymbolTable, s *twincat.Snapshot) *twincat.Snapshot {
+	var paths []string
+	for _, item := range s.Items {
+		if _, err := c.ResolveSymbol(ctx, target, sender, t, item.Path); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", item.Path, err)
+			continue
+		}
+		paths = append(paths, item.Path)
+	}
+	b, err := c.Snapshot(ctx, target, sender, t, paths...)
+	if err == nil {
+		return b
+	}
+
+	// read the items one by one to find the ones which fail
+	b = &twincat.Snapshot{Version: twincat.SnapshotVersion, Time: time.Now().UTC(), Target: target.String()}
+	for _, p := range paths {
+		x, err := c.Snapshot(ctx, target, sender, t, p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", p, err)
+			continue
+		}
+		b.Items = append(b.Items, x.Items...)
+	}
+	return b
+}
+
+// diffValue formats a value of a diff as JSON.
+func diffValue(typ string, v interface{}) string {
+	if typ == "" {
+		return "-"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
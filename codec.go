@@ -0,0 +1,50 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+)
+
+// encodeValue encodes a value in the memory layout of the PLC. Fixed
+// size values like bool, sized integers, floats and arrays and
+// structs of them are encoded with encoding/binary in little endian.
+// Strings are encoded with a terminating zero byte.
+func encodeValue(v interface{}) ([]byte, error) {
+	if s, ok := v.(string); ok {
+		return append([]byte(s), 0), nil
+	}
+	if binary.Size(v) < 0 {
+		return nil, fmt.Errorf("unsupported type %T", v)
+	}
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// decodeValue decodes b into the value v points to. Strings end at
+// the first zero byte.
+func decodeValue(b []byte, v interface{}) error {
+	if s, ok := v.(*string); ok {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		*s = string(b)
+		return nil
+	}
+	n := binary.Size(v)
+	if n < 0 || reflect.ValueOf(v).Kind() != reflect.Ptr {
+		return fmt.Errorf("unsupported type %T", v)
+	}
+	if len(b) < n {
+		return fmt.Errorf("got %d bytes want %d for %T", len(b), n, v)
+	}
+	return binary.Read(bytes.NewReader(b), binary.LittleEndian, v)
+}
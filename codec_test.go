@@ -0,0 +1,59 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestCodec(t *testing.T) {
+	type point struct {
+		X, Y int16
+		On   bool
+	}
+	tests := []struct {
+		name string
+		v    interface{}
+		b    []byte
+		ptr  interface{}
+	}{
+		{"bool", true, []byte{1}, new(bool)},
+		{"int16", int16(-2), []byte{0xfe, 0xff}, new(int16)},
+		{"uint32", uint32(0x01020304), []byte{4, 3, 2, 1}, new(uint32)},
+		{"float32", float32(1.5), []byte{0, 0, 0xc0, 0x3f}, new(float32)},
+		{"array", [2]uint8{1, 2}, []byte{1, 2}, new([2]uint8)},
+		{"struct", point{1, 2, true}, []byte{1, 0, 2, 0, 1}, new(point)},
+		{"string", "ab", []byte{'a', 'b', 0}, new(string)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := encodeValue(tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			verify.Values(t, "bytes", b, tt.b)
+			if err := decodeValue(b, tt.ptr); err != nil {
+				t.Fatal(err)
+			}
+			verify.Values(t, "value", reflect.ValueOf(tt.ptr).Elem().Interface(), tt.v)
+		})
+	}
+}
+
+func TestCodecErrors(t *testing.T) {
+	if _, err := encodeValue(1); err == nil {
+		t.Fatal("int encoded")
+	}
+	var v uint32
+	if err := decodeValue([]byte{1, 2}, &v); err == nil {
+		t.Fatal("short data decoded")
+	}
+	if err := decodeValue([]byte{1, 2, 3, 4}, v); err == nil {
+		t.Fatal("decoded into non-pointer")
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package discovery finds TwinCAT devices in the local network and
+// manages their ADS routes with the UDP service of the TwinCAT system
+// service on port 48899.
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// Port is the UDP port of the TwinCAT system service.
+const Port = 48899
+
+// BroadcastAddr is the default address of the discovery request.
+var BroadcastAddr = fmt.Sprintf("255.255.255.255:%d", Port)
+
+// DefaultTimeout is used by Discover when the context has no deadline.
+const DefaultTimeout = 2 * time.Second
+
+// Version is a TwinCAT version.
+type Version struct {
+	Major, Minor uint8
+	Build        uint16
+}
+
+// String returns the version as major.minor.build.
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Build)
+}
+
+// OSVersion is the version of the operating system of a device.
+type OSVersion struct {
+	Major, Minor, Build uint32
+	Platform            uint32
+	ServicePack         string
+}
+
+// String returns the version as major.minor.build followed by
+// the service pack if set.
+func (v OSVersion) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Build)
+	if v.ServicePack != "" {
+		s += " " + v.ServicePack
+	}
+	return s
+}
+
+// Device is a TwinCAT device which replied to a discovery request.
+type Device struct {
+	// IP is the address the reply was sent from.
+	IP net.IP
+
+	// NetID is the AMS NetID of the device.
+	NetID []byte
+
+	Hostname  string
+	TCVersion Version
+	OSVersion OSVersion
+
+	// Fingerprint is the fingerprint of the Secure ADS certificate.
+	// It is empty for devices without Secure ADS.
+	Fingerprint string
+}
+
+// NetIDString returns the NetID as a.b.c.d.e.f.
+func (d *Device) NetIDString() string {
+	a := d.Addr(0).String()
+	return a[:len(a)-len(":0")]
+}
+
+// Addr returns the AMS address of the device with the port.
+func (d *Device) Addr(port uint16) ams.Addr {
+	return ams.Addr{NetID: d.NetID, Port: port}
+}
+
+// Discover sends a discovery request to addr and collects the
+// replies until ctx is done. addr is usually a broadcast address
+// and BroadcastAddr is used if addr is empty. If ctx has no
+// deadline, DefaultTimeout is used.
+//
+// Every device is returned once. The devices which replied before
+// ctx was canceled are returned together with the error of ctx.
+// Reaching the deadline is not an error.
+func Discover(ctx context.Context, addr string) ([]*Device, error) {
+	if addr == "" {
+		addr = BroadcastAddr
+	}
+	raddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// unblock the read when the context is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	req := &message{
+		Service: serviceDiscover,
+		Addr:    ams.Addr{NetID: make([]byte, 6), Port: systemServicePort},
+	}
+	var b ams.Buffer
+	if err := req.Encode(&b); err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP(b.Bytes(), raddr); err != nil {
+		return nil, err
+	}
+
+	var devices []*Device
+	seen := map[string]bool{}
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return devices, err
+		}
+		d, err := parseDevice(buf[:n])
+		if err != nil {
+			// ignore invalid replies and other messages
+			continue
+		}
+		d.IP = from.IP
+		if k := string(d.NetID); !seen[k] {
+			seen[k] = true
+			devices = append(devices, d)
+		}
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return devices, nil
+	}
+	return devices, ctx.Err()
+}
+
+// parseDevice parses a reply to a discovery request.
+func parseDevice(p []byte) (*Device, error) {
+	var m message
+	if err := m.Decode(ams.NewBuffer(p)); err != nil {
+		return nil, err
+	}
+	if m.Service != serviceDiscover|serviceResponse {
+		return nil, errInvalidMessage
+	}
+
+	d := &Device{NetID: m.Addr.NetID}
+	if b, ok := m.tag(tagHostname); ok {
+		d.Hostname = cstring(b)
+	}
+	if b, ok := m.tag(tagTCVersion); ok && len(b) >= 4 {
+		d.TCVersion = Version{Major: b[0], Minor: b[1], Build: binary.LittleEndian.Uint16(b[2:])}
+	}
+	if b, ok := m.tag(tagOSVersion); ok && len(b) >= 20 {
+		// OSVERSIONINFO: size, major, minor, build, platform and
+		// the service pack as UTF-16 string
+		d.OSVersion = OSVersion{
+			Major:       binary.LittleEndian.Uint32(b[4:]),
+			Minor:       binary.LittleEndian.Uint32(b[8:]),
+			Build:       binary.LittleEndian.Uint32(b[12:]),
+			Platform:    binary.LittleEndian.Uint32(b[16:]),
+			ServicePack: wstring(b[20:]),
+		}
+	}
+	if b, ok := m.tag(tagFingerprint); ok {
+		d.Fingerprint = cstring(b)
+	}
+	return d, nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package discovery
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// responder answers the requests on a local UDP port with the
+// replies returned by reply.
+func responder(t *testing.T, reply func(req *message) [][]byte) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var req message
+			if err := req.Decode(ams.NewBuffer(buf[:n])); err != nil {
+				t.Errorf("responder: %s", err)
+				return
+			}
+			for _, p := range reply(&req) {
+				conn.WriteTo(p, from)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func encode(t *testing.T, m *message) []byte {
+	t.Helper()
+	var b ams.Buffer
+	if err := m.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// osVersion returns an OSVERSIONINFO structure.
+func osVersion(major, minor, build, platform uint32, sp string) []byte {
+	b := make([]byte, 20)
+	binary.LittleEndian.PutUint32(b[0:], 20+2*uint32(len(sp)+1))
+	binary.LittleEndian.PutUint32(b[4:], major)
+	binary.LittleEndian.PutUint32(b[8:], minor)
+	binary.LittleEndian.PutUint32(b[12:], build)
+	binary.LittleEndian.PutUint32(b[16:], platform)
+	for _, c := range utf16.Encode([]rune(sp)) {
+		b = append(b, byte(c), byte(c>>8))
+	}
+	return append(b, 0, 0)
+}
+
+func TestDiscover(t *testing.T) {
+	reqs := make(chan *message, 1)
+	addr := responder(t, func(req *message) [][]byte {
+		reqs <- req
+		reply := encode(t, &message{
+			Service: serviceDiscover | serviceResponse,
+			Addr:    ams.MustParseAddr("10.0.0.1.1.1:10000"),
+			Tags: []tag{
+				stringTag(tagHostname, "plc1"),
+				{ID: tagTCVersion, Data: []byte{3, 1, 0xB8, 0x0F}},
+				{ID: tagOSVersion, Data: osVersion(10, 0, 19044, 2, "SP1")},
+				stringTag(tagFingerprint, "ab12"),
+			},
+		})
+		other := encode(t, &message{
+			Service: serviceDiscover | serviceResponse,
+			Addr:    ams.MustParseAddr("10.0.0.2.1.1:10000"),
+			Tags:    []tag{stringTag(tagHostname, "plc2")},
+		})
+		return [][]byte{
+			[]byte("garbage"),
+			reply,
+			reply, // duplicate
+			other,
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	devices, err := Discover(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify.Values(t, "request", <-reqs, &message{
+		Service: serviceDiscover,
+		Addr:    ams.Addr{NetID: make([]byte, 6), Port: systemServicePort},
+	})
+	verify.Values(t, "devices", devices, []*Device{
+		{
+			IP:          net.IPv4(127, 0, 0, 1).To4(),
+			NetID:       []byte{10, 0, 0, 1, 1, 1},
+			Hostname:    "plc1",
+			TCVersion:   Version{3, 1, 4024},
+			OSVersion:   OSVersion{Major: 10, Minor: 0, Build: 19044, Platform: 2, ServicePack: "SP1"},
+			Fingerprint: "ab12",
+		},
+		{
+			IP:       net.IPv4(127, 0, 0, 1).To4(),
+			NetID:    []byte{10, 0, 0, 2, 1, 1},
+			Hostname: "plc2",
+		},
+	})
+	verify.Values(t, "version", devices[0].TCVersion.String(), "3.1.4024")
+	verify.Values(t, "os version", devices[0].OSVersion.String(), "10.0.19044 SP1")
+}
+
+func TestDiscoverCanceled(t *testing.T) {
+	addr := responder(t, func(*message) [][]byte { return nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	devices, err := Discover(ctx, addr)
+	if err != context.Canceled {
+		t.Fatalf("got %v want %v", err, context.Canceled)
+	}
+	if len(devices) != 0 {
+		t.Fatalf("got %d devices want 0", len(devices))
+	}
+}
+
+func TestMessageDecodeInvalid(t *testing.T) {
+	valid := encode(t, &message{
+		Service: serviceDiscover,
+		Addr:    ams.MustParseAddr("1.2.3.4.5.6:10000"),
+		Tags:    []tag{stringTag(tagHostname, "x")},
+	})
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"magic", append([]byte{0, 0, 0, 0}, valid[4:]...)},
+		{"short", valid[:len(valid)-1]},
+		{"tag count", append(append(append([]byte{}, valid[:20]...), 0xFF, 0xFF, 0, 0), valid[24:]...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m message
+			if err := m.Decode(ams.NewBuffer(tt.b)); err == nil {
+				t.Fatal("got nil want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package discovery
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// magic is the first field of every message.
+const magic = 0x71146603
+
+// Services of the UDP service. A response has the service
+// id of the request with the serviceResponse bit set.
+const (
+	serviceDiscover = 1
+	serviceAddRoute = 6
+	serviceDelRoute = 7
+
+	serviceResponse = 0x80000000
+)
+
+// Tags of the message fields.
+const (
+	tagStatus      = 0x01
+	tagPassword    = 0x02
+	tagTCVersion   = 0x03
+	tagOSVersion   = 0x04
+	tagHostname    = 0x05
+	tagNetID       = 0x07
+	tagRouteName   = 0x0C
+	tagUserName    = 0x0D
+	tagFingerprint = 0x12
+)
+
+// systemServicePort is the AMS port of the system service
+// which is used as sender port.
+const systemServicePort = 10000
+
+var errInvalidMessage = errors.New("discovery: invalid message")
+
+// message is a request or response of the UDP service. It has the
+// following layout (little endian):
+//
+//	uint32     magic
+//	uint32     invoke id
+//	uint32     service
+//	[6]byte    AMS NetID
+//	uint16     AMS port
+//	uint32     number of tags
+//	           tags:
+//	             uint16 tag, uint16 length, data
+type message struct {
+	InvokeID uint32
+	Service  uint32
+	Addr     ams.Addr
+	Tags     []tag
+}
+
+type tag struct {
+	ID   uint16
+	Data []byte
+}
+
+func (m *message) Encode(b *ams.Buffer) error {
+	if len(m.Addr.NetID) != 6 {
+		return fmt.Errorf("discovery: invalid netid %v", m.Addr.NetID)
+	}
+	b.WriteUint32(magic)
+	b.WriteUint32(m.InvokeID)
+	b.WriteUint32(m.Service)
+	b.WriteStruct(&m.Addr)
+	b.WriteUint32(uint32(len(m.Tags)))
+	for _, t := range m.Tags {
+		if len(t.Data) > 0xFFFF {
+			return fmt.Errorf("discovery: tag %d too long", t.ID)
+		}
+		b.WriteUint16(t.ID)
+		b.WriteUint16(uint16(len(t.Data)))
+		b.Write(t.Data)
+	}
+	return b.Err()
+}
+
+func (m *message) Decode(b *ams.Buffer) error {
+	if b.ReadUint32() != magic && b.Err() == nil {
+		return errInvalidMessage
+	}
+	m.InvokeID = b.ReadUint32()
+	m.Service = b.ReadUint32()
+	b.ReadStruct(&m.Addr)
+	n := int(b.ReadUint32())
+	// every tag has at least four bytes
+	if b.Err() == nil && n > b.Len()/4 {
+		return errInvalidMessage
+	}
+	m.Tags = nil
+	for i := 0; i < n && b.Err() == nil; i++ {
+		var t tag
+		t.ID = b.ReadUint16()
+		t.Data = b.ReadN(int(b.ReadUint16()))
+		m.Tags = append(m.Tags, t)
+	}
+	return b.Err()
+}
+
+// tag returns the data of the first tag with the id.
+func (m *message) tag(id uint16) ([]byte, bool) {
+	for _, t := range m.Tags {
+		if t.ID == id {
+			return t.Data, true
+		}
+	}
+	return nil, false
+}
+
+// stringTag returns a tag for a zero terminated string.
+func stringTag(id uint16, s string) tag {
+	return tag{ID: id, Data: append([]byte(s), 0)}
+}
+
+// cstring returns the string up to the first zero byte.
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// wstring returns the UTF-16 string up to the first zero character.
+func wstring(b []byte) string {
+	var u []uint16
+	for i := 0; i+1 < len(b); i += 2 {
+		c := uint16(b[i]) | uint16(b[i+1])<<8
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
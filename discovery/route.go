@@ -0,0 +1,158 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package discovery
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// Route is a static ADS route on a remote device. The route allows
+// the client with NetID at Host to connect to the device.
+type Route struct {
+	// Name is the name of the route on the device.
+	Name string
+
+	// NetID is the AMS NetID of the client.
+	NetID []byte
+
+	// Host is the IP address or hostname of the client.
+	Host string
+
+	// UserName and Password are the credentials of an administrator
+	// of the remote device.
+	UserName string
+	Password string
+}
+
+// RouteError is returned when the device rejects a route request.
+// Code is the ADS error code of the reply.
+type RouteError struct {
+	Code uint32
+}
+
+func (e *RouteError) Error() string {
+	return fmt.Sprintf("discovery: route request failed with error 0x%x", e.Code)
+}
+
+// AddRoute adds the route on the device at addr. addr is the IP
+// address or hostname of the device with an optional port. If ctx
+// has no deadline, DefaultTimeout is used.
+func AddRoute(ctx context.Context, addr string, r Route) error {
+	return routeRequest(ctx, addr, serviceAddRoute, r)
+}
+
+// DelRoute removes the route with the name of r from the device
+// at addr. Host is not required.
+func DelRoute(ctx context.Context, addr string, r Route) error {
+	return routeRequest(ctx, addr, serviceDelRoute, r)
+}
+
+func routeRequest(ctx context.Context, addr string, service uint32, r Route) error {
+	if len(r.NetID) != 6 {
+		return fmt.Errorf("discovery: invalid netid %v", r.NetID)
+	}
+	tags := []tag{
+		stringTag(tagRouteName, r.Name),
+		{ID: tagNetID, Data: r.NetID},
+		stringTag(tagUserName, r.UserName),
+		stringTag(tagPassword, r.Password),
+	}
+	if service == serviceAddRoute {
+		tags = append(tags, stringTag(tagHostname, r.Host))
+	}
+	req := &message{
+		InvokeID: newInvokeID(),
+		Service:  service,
+		Addr:     ams.Addr{NetID: r.NetID, Port: systemServicePort},
+		Tags:     tags,
+	}
+
+	resp, err := roundTrip(ctx, addr, req)
+	if err != nil {
+		return err
+	}
+	b, ok := resp.tag(tagStatus)
+	if !ok || len(b) < 4 {
+		return errInvalidMessage
+	}
+	if code := binary.LittleEndian.Uint32(b); code != ams.NoError {
+		return &RouteError{Code: code}
+	}
+	return nil
+}
+
+// newInvokeID returns a random invoke ID so that concurrent processes
+// do not match the responses of each other. The global source of
+// math/rand has the same seed in every process.
+func newInvokeID() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uint32(time.Now().UnixNano())
+	}
+	return binary.LittleEndian.Uint32(b[:])
+}
+
+// roundTrip sends req to the UDP service at addr and waits for the
+// response with the same invoke id.
+func roundTrip(ctx context.Context, addr string, req *message) (*message, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, fmt.Sprint(Port))
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// unblock the read when the context is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	var b ams.Buffer
+	if err := req.Encode(&b); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(b.Bytes()); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		var resp message
+		if err := resp.Decode(ams.NewBuffer(buf[:n])); err != nil {
+			continue
+		}
+		if resp.InvokeID == req.InvokeID && resp.Service == req.Service|serviceResponse {
+			return &resp, nil
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// routeResponder replies to route requests with status code.
+// The requests are sent to reqs.
+func routeResponder(t *testing.T, code uint32, reqs chan<- *message) string {
+	return responder(t, func(req *message) [][]byte {
+		reqs <- req
+		reply := func(invokeID uint32) []byte {
+			return encode(t, &message{
+				InvokeID: invokeID,
+				Service:  req.Service | serviceResponse,
+				Addr:     ams.MustParseAddr("10.0.0.1.1.1:10000"),
+				Tags:     []tag{{ID: tagStatus, Data: []byte{byte(code), byte(code >> 8), 0, 0}}},
+			})
+		}
+		// a reply for a different request is ignored
+		return [][]byte{reply(req.InvokeID + 1), reply(req.InvokeID)}
+	})
+}
+
+var testRoute = Route{
+	Name:     "client",
+	NetID:    []byte{192, 168, 0, 10, 1, 1},
+	Host:     "192.168.0.10",
+	UserName: "Administrator",
+	Password: "1",
+}
+
+func TestAddRoute(t *testing.T) {
+	reqs := make(chan *message, 1)
+	addr := routeResponder(t, 0, reqs)
+
+	if err := AddRoute(context.Background(), addr, testRoute); err != nil {
+		t.Fatal(err)
+	}
+	req := <-reqs
+	verify.Values(t, "request", req, &message{
+		InvokeID: req.InvokeID,
+		Service:  serviceAddRoute,
+		Addr:     ams.Addr{NetID: testRoute.NetID, Port: systemServicePort},
+		Tags: []tag{
+			stringTag(tagRouteName, "client"),
+			{ID: tagNetID, Data: testRoute.NetID},
+			stringTag(tagUserName, "Administrator"),
+			stringTag(tagPassword, "1"),
+			stringTag(tagHostname, "192.168.0.10"),
+		},
+	})
+}
+
+func TestDelRoute(t *testing.T) {
+	reqs := make(chan *message, 1)
+	addr := routeResponder(t, 0, reqs)
+
+	if err := DelRoute(context.Background(), addr, testRoute); err != nil {
+		t.Fatal(err)
+	}
+	req := <-reqs
+	verify.Values(t, "request", req, &message{
+		InvokeID: req.InvokeID,
+		Service:  serviceDelRoute,
+		Addr:     ams.Addr{NetID: testRoute.NetID, Port: systemServicePort},
+		Tags: []tag{
+			stringTag(tagRouteName, "client"),
+			{ID: tagNetID, Data: testRoute.NetID},
+			stringTag(tagUserName, "Administrator"),
+			stringTag(tagPassword, "1"),
+		},
+	})
+}
+
+func TestAddRouteError(t *testing.T) {
+	reqs := make(chan *message, 1)
+	addr := routeResponder(t, 0x704, reqs)
+
+	err := AddRoute(context.Background(), addr, testRoute)
+	var rerr *RouteError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("got %v want *RouteError", err)
+	}
+	verify.Values(t, "code", rerr.Code, uint32(0x704))
+}
+
+func TestAddRouteTimeout(t *testing.T) {
+	addr := responder(t, func(*message) [][]byte { return nil })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := AddRoute(ctx, addr, testRoute); err != context.DeadlineExceeded {
+		t.Fatalf("got %v want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType is the type of an event.
+type EventType int
+
+const (
+	// EventStateChange is emitted when the ADS state or the device
+	// state of the target changes, e.g. from RUN to STOP.
+	EventStateChange EventType = iota + 1
+
+	// EventUnhealthy is emitted when the heartbeat has missed too
+	// many beats.
+	EventUnhealthy
+
+	// EventHealthy is emitted when the heartbeat succeeds again
+	// after the connection was unhealthy.
+	EventHealthy
+
+	// EventReconnect is emitted after the client has dialed again.
+	// Err is set if the dial failed.
+	EventReconnect
+
+	// EventSymbolVersion is emitted when the symbol version of the
+	// target has changed, e.g. after an online change. Err is set if
+	// the variables or subscriptions could not be resolved again.
+	EventSymbolVersion
+
+	// EventSubscriptionFailed is emitted when a subscription could
+	// not be added again after a reconnect or a change of the symbol
+	// version. The subscription stays inactive until it is added
+	// again. Subscription and Err are set.
+	EventSubscriptionFailed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventStateChange:
+		return "StateChange"
+	case EventUnhealthy:
+		return "Unhealthy"
+	case EventHealthy:
+		return "Healthy"
+	case EventReconnect:
+		return "Reconnect"
+	case EventSymbolVersion:
+		return "SymbolVersion"
+	case EventSubscriptionFailed:
+		return "SubscriptionFailed"
+	default:
+		return fmt.Sprintf("EventType(%d)", int(t))
+	}
+}
+
+// Event describes a change of the connection or the target.
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	// OldADSState and NewADSState are set for EventStateChange.
+	OldADSState, NewADSState uint16
+
+	// OldDeviceState and NewDeviceState are set for EventStateChange.
+	OldDeviceState, NewDeviceState uint16
+
+	// OldSymbolVersion and NewSymbolVersion are set for
+	// EventSymbolVersion.
+	OldSymbolVersion, NewSymbolVersion uint8
+
+	// Subscription is set for EventSubscriptionFailed.
+	Subscription *Subscription
+
+	// Err is the error which caused the event, if any.
+	Err error
+}
+
+// emit sends the event to the OnEvent callback.
+func (c *Client) emit(e Event) {
+	if c.OnEvent == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	c.OnEvent(e)
+}
@@ -0,0 +1,228 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// Heartbeat configures periodic ReadState requests which detect
+// broken connections, e.g. half-open TCP connections, and state
+// changes of the target.
+type Heartbeat struct {
+	// Target and Sender are the AMS addresses of the ReadState
+	// requests.
+	Target, Sender ams.Addr
+
+	// Interval is the time between two beats. If zero, one second
+	// is used.
+	Interval time.Duration
+
+	// Timeout is the time to wait for the response of a beat.
+	// If zero, the Interval is used.
+	Timeout time.Duration
+
+	// MaxMissed is the number of consecutive missed beats after
+	// which the connection is unhealthy and the client dials
+	// again. If zero, 3 is used.
+	MaxMissed int
+}
+
+// Health is a snapshot of the connection health which is
+// maintained by the heartbeat.
+type Health struct {
+	// Healthy is false after MaxMissed consecutive missed beats
+	// until a beat succeeds again.
+	Healthy bool
+
+	// LastBeat is the time of the last successful beat and LastRTT
+	// is its round trip time.
+	LastBeat time.Time
+	LastRTT  time.Duration
+
+	// ADSState and DeviceState are the last states of the target.
+	ADSState    uint16
+	DeviceState uint16
+
+	// ConsecutiveFailures is the number of missed beats since the
+	// last successful beat and LastErr is the error of the last
+	// missed beat.
+	ConsecutiveFailures int
+	LastErr             error
+}
+
+// heartbeat holds the state of a running heartbeat.
+type heartbeat struct {
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu     sync.Mutex
+	health Health
+	beats  int // number of successful beats
+}
+
+// Health returns the current health of the connection. Without
+// a heartbeat only Healthy is set after a successful Dial.
+func (c *Client) Health() Health {
+	c.mu.Lock()
+	hb := c.hb
+	c.mu.Unlock()
+	if hb == nil {
+		return Health{Healthy: c.connection() != nil}
+	}
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	return hb.health
+}
+
+func (c *Client) startHeartbeat() {
+	if c.Heartbeat == nil {
+		return
+	}
+	hb := &heartbeat{stop: make(chan struct{}), health: Health{Healthy: true}}
+	c.mu.Lock()
+	if c.hb != nil && !c.hb.stopped() {
+		c.mu.Unlock()
+		return
+	}
+	c.hb = hb
+	c.mu.Unlock()
+	go c.runHeartbeat(hb)
+}
+
+func (hb *heartbeat) stopped() bool {
+	select {
+	case <-hb.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) stopHeartbeat() {
+	c.mu.Lock()
+	hb := c.hb
+	c.mu.Unlock()
+	if hb != nil {
+		hb.stopOnce.Do(func() { close(hb.stop) })
+	}
+}
+
+func (c *Client) runHeartbeat(hb *heartbeat) {
+	interval := c.Heartbeat.Interval
+	if interval == 0 {
+		interval = time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-hb.stop:
+			return
+		case <-t.C:
+			c.beat(hb, interval)
+		}
+	}
+}
+
+// beat sends a single ReadState request and updates the health.
+func (c *Client) beat(hb *heartbeat, interval time.Duration) {
+	cfg := c.Heartbeat
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = interval
+	}
+	maxMissed := cfg.MaxMissed
+	if maxMissed == 0 {
+		maxMissed = 3
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	resp, err := c.ReadState(ctx, ams.NewReadStateRequest(cfg.Target, cfg.Sender))
+	rtt := time.Since(start)
+
+	var events []Event
+	reconnect := false
+
+	hb.mu.Lock()
+	h := &hb.health
+	switch {
+	case err != nil:
+		h.ConsecutiveFailures++
+		h.LastErr = err
+		if h.ConsecutiveFailures >= maxMissed {
+			if h.Healthy {
+				h.Healthy = false
+				events = append(events, Event{Type: EventUnhealthy, Err: err})
+			}
+			reconnect = true
+		}
+	default:
+		wasHealthy := h.Healthy
+		h.Healthy = true
+		h.ConsecutiveFailures = 0
+		h.LastErr = nil
+		h.LastBeat = start
+		h.LastRTT = rtt
+		if !wasHealthy {
+			events = append(events, Event{Type: EventHealthy})
+		}
+		// an ADS error still proves that the connection works
+		// but the states of the response are not valid.
+		if resp.Header().ErrorCode == ams.NoError && resp.Result == ams.NoError {
+			if hb.beats > 0 && (resp.ADSState != h.ADSState || resp.DeviceState != h.DeviceState) {
+				events = append(events, Event{
+					Type:           EventStateChange,
+					OldADSState:    h.ADSState,
+					NewADSState:    resp.ADSState,
+					OldDeviceState: h.DeviceState,
+					NewDeviceState: resp.DeviceState,
+				})
+			}
+			h.ADSState, h.DeviceState = resp.ADSState, resp.DeviceState
+			hb.beats++
+		}
+	}
+	hb.mu.Unlock()
+
+	for _, e := range events {
+		if e.Type == EventUnhealthy {
+			c.log(ctx, LevelWarn, "connection unhealthy", "addr", c.Addr, "err", e.Err)
+		}
+		c.emit(e)
+		if e.Type == EventStateChange && e.NewADSState == ams.ADSStateRun {
+			go c.restarted()
+		}
+	}
+	if reconnect {
+		c.reconnect(hb, interval+timeout)
+	}
+}
+
+// reconnect closes the current connection and dials again unless
+// the client was closed. The dial is limited to timeout.
+func (c *Client) reconnect(hb *heartbeat, timeout time.Duration) {
+	if hb.stopped() {
+		return
+	}
+	if conn := c.connection(); conn != nil {
+		conn.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := c.Dial(ctx)
+	if err != nil {
+		c.log(ctx, LevelWarn, "reconnect failed", "addr", c.Addr, "err", err)
+	} else {
+		c.log(ctx, LevelInfo, "reconnected", "addr", c.Addr)
+	}
+	c.emit(Event{Type: EventReconnect, Err: err})
+}
@@ -0,0 +1,111 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// withEvents returns a client option which sends the events to a channel.
+func withEvents(events chan<- Event) func(c *Client) {
+	return func(c *Client) {
+		c.OnEvent = func(e Event) {
+			select {
+			case events <- e:
+			default:
+			}
+		}
+	}
+}
+
+// nextEvent returns the next event of type typ and skips all other events.
+func nextEvent(t *testing.T, events <-chan Event, typ EventType) Event {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Type == typ {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("no %s event", typ)
+		}
+	}
+}
+
+func TestHeartbeatStateChange(t *testing.T) {
+	s := newTestServer(t)
+	events := make(chan Event, 100)
+	c := s.dial(t, withEvents(events), func(c *Client) {
+		c.Heartbeat = &Heartbeat{Target: testTarget, Sender: testSender, Interval: 10 * time.Millisecond}
+	})
+
+	waitFor(t, func() bool { return !c.Health().LastBeat.IsZero() })
+
+	s.mu.Lock()
+	s.state = ams.ADSStateStop
+	s.mu.Unlock()
+
+	e := nextEvent(t, events, EventStateChange)
+	verify.Values(t, "ads state", []uint16{e.OldADSState, e.NewADSState}, []uint16{ams.ADSStateRun, ams.ADSStateStop})
+
+	h := c.Health()
+	if !h.Healthy || h.ADSState != ams.ADSStateStop || h.LastRTT <= 0 || h.ConsecutiveFailures != 0 {
+		t.Fatalf("unexpected health %+v", h)
+	}
+}
+
+func TestHeartbeatReconnect(t *testing.T) {
+	var drop int32
+	s := newTestServer(t)
+	s.setHandler(func(req ams.Packet) ams.Packet {
+		if atomic.LoadInt32(&drop) == 1 {
+			return nil
+		}
+		return s.respond(req)
+	})
+
+	events := make(chan Event, 100)
+	c := s.dial(t, withEvents(events), func(c *Client) {
+		c.Heartbeat = &Heartbeat{
+			Target:    testTarget,
+			Sender:    testSender,
+			Interval:  10 * time.Millisecond,
+			Timeout:   10 * time.Millisecond,
+			MaxMissed: 2,
+		}
+	})
+	waitFor(t, func() bool { return !c.Health().LastBeat.IsZero() })
+
+	atomic.StoreInt32(&drop, 1)
+	e := nextEvent(t, events, EventUnhealthy)
+	if e.Err != context.DeadlineExceeded {
+		t.Fatalf("got %v want %v", e.Err, context.DeadlineExceeded)
+	}
+	if h := c.Health(); h.Healthy || h.ConsecutiveFailures < 2 || h.LastErr == nil {
+		t.Fatalf("unexpected health %+v", h)
+	}
+	if e := nextEvent(t, events, EventReconnect); e.Err != nil {
+		t.Fatal(e.Err)
+	}
+
+	atomic.StoreInt32(&drop, 0)
+	nextEvent(t, events, EventHealthy)
+	if h := c.Health(); !h.Healthy || h.ConsecutiveFailures != 0 {
+		t.Fatalf("unexpected health %+v", h)
+	}
+
+	// the reconnected client works
+	if _, err := c.ReadState(context.Background(), ams.NewReadStateRequest(testTarget, testSender)); err != nil {
+		t.Fatal(err)
+	}
+}
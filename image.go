@@ -0,0 +1,220 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// Memory areas of IEC addresses.
+const (
+	AreaInput  = 'I'
+	AreaOutput = 'Q'
+	AreaFlag   = 'M'
+)
+
+// Sizes of IEC addresses.
+const (
+	SizeBit   = 'X'
+	SizeByte  = 'B'
+	SizeWord  = 'W'
+	SizeDWord = 'D'
+	SizeLWord = 'L'
+)
+
+// IECAddr is a direct IEC 61131-3 address of the process image or
+// the flag area like %IX2.3, %QW10 or %MD100.
+type IECAddr struct {
+	Area byte   // AreaInput, AreaOutput or AreaFlag
+	Size byte   // SizeBit, SizeByte, SizeWord, SizeDWord or SizeLWord
+	Byte uint32 // byte offset in the area
+	Bit  uint8  // bit in the byte for SizeBit
+}
+
+// ParseIECAddr parses a direct IEC address. A bit address can omit
+// the size like %I2.3.
+func ParseIECAddr(s string) (IECAddr, error) {
+	errInvalid := fmt.Errorf("invalid IEC address %q", s)
+	if len(s) < 3 || s[0] != '%' {
+		return IECAddr{}, errInvalid
+	}
+	a := IECAddr{Area: strings.ToUpper(s[1:2])[0]}
+	switch a.Area {
+	case AreaInput, AreaOutput, AreaFlag:
+	default:
+		return IECAddr{}, errInvalid
+	}
+
+	rest := s[2:]
+	switch size := strings.ToUpper(rest[:1])[0]; size {
+	case SizeBit, SizeByte, SizeWord, SizeDWord, SizeLWord:
+		a.Size = size
+		rest = rest[1:]
+	default:
+		if !strings.Contains(rest, ".") {
+			return IECAddr{}, errInvalid
+		}
+		a.Size = SizeBit
+	}
+
+	byteStr, bitStr := rest, ""
+	if i := strings.IndexByte(rest, '.'); i >= 0 {
+		byteStr, bitStr = rest[:i], rest[i+1:]
+	}
+	if (a.Size == SizeBit) != (bitStr != "") {
+		return IECAddr{}, errInvalid
+	}
+	n, err := strconv.ParseUint(byteStr, 10, 32)
+	if err != nil {
+		return IECAddr{}, errInvalid
+	}
+	a.Byte = uint32(n)
+	if bitStr != "" {
+		// the offset of a bit address counts bits
+		if a.Byte > (math.MaxUint32-7)/8 {
+			return IECAddr{}, errInvalid
+		}
+		bit, err := strconv.ParseUint(bitStr, 10, 8)
+		if err != nil || bit > 7 {
+			return IECAddr{}, errInvalid
+		}
+		a.Bit = uint8(bit)
+	}
+	return a, nil
+}
+
+// MustParseIECAddr is like ParseIECAddr but panics on error.
+func MustParseIECAddr(s string) IECAddr {
+	a, err := ParseIECAddr(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func (a IECAddr) String() string {
+	if a.Size == SizeBit {
+		return fmt.Sprintf("%%%cX%d.%d", a.Area, a.Byte, a.Bit)
+	}
+	return fmt.Sprintf("%%%c%c%d", a.Area, a.Size, a.Byte)
+}
+
+// Len returns the number of bytes of the value. A bit is read and
+// written as a single byte.
+func (a IECAddr) Len() int {
+	switch a.Size {
+	case SizeWord:
+		return 2
+	case SizeDWord:
+		return 4
+	case SizeLWord:
+		return 8
+	default:
+		return 1
+	}
+}
+
+// Index returns the index group and offset of the address. Bits use
+// the bit-addressed index groups with the offset in bits.
+func (a IECAddr) Index() (group, offset uint32) {
+	if a.Size == SizeBit {
+		offset = a.Byte*8 + uint32(a.Bit)
+		switch a.Area {
+		case AreaInput:
+			return ams.IdxReadIXWriteIX, offset
+		case AreaOutput:
+			return ams.IdxReadQXWriteQX, offset
+		default:
+			return ams.IdxReadMXWriteMX, offset
+		}
+	}
+	switch a.Area {
+	case AreaInput:
+		return ams.IdxReadIWriteI, a.Byte
+	case AreaOutput:
+		return ams.IdxReadQWriteQ, a.Byte
+	default:
+		return ams.IdxReadMWriteM, a.Byte
+	}
+}
+
+// ReadIEC reads the value of an IEC address. Bits are 0 or 1.
+func (c *Client) ReadIEC(ctx context.Context, target, sender ams.Addr, addr IECAddr) (uint64, error) {
+	group, offset := addr.Index()
+	r, err := c.Read(ctx, ams.NewReadRequest(target, sender, group, offset, uint32(addr.Len())))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", addr, err)
+	}
+	if r.Result != ams.NoError {
+		return 0, fmt.Errorf("failed to read %s: %w", addr, &ADSError{Code: r.Result})
+	}
+	if len(r.Data) < addr.Len() {
+		return 0, fmt.Errorf("failed to read %s: got %d bytes want %d", addr, len(r.Data), addr.Len())
+	}
+	b := make([]byte, 8)
+	copy(b, r.Data[:addr.Len()])
+	v := binary.LittleEndian.Uint64(b)
+	if addr.Size == SizeBit && v != 0 {
+		v = 1
+	}
+	return v, nil
+}
+
+// WriteIEC writes a value to an IEC address. The value must fit into
+// the size of the address.
+func (c *Client) WriteIEC(ctx context.Context, target, sender ams.Addr, addr IECAddr, v uint64) error {
+	n := addr.Len()
+	max := uint64(1)<<(8*uint(n)) - 1
+	if addr.Size == SizeBit {
+		max = 1
+	}
+	if n < 8 && v > max {
+		return fmt.Errorf("value %d out of range for %s", v, addr)
+	}
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+
+	group, offset := addr.Index()
+	r, err := c.Write(ctx, ams.NewWriteRequest(target, sender, group, offset, b[:n]))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", addr, err)
+	}
+	if r.Result != ams.NoError {
+		return fmt.Errorf("failed to write %s: %w", addr, &ADSError{Code: r.Result})
+	}
+	return nil
+}
+
+// ImageSize returns the size of the input and the output process
+// image in bytes.
+func (c *Client) ImageSize(ctx context.Context, target, sender ams.Addr) (inputs, outputs uint32, err error) {
+	size := func(group uint32) (uint32, error) {
+		r, err := c.Read(ctx, ams.NewReadRequest(target, sender, group, 0, 4))
+		if err != nil {
+			return 0, err
+		}
+		if r.Result != ams.NoError {
+			return 0, &ADSError{Code: r.Result}
+		}
+		if len(r.Data) < 4 {
+			return 0, fmt.Errorf("got %d bytes want 4", len(r.Data))
+		}
+		return binary.LittleEndian.Uint32(r.Data), nil
+	}
+	if inputs, err = size(ams.IdxADSIGRP_IOIMAGE_RISIZE); err != nil {
+		return 0, 0, fmt.Errorf("failed to read input image size: %w", err)
+	}
+	if outputs, err = size(ams.IdxADSIGRP_IOIMAGE_ROSIZE); err != nil {
+		return 0, 0, fmt.Errorf("failed to read output image size: %w", err)
+	}
+	return inputs, outputs, nil
+}
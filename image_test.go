@@ -0,0 +1,99 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestParseIECAddr(t *testing.T) {
+	tests := []struct {
+		s      string
+		addr   IECAddr
+		group  uint32
+		offset uint32
+	}{
+		{"%IX2.3", IECAddr{AreaInput, SizeBit, 2, 3}, ams.IdxReadIXWriteIX, 19},
+		{"%I2.3", IECAddr{AreaInput, SizeBit, 2, 3}, ams.IdxReadIXWriteIX, 19},
+		{"%QX0.7", IECAddr{AreaOutput, SizeBit, 0, 7}, ams.IdxReadQXWriteQX, 7},
+		{"%MX1.0", IECAddr{AreaFlag, SizeBit, 1, 0}, ams.IdxReadMXWriteMX, 8},
+		{"%IB4", IECAddr{AreaInput, SizeByte, 4, 0}, ams.IdxReadIWriteI, 4},
+		{"%QW10", IECAddr{AreaOutput, SizeWord, 10, 0}, ams.IdxReadQWriteQ, 10},
+		{"%md100", IECAddr{AreaFlag, SizeDWord, 100, 0}, ams.IdxReadMWriteM, 100},
+		{"%QL8", IECAddr{AreaOutput, SizeLWord, 8, 0}, ams.IdxReadQWriteQ, 8},
+		{"%MX536870911.7", IECAddr{AreaFlag, SizeBit, 536870911, 7}, ams.IdxReadMXWriteMX, 0xFFFFFFFF},
+	}
+	for _, tt := range tests {
+		a, err := ParseIECAddr(tt.s)
+		if err != nil {
+			t.Errorf("%s: %v", tt.s, err)
+			continue
+		}
+		verify.Values(t, tt.s, a, tt.addr)
+		group, offset := a.Index()
+		verify.Values(t, tt.s+" index", []uint32{group, offset}, []uint32{tt.group, tt.offset})
+	}
+
+	for _, s := range []string{"", "%", "IX2.3", "%ZX2.3", "%IX2", "%IX2.8", "%IW2.1", "%I2", "%IWx", "%MX536870912.0"} {
+		if _, err := ParseIECAddr(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+func TestIECAddrString(t *testing.T) {
+	for _, s := range []string{"%IX2.3", "%QW10", "%MD100", "%IB0", "%QL8"} {
+		verify.Values(t, s, MustParseIECAddr(s).String(), s)
+	}
+}
+
+func TestReadWriteIEC(t *testing.T) {
+	s := newTestServer(t)
+	s.mem[ams.IdxReadIWriteI] = []byte{0x34, 0x12}
+	s.mem[ams.IdxReadIXWriteIX] = []byte{0, 0, 0, 1}
+	c := s.dial(t)
+	ctx := context.Background()
+
+	v, err := c.ReadIEC(ctx, testTarget, testSender, MustParseIECAddr("%IW0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "word", v, uint64(0x1234))
+
+	v, err = c.ReadIEC(ctx, testTarget, testSender, MustParseIECAddr("%IX0.3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "bit", v, uint64(1))
+
+	if err := c.WriteIEC(ctx, testTarget, testSender, MustParseIECAddr("%MD4"), 0x01020304); err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "flags", s.mem[ams.IdxReadMWriteM], []byte{0, 0, 0, 0, 4, 3, 2, 1})
+
+	if err := c.WriteIEC(ctx, testTarget, testSender, MustParseIECAddr("%QB0"), 256); err == nil {
+		t.Fatal("value out of range written")
+	}
+	if err := c.WriteIEC(ctx, testTarget, testSender, MustParseIECAddr("%QX0.0"), 2); err == nil {
+		t.Fatal("bit value out of range written")
+	}
+}
+
+func TestImageSize(t *testing.T) {
+	s := newTestServer(t)
+	s.mem[ams.IdxADSIGRP_IOIMAGE_RISIZE] = []byte{0x10, 0, 0, 0}
+	s.mem[ams.IdxADSIGRP_IOIMAGE_ROSIZE] = []byte{0x20, 0, 0, 0}
+	c := s.dial(t)
+
+	in, out, err := c.ImageSize(context.Background(), testTarget, testSender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "sizes", []uint32{in, out}, []uint32{0x10, 0x20})
+}
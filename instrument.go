@@ -0,0 +1,135 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// RequestInfo describes a request for the instrumentation.
+type RequestInfo struct {
+	Cmd         uint16
+	Target      ams.Addr
+	InvokeID    uint32
+	IndexGroup  uint32 // zero for commands without index group
+	IndexOffset uint32 // zero for commands without index offset
+	Bytes       int    // length of the request frame
+}
+
+// RequestResult describes the outcome of a request.
+type RequestResult struct {
+	Bytes     int    // length of the response frame
+	ErrorCode uint32 // error code of the AMS header
+	Result    uint32 // ADS result of the response
+	Latency   time.Duration
+	Err       error
+}
+
+// Instrumentation receives callbacks from the client for
+// collecting metrics and traces. Implementations must be
+// safe for concurrent use. Embed NopInstrumentation to
+// implement only some of the callbacks.
+type Instrumentation interface {
+	// RequestStart is called before a request is sent. The returned
+	// context is passed to RequestEnd.
+	RequestStart(ctx context.Context, info RequestInfo) context.Context
+
+	// RequestEnd is called when a request has completed or failed.
+	RequestEnd(ctx context.Context, info RequestInfo, res RequestResult)
+
+	// Reconnect is called when the client dials again.
+	Reconnect(addr string, err error)
+
+	// Timeout is called when a request timed out.
+	Timeout(info RequestInfo)
+
+	// UnknownPacket is called for packets the client cannot handle.
+	UnknownPacket(hdr ams.AMSHeader)
+
+	// LateResponse is called for a response which arrived after
+	// its request was canceled or timed out. The response is
+	// dropped.
+	LateResponse(hdr ams.AMSHeader)
+
+	// InFlight is called with the number of requests of the client
+	// for addr waiting for a response whenever it changes.
+	InFlight(addr string, n int)
+
+	// QueueDepth is called with the number of requests of the
+	// client for addr which wait for the in-flight or the rate
+	// limit whenever it changes.
+	QueueDepth(addr string, n int)
+}
+
+// NopInstrumentation implements Instrumentation and ignores all callbacks.
+type NopInstrumentation struct{}
+
+func (NopInstrumentation) RequestStart(ctx context.Context, _ RequestInfo) context.Context {
+	return ctx
+}
+func (NopInstrumentation) RequestEnd(context.Context, RequestInfo, RequestResult) {}
+func (NopInstrumentation) Reconnect(string, error)                                {}
+func (NopInstrumentation) Timeout(RequestInfo)                                    {}
+func (NopInstrumentation) UnknownPacket(ams.AMSHeader)                            {}
+func (NopInstrumentation) LateResponse(ams.AMSHeader)                             {}
+func (NopInstrumentation) InFlight(string, int)                                   {}
+func (NopInstrumentation) QueueDepth(string, int)                                 {}
+
+// instr returns the instrumentation of the client.
+func (c *Client) instr() Instrumentation {
+	if c.Instrumentation == nil {
+		return NopInstrumentation{}
+	}
+	return c.Instrumentation
+}
+
+// requestInfo returns the instrumentation info for a request.
+func requestInfo(req ams.Packet, n int) RequestInfo {
+	hdr := req.Header()
+	info := RequestInfo{
+		Cmd:      hdr.CmdID,
+		Target:   hdr.Target,
+		InvokeID: hdr.InvokeID,
+		Bytes:    n,
+	}
+	switch r := req.(type) {
+	case *ams.ReadRequest:
+		info.IndexGroup, info.IndexOffset = r.IndexGroup, r.IndexOffset
+	case *ams.WriteRequest:
+		info.IndexGroup, info.IndexOffset = r.IndexGroup, r.IndexOffset
+	case *ams.ReadWriteRequest:
+		info.IndexGroup, info.IndexOffset = r.IndexGroup, r.IndexOffset
+	case *ams.AddDeviceNotificationRequest:
+		info.IndexGroup, info.IndexOffset = r.IndexGroup, r.IndexOffset
+	}
+	return info
+}
+
+// adsResult returns the ADS result of a response.
+func adsResult(resp ams.Response) uint32 {
+	switch r := resp.(type) {
+	case *ams.ReadDeviceInfoResponse:
+		return r.Result
+	case *ams.ReadResponse:
+		return r.Result
+	case *ams.WriteResponse:
+		return r.Result
+	case *ams.ReadStateResponse:
+		return r.Result
+	case *ams.WriteControlResponse:
+		return r.Result
+	case *ams.AddDeviceNotificationResponse:
+		return r.Result
+	case *ams.DeleteDeviceNotificationResponse:
+		return r.Result
+	case *ams.ReadWriteResponse:
+		return r.Result
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// memInstrumentation records all callbacks.
+type memInstrumentation struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (m *memInstrumentation) add(format string, args ...interface{}) {
+	m.mu.Lock()
+	m.events = append(m.events, fmt.Sprintf(format, args...))
+	m.mu.Unlock()
+}
+
+func (m *memInstrumentation) list() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.events...)
+}
+
+func (m *memInstrumentation) RequestStart(ctx context.Context, info RequestInfo) context.Context {
+	m.add("start %s %s 0x%x/0x%x %d", ams.CmdName(info.Cmd), info.Target, info.IndexGroup, info.IndexOffset, info.Bytes)
+	return ctx
+}
+
+func (m *memInstrumentation) RequestEnd(_ context.Context, info RequestInfo, res RequestResult) {
+	m.add("end %s %d %d %v", ams.CmdName(info.Cmd), res.Bytes, res.Result, res.Err)
+}
+
+func (m *memInstrumentation) Reconnect(addr string, err error) { m.add("reconnect %v", err) }
+func (m *memInstrumentation) Timeout(info RequestInfo)         { m.add("timeout %s", ams.CmdName(info.Cmd)) }
+func (m *memInstrumentation) UnknownPacket(hdr ams.AMSHeader) {
+	m.add("unknown %s", ams.CmdName(hdr.CmdID))
+}
+func (m *memInstrumentation) LateResponse(hdr ams.AMSHeader) {
+	m.add("late %s", ams.CmdName(hdr.CmdID))
+}
+func (m *memInstrumentation) InFlight(addr string, n int)   { m.add("in flight %d", n) }
+func (m *memInstrumentation) QueueDepth(addr string, n int) { m.add("queue %d", n) }
+
+func TestClientInstrumentation(t *testing.T) {
+	s := newTestServer(t)
+	m := &memInstrumentation{}
+	c := s.dial(t, func(c *Client) {
+		c.Instrumentation = m
+		c.ReadTimeout = 50 * time.Millisecond
+	})
+	ctx := context.Background()
+
+	if _, err := c.Read(ctx, ams.NewReadRequest(testTarget, testSender, 0x4020, 0x10, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	s.setHandler(func(req ams.Packet) ams.Packet { return nil })
+	if _, err := c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, 0x4020, 0x10, []byte{1})); err != ErrTimeout {
+		t.Fatalf("got %v want %v", err, ErrTimeout)
+	}
+
+	verify.Values(t, "", m.list(), []string{
+		"start Read 1.2.3.4.5.6:851 0x4020/0x10 50",
+		"in flight 1",
+		"in flight 0",
+		"end Read 48 0 <nil>",
+		"start Write 1.2.3.4.5.6:851 0x4020/0x10 51",
+		"in flight 1",
+		"in flight 0",
+		"timeout Write",
+		"end Write 0 0 timeout",
+	})
+}
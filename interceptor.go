@@ -0,0 +1,64 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// Invoker sends a request and returns its response.
+type Invoker func(ctx context.Context, req ams.Packet) (ams.Response, error)
+
+// Interceptor intercepts the requests of a client. req is the typed
+// request, e.g. *ams.WriteRequest, and req.Header().Target is its
+// target. The interceptor continues the request by calling invoke and
+// can modify the request before and the response after the call. It
+// can also return a response or an error without calling invoke or
+// call invoke more than once, e.g. to retry a request.
+//
+// A replaced response must have the type of the original response.
+// Interceptors run before the rate and in-flight limits and the
+// instrumentation, so every call of invoke is a separate request.
+type Interceptor func(ctx context.Context, req ams.Packet, invoke Invoker) (ams.Response, error)
+
+// ChainInterceptors returns an interceptor which calls the given
+// interceptors in order. The first interceptor is the outermost one.
+func ChainInterceptors(interceptors ...Interceptor) Interceptor {
+	return func(ctx context.Context, req ams.Packet, invoke Invoker) (ams.Response, error) {
+		return chain(interceptors, invoke)(ctx, req)
+	}
+}
+
+// chain returns an invoker which calls the interceptors in order
+// and then invoke.
+func chain(interceptors []Interceptor, invoke Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		f, next := interceptors[i], invoke
+		invoke = func(ctx context.Context, req ams.Packet) (ams.Response, error) {
+			return f(ctx, req, next)
+		}
+	}
+	return invoke
+}
+
+// invoker returns the invoker for a request which passes through the
+// retry policy, the interceptors, the auditor and the write policy of
+// the client. The interceptors see every attempt of a retried request.
+func (c *Client) invoker() Invoker {
+	invoke := c.roundTrip
+	if c.WritePolicy != nil {
+		invoke = chain([]Interceptor{c.guard(c.WritePolicy)}, invoke)
+	}
+	if c.Auditor != nil {
+		invoke = chain([]Interceptor{c.audit(c.Auditor)}, invoke)
+	}
+	invoke = chain(c.Interceptors, invoke)
+	if c.Retry != nil {
+		invoke = c.retry(c.Retry, invoke)
+	}
+	return invoke
+}
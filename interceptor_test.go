@@ -0,0 +1,124 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestInterceptorOrder(t *testing.T) {
+	var calls []string
+	record := func(name string) Interceptor {
+		return func(ctx context.Context, req ams.Packet, invoke Invoker) (ams.Response, error) {
+			calls = append(calls, name+" "+ams.CmdName(req.Header().CmdID))
+			r, err := invoke(ctx, req)
+			calls = append(calls, name+" done")
+			return r, err
+		}
+	}
+
+	s := newTestServer(t)
+	c := s.dial(t, func(c *Client) {
+		c.Interceptors = []Interceptor{record("a"), ChainInterceptors(record("b"), record("c"))}
+	})
+	if _, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "calls", calls, []string{"a Read", "b Read", "c Read", "c done", "b done", "a done"})
+}
+
+func TestInterceptorBlockWrite(t *testing.T) {
+	errProtected := errors.New("protected")
+	var sent int32
+	s := newTestServer(t)
+	s.setHandler(func(req ams.Packet) ams.Packet {
+		atomic.AddInt32(&sent, 1)
+		return s.respond(req)
+	})
+	c := s.dial(t, func(c *Client) {
+		c.Interceptors = []Interceptor{func(ctx context.Context, req ams.Packet, invoke Invoker) (ams.Response, error) {
+			if w, ok := req.(*ams.WriteRequest); ok && w.IndexGroup == 0x4020 {
+				return nil, errProtected
+			}
+			return invoke(ctx, req)
+		}}
+	})
+
+	ctx := context.Background()
+	if _, err := c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, 0x4020, 0, []byte{1})); err != errProtected {
+		t.Fatalf("got %v want %v", err, errProtected)
+	}
+	verify.Values(t, "sent", atomic.LoadInt32(&sent), int32(0))
+
+	if _, err := c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, 0x4040, 0, []byte{1})); err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "sent", atomic.LoadInt32(&sent), int32(1))
+}
+
+func TestInterceptorShortCircuit(t *testing.T) {
+	s := newTestServer(t)
+	s.setHandler(func(ams.Packet) ams.Packet {
+		t.Error("request sent")
+		return nil
+	})
+	c := s.dial(t, func(c *Client) {
+		c.Interceptors = []Interceptor{func(ctx context.Context, req ams.Packet, invoke Invoker) (ams.Response, error) {
+			hdr := req.Header()
+			switch req.(type) {
+			case *ams.ReadRequest:
+				// inject a fault
+				return ams.NewReadResponse(hdr.Sender, hdr.Target, ams.DeviceBusy, nil), nil
+			default:
+				// wrong response type
+				return ams.NewReadStateResponse(hdr.Sender, hdr.Target, ams.NoError, 0, 0), nil
+			}
+		}}
+	})
+
+	ctx := context.Background()
+	r, err := c.Read(ctx, ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "result", r.Result, uint32(ams.DeviceBusy))
+
+	_, err = c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, 0x4020, 0, []byte{1}))
+	if err == nil || !strings.Contains(err.Error(), "want *ams.WriteResponse") {
+		t.Fatalf("got %v want response type error", err)
+	}
+}
+
+func TestInterceptorModify(t *testing.T) {
+	s := newTestServer(t)
+	s.mem[0x4020] = []byte{1, 2, 3, 4}
+	c := s.dial(t, func(c *Client) {
+		c.Interceptors = []Interceptor{func(ctx context.Context, req ams.Packet, invoke Invoker) (ams.Response, error) {
+			// read from offset 2 instead and double the values
+			req.(*ams.ReadRequest).IndexOffset = 2
+			r, err := invoke(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			for i := range r.(*ams.ReadResponse).Data {
+				r.(*ams.ReadResponse).Data[i] *= 2
+			}
+			return r, nil
+		}}
+	})
+
+	r, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "data", r.Data, []byte{6, 8})
+}
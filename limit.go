@@ -0,0 +1,211 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// queue is a semaphore which admits the waiting requests in the
+// order of their arrival. A request which is canceled while it is
+// waiting leaves the queue.
+type queue struct {
+	max int
+
+	mu      sync.Mutex
+	running int
+	waiting list.List // of chan struct{}
+}
+
+// acquire waits for a free slot or until ctx is done. wait is
+// called before the request starts waiting.
+func (q *queue) acquire(ctx context.Context, wait func()) error {
+	q.mu.Lock()
+	if q.running < q.max && q.waiting.Len() == 0 {
+		q.running++
+		q.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	e := q.waiting.PushBack(ready)
+	q.mu.Unlock()
+	wait()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		select {
+		case <-ready:
+			// the slot was granted concurrently. Pass it on.
+			q.mu.Unlock()
+			q.release()
+			return ctx.Err()
+		default:
+		}
+		q.waiting.Remove(e)
+		q.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// release frees a slot and hands it to the first waiting request.
+func (q *queue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e := q.waiting.Front()
+	if e == nil {
+		q.running--
+		return
+	}
+	// the slot stays taken and moves to the waiting request
+	q.waiting.Remove(e)
+	close(e.Value.(chan struct{}))
+}
+
+// RateLimit limits the request rate per target with a token bucket.
+type RateLimit struct {
+	// Rate is the number of requests per second.
+	Rate float64
+
+	// Burst is the number of requests which can be sent at once.
+	// If zero, 1 is used.
+	Burst int
+}
+
+// bucket is the token bucket of a single target.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter holds the buckets of all targets.
+type rateLimiter struct {
+	limit RateLimit
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// reserve takes a token for the target and returns the time to
+// wait until the token is available.
+func (r *rateLimiter) reserve(target ams.Addr, now time.Time) time.Duration {
+	burst := float64(r.limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.buckets == nil {
+		r.buckets = map[string]*bucket{}
+	}
+	k := target.String()
+	b := r.buckets[k]
+	if b == nil {
+		b = &bucket{tokens: burst, last: now}
+		r.buckets[k] = b
+	}
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * r.limit.Rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+		b.last = now
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / r.limit.Rate * float64(time.Second))
+}
+
+// cancel returns a token which was reserved but not used.
+func (r *rateLimiter) cancel(target ams.Addr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b := r.buckets[target.String()]; b != nil {
+		b.tokens++
+	}
+}
+
+// wait blocks until the request to target may be sent or until ctx
+// is done. wait is called before the request starts waiting.
+func (r *rateLimiter) wait(ctx context.Context, target ams.Addr, wait func()) error {
+	d := r.reserve(target, time.Now())
+	if d == 0 {
+		return nil
+	}
+	wait()
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		r.cancel(target)
+		return ctx.Err()
+	}
+}
+
+// admit waits until a request to target may be sent according to the
+// rate limit and the in-flight limit. The returned function must be
+// called when the request has completed.
+func (c *Client) admit(ctx context.Context, target ams.Addr) (func(), error) {
+	c.limitOnce.Do(func() {
+		if c.MaxInFlight > 0 {
+			c.queue = &queue{max: c.MaxInFlight}
+		}
+		if c.RateLimit != nil && c.RateLimit.Rate > 0 {
+			c.rate = &rateLimiter{limit: *c.RateLimit}
+		}
+	})
+
+	// waiting tracks the queue depth while the request waits
+	waiting := false
+	wait := func() {
+		waiting = true
+		c.trackQueued(1)
+	}
+	stopWaiting := func() {
+		if waiting {
+			waiting = false
+			c.trackQueued(-1)
+		}
+	}
+
+	if c.rate != nil {
+		err := c.rate.wait(ctx, target, wait)
+		stopWaiting()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.queue == nil {
+		return func() {}, nil
+	}
+	err := c.queue.acquire(ctx, wait)
+	stopWaiting()
+	if err != nil {
+		return nil, err
+	}
+	return c.queue.release, nil
+}
+
+// trackQueued changes the number of waiting requests by d and
+// reports it to the instrumentation.
+func (c *Client) trackQueued(d int) {
+	c.queueMu.Lock()
+	c.queueDepth += d
+	n := c.queueDepth
+	c.queueMu.Unlock()
+	c.instr().QueueDepth(c.Addr, n)
+}
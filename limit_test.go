@@ -0,0 +1,149 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestQueueOrder(t *testing.T) {
+	q := &queue{max: 1}
+	ctx := context.Background()
+	if err := q.acquire(ctx, func() { t.Fatal("first request waits") }); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 1; i <= 3; i++ {
+		waiting := make(chan struct{})
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := q.acquire(ctx, func() { close(waiting) }); err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			q.release()
+		}(i)
+		<-waiting
+	}
+	q.release()
+	wg.Wait()
+	verify.Values(t, "order", order, []int{1, 2, 3})
+	verify.Values(t, "running", q.running, 0)
+}
+
+func TestQueueCancel(t *testing.T) {
+	q := &queue{max: 1}
+	if err := q.acquire(context.Background(), func() {}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.acquire(ctx, func() {}); err != context.DeadlineExceeded {
+		t.Fatalf("got %v want %v", err, context.DeadlineExceeded)
+	}
+	verify.Values(t, "waiting", q.waiting.Len(), 0)
+
+	// the slot is free again after the release
+	q.release()
+	if err := q.acquire(context.Background(), func() { t.Fatal("request waits") }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	r := &rateLimiter{limit: RateLimit{Rate: 10, Burst: 2}}
+	a, b := testTarget, testSender
+	now := time.Unix(0, 0)
+
+	verify.Values(t, "burst 1", r.reserve(a, now), time.Duration(0))
+	verify.Values(t, "burst 2", r.reserve(a, now), time.Duration(0))
+	verify.Values(t, "wait", r.reserve(a, now), 100*time.Millisecond)
+	verify.Values(t, "other target", r.reserve(b, now), time.Duration(0))
+
+	// a canceled request returns its token
+	r.cancel(a)
+	verify.Values(t, "after cancel", r.reserve(a, now), 100*time.Millisecond)
+
+	// the bucket refills with the rate
+	verify.Values(t, "refill", r.reserve(a, now.Add(300*time.Millisecond)), time.Duration(0))
+}
+
+func TestClientMaxInFlight(t *testing.T) {
+	s := newTestServer(t)
+	s.setHandler(func(req ams.Packet) ams.Packet {
+		time.Sleep(5 * time.Millisecond)
+		return s.respond(req)
+	})
+	m := &memInstrumentation{}
+	c := s.dial(t, func(c *Client) {
+		c.Instrumentation = m
+		c.MaxInFlight = 2
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var queued bool
+	for _, e := range m.list() {
+		switch {
+		case e == "in flight 3":
+			t.Fatalf("more than 2 requests in flight")
+		case strings.HasPrefix(e, "queue ") && e != "queue 0":
+			queued = true
+		}
+	}
+	if !queued {
+		t.Fatal("no queued requests")
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t, func(c *Client) {
+		c.RateLimit = &RateLimit{Rate: 50, Burst: 1}
+	})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the second and third request wait 20ms each
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Fatalf("got %v want at least 40ms", d)
+	}
+
+	// the wait honors the context
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := c.Read(ctx, ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1)); err != context.DeadlineExceeded {
+		t.Fatalf("got %v want %v", err, context.DeadlineExceeded)
+	}
+}
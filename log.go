@@ -0,0 +1,121 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Level is the severity of a log message. The values
+// match the levels of the log/slog package.
+type Level int
+
+const (
+	// LevelTrace logs hex dumps of all frames.
+	LevelTrace Level = -8
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelTrace:
+		return "TRACE"
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+}
+
+// Logger is the interface for structured logging. args are
+// alternating keys and values like for the log/slog package.
+//
+// The client logs with the following keys:
+//
+//	cmd         command name
+//	invoke_id   invoke id of the packet
+//	target      target address
+//	error_code  error code of the AMS header
+//	latency     time between request and response
+//	err         error
+//	frame       hex dump of a frame (LevelTrace only)
+type Logger interface {
+	Log(ctx context.Context, level Level, msg string, args ...interface{})
+}
+
+// LoggerFunc is an adapter to use a function as a Logger.
+type LoggerFunc func(ctx context.Context, level Level, msg string, args ...interface{})
+
+// Log calls f.
+func (f LoggerFunc) Log(ctx context.Context, level Level, msg string, args ...interface{}) {
+	f(ctx, level, msg, args...)
+}
+
+// DiscardLogger drops all messages.
+var DiscardLogger Logger = LoggerFunc(func(context.Context, Level, string, ...interface{}) {})
+
+// NewStdLogger returns a logger which writes the messages
+// with their fields as key=value pairs to l. If l is nil
+// the standard logger of the log package is used.
+func NewStdLogger(l *log.Logger) Logger {
+	return &stdLogger{l: l}
+}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+func (l *stdLogger) Log(_ context.Context, level Level, msg string, args ...interface{}) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "client: %s %s", level, msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			fmt.Fprintf(&sb, " %v", args[i])
+			break
+		}
+		v := fmt.Sprint(args[i+1])
+		if strings.ContainsAny(v, " \n\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&sb, " %v=%s", args[i], v)
+	}
+	if l.l == nil {
+		log.Print(sb.String())
+		return
+	}
+	l.l.Print(sb.String())
+}
+
+// defaultLogger is used when the client has no logger.
+var defaultLogger = NewStdLogger(nil)
+
+// enabled returns true if messages of the given level are logged.
+func (c *Client) enabled(level Level) bool {
+	return level >= c.LogLevel
+}
+
+// log logs a message if the level is enabled.
+func (c *Client) log(ctx context.Context, level Level, msg string, args ...interface{}) {
+	if !c.enabled(level) {
+		return
+	}
+	l := c.Logger
+	if l == nil {
+		l = defaultLogger
+	}
+	l.Log(ctx, level, msg, args...)
+}
@@ -0,0 +1,21 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package twincat
+
+import (
+	"context"
+	"log/slog"
+)
+
+// NewSlogLogger returns a logger which writes to l.
+// LevelTrace is mapped to slog.Level(-8).
+func NewSlogLogger(l *slog.Logger) Logger {
+	return LoggerFunc(func(ctx context.Context, level Level, msg string, args ...interface{}) {
+		l.Log(ctx, slog.Level(level), msg, args...)
+	})
+}
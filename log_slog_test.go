@@ -0,0 +1,33 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package twincat
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.Level(LevelTrace),
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	l := NewSlogLogger(slog.New(h))
+	l.Log(context.Background(), LevelWarn, "no handler", "invoke_id", uint32(7))
+	verify.Values(t, "", buf.String(), "level=WARN msg=\"no handler\" invoke_id=7\n")
+}
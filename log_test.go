@@ -0,0 +1,100 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sync"
+	"testing"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+type logEntry struct {
+	level Level
+	msg   string
+	args  map[string]interface{}
+}
+
+// memLogger records all log messages.
+type memLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *memLogger) Log(_ context.Context, level Level, msg string, args ...interface{}) {
+	e := logEntry{level: level, msg: msg, args: map[string]interface{}{}}
+	for i := 0; i+1 < len(args); i += 2 {
+		e.args[args[i].(string)] = args[i+1]
+	}
+	l.mu.Lock()
+	l.entries = append(l.entries, e)
+	l.mu.Unlock()
+}
+
+func (l *memLogger) msgs() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var msgs []string
+	for _, e := range l.entries {
+		msgs = append(msgs, e.level.String()+" "+e.msg)
+	}
+	return msgs
+}
+
+func TestClientLogger(t *testing.T) {
+	tests := []struct {
+		level Level
+		msgs  []string
+	}{
+		{LevelInfo, nil},
+		{LevelDebug, []string{"DEBUG request"}},
+		{LevelTrace, []string{"TRACE sent frame", "TRACE received frame", "DEBUG request"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level.String(), func(t *testing.T) {
+			s := newTestServer(t)
+			l := &memLogger{}
+			c := s.dial(t, func(c *Client) { c.Logger, c.LogLevel = l, tt.level })
+
+			if _, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1)); err != nil {
+				t.Fatal(err)
+			}
+			verify.Values(t, "msgs", l.msgs(), tt.msgs)
+		})
+	}
+}
+
+func TestClientLoggerFields(t *testing.T) {
+	s := newTestServer(t)
+	l := &memLogger{}
+	c := s.dial(t, func(c *Client) { c.Logger, c.LogLevel = l, LevelDebug })
+
+	if _, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	e := l.entries[0]
+	verify.Values(t, "cmd", e.args["cmd"], "Read")
+	verify.Values(t, "target", e.args["target"], testTarget)
+	verify.Values(t, "error_code", e.args["error_code"], uint32(0))
+	if _, ok := e.args["invoke_id"].(uint32); !ok {
+		t.Fatalf("got invoke_id %v want uint32", e.args["invoke_id"])
+	}
+	if _, ok := e.args["latency"]; !ok {
+		t.Fatal("missing latency")
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewStdLogger(log.New(&buf, "", 0))
+	l.Log(context.Background(), LevelWarn, "no handler", "cmd", "Read", "err", "a b")
+	verify.Values(t, "", buf.String(), "client: WARN no handler cmd=Read err=\"a b\"\n")
+}
@@ -0,0 +1,290 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package metrics collects Prometheus style metrics for twincat clients.
+//
+// The metrics are kept in memory and exported in the Prometheus text
+// exposition format. A Metrics value can be shared by many clients.
+//
+//	m := metrics.New()
+//	c := &twincat.Client{Addr: addr, Instrumentation: m}
+//	http.Handle("/metrics", m)
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gotwincat/twincat"
+	"github.com/gotwincat/twincat/ams"
+)
+
+// DefaultBuckets are the upper bounds of the latency histogram in seconds.
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// Metrics implements twincat.Instrumentation and collects the
+// following metrics:
+//
+//	twincat_requests_total              counter   {cmd, target, status}
+//	twincat_request_duration_seconds    histogram {cmd, target}
+//	twincat_request_bytes_total         counter   {cmd, target, direction}
+//	twincat_requests_in_flight          gauge     {target}
+//	twincat_connection_in_flight        gauge     {addr}
+//	twincat_request_queue_depth         gauge     {addr}
+//	twincat_timeouts_total              counter   {cmd, target}
+//	twincat_reconnects_total            counter   {addr, status}
+//	twincat_unknown_packets_total       counter   {cmd}
+//	twincat_late_responses_total        counter   {cmd}
+//
+// status is "ok", "ads_error" when the AMS error code or the ADS
+// result is not zero or "error" for all other errors.
+type Metrics struct {
+	buckets []float64
+
+	mu        sync.Mutex
+	requests  map[string]float64
+	bytes     map[string]float64
+	inFlight  map[string]float64
+	connected map[string]float64 // in flight by addr
+	queue     map[string]float64
+	timeouts  map[string]float64
+	reconnect map[string]float64
+	unknown   map[string]float64
+	late      map[string]float64
+	durations map[string]*histogram
+}
+
+// New returns a new metrics collector with the default buckets.
+func New() *Metrics {
+	return NewWithBuckets(DefaultBuckets)
+}
+
+// NewWithBuckets returns a new metrics collector with the given
+// upper bounds for the latency histogram.
+func NewWithBuckets(buckets []float64) *Metrics {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &Metrics{
+		buckets:   b,
+		requests:  map[string]float64{},
+		bytes:     map[string]float64{},
+		inFlight:  map[string]float64{},
+		connected: map[string]float64{},
+		queue:     map[string]float64{},
+		timeouts:  map[string]float64{},
+		reconnect: map[string]float64{},
+		unknown:   map[string]float64{},
+		late:      map[string]float64{},
+		durations: map[string]*histogram{},
+	}
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// labels formats label pairs in the exposition format.
+func labels(kv ...string) string {
+	var sb strings.Builder
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, "%s=%s", kv[i], strconv.Quote(kv[i+1]))
+	}
+	return sb.String()
+}
+
+// RequestStart implements twincat.Instrumentation.
+func (m *Metrics) RequestStart(ctx context.Context, info twincat.RequestInfo) context.Context {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight[labels("target", info.Target.String())]++
+	return ctx
+}
+
+// RequestEnd implements twincat.Instrumentation.
+func (m *Metrics) RequestEnd(_ context.Context, info twincat.RequestInfo, res twincat.RequestResult) {
+	cmd, target := ams.CmdName(info.Cmd), info.Target.String()
+
+	status := "ok"
+	switch {
+	case res.ErrorCode != 0 || res.Result != 0:
+		status = "ads_error"
+	case res.Err != nil:
+		status = "error"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight[labels("target", target)]--
+	m.requests[labels("cmd", cmd, "target", target, "status", status)]++
+	m.bytes[labels("cmd", cmd, "target", target, "direction", "sent")] += float64(info.Bytes)
+	m.bytes[labels("cmd", cmd, "target", target, "direction", "received")] += float64(res.Bytes)
+
+	k := labels("cmd", cmd, "target", target)
+	h := m.durations[k]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.durations[k] = h
+	}
+	sec := res.Latency.Seconds()
+	for i, le := range m.buckets {
+		if sec <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += sec
+	h.count++
+}
+
+// Reconnect implements twincat.Instrumentation.
+func (m *Metrics) Reconnect(addr string, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reconnect[labels("addr", addr, "status", status)]++
+}
+
+// Timeout implements twincat.Instrumentation.
+func (m *Metrics) Timeout(info twincat.RequestInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timeouts[labels("cmd", ams.CmdName(info.Cmd), "target", info.Target.String())]++
+}
+
+// UnknownPacket implements twincat.Instrumentation.
+func (m *Metrics) UnknownPacket(hdr ams.AMSHeader) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.unknown[labels("cmd", ams.CmdName(hdr.CmdID))]++
+}
+
+// LateResponse implements twincat.Instrumentation.
+func (m *Metrics) LateResponse(hdr ams.AMSHeader) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.late[labels("cmd", ams.CmdName(hdr.CmdID))]++
+}
+
+// InFlight implements twincat.Instrumentation. The in-flight gauge
+// per target is maintained by RequestStart and RequestEnd.
+func (m *Metrics) InFlight(addr string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connected[labels("addr", addr)] = float64(n)
+}
+
+// QueueDepth implements twincat.Instrumentation.
+func (m *Metrics) QueueDepth(addr string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue[labels("addr", addr)] = float64(n)
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	writeCounter(cw, "twincat_requests_total", "counter", "Total number of ADS requests.", m.requests)
+	m.writeHistogram(cw)
+	writeCounter(cw, "twincat_request_bytes_total", "counter", "Total number of bytes of requests and responses.", m.bytes)
+	writeCounter(cw, "twincat_requests_in_flight", "gauge", "Number of requests waiting for a response.", m.inFlight)
+	writeCounter(cw, "twincat_connection_in_flight", "gauge", "Number of requests of a connection waiting for a response.", m.connected)
+	writeCounter(cw, "twincat_request_queue_depth", "gauge", "Number of requests waiting for the in-flight or rate limit.", m.queue)
+	writeCounter(cw, "twincat_timeouts_total", "counter", "Total number of timed out requests.", m.timeouts)
+	writeCounter(cw, "twincat_reconnects_total", "counter", "Total number of reconnects.", m.reconnect)
+	writeCounter(cw, "twincat_unknown_packets_total", "counter", "Total number of unknown packets.", m.unknown)
+	writeCounter(cw, "twincat_late_responses_total", "counter", "Total number of responses to canceled or timed out requests.", m.late)
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+func writeCounter(w *countWriter, name, typ, help string, values map[string]float64) {
+	if len(values) == 0 {
+		return
+	}
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	for _, k := range sortedKeys(values) {
+		w.printf("%s{%s} %s\n", name, k, formatFloat(values[k]))
+	}
+}
+
+func (m *Metrics) writeHistogram(w *countWriter) {
+	if len(m.durations) == 0 {
+		return
+	}
+	const name = "twincat_request_duration_seconds"
+	w.printf("# HELP %s Latency of ADS requests.\n# TYPE %s histogram\n", name, name)
+
+	keys := make([]string, 0, len(m.durations))
+	for k := range m.durations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		h := m.durations[k]
+		var cum uint64
+		for i, le := range m.buckets {
+			cum += h.counts[i]
+			w.printf("%s_bucket{%s,le=%q} %d\n", name, k, formatFloat(le), cum)
+		}
+		w.printf("%s_bucket{%s,le=\"+Inf\"} %d\n", name, k, h.count)
+		w.printf("%s_sum{%s} %s\n", name, k, formatFloat(h.sum))
+		w.printf("%s_count{%s} %d\n", name, k, h.count)
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// countWriter counts the written bytes and keeps the first error.
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *countWriter) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+	w.err = err
+}
@@ -0,0 +1,86 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat"
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewWithBuckets([]float64{0.01, 0.1})
+	target := ams.MustParseAddr("1.2.3.4.5.6:851")
+	info := twincat.RequestInfo{Cmd: ams.CmdADSRead, Target: target, Bytes: 50}
+
+	ctx := m.RequestStart(context.Background(), info)
+	m.RequestEnd(ctx, info, twincat.RequestResult{Bytes: 42, Latency: 5 * time.Millisecond})
+	ctx = m.RequestStart(context.Background(), info)
+	m.RequestEnd(ctx, info, twincat.RequestResult{Bytes: 46, Result: 0x710, Latency: 50 * time.Millisecond})
+	ctx = m.RequestStart(context.Background(), info)
+	m.Timeout(info)
+	m.RequestEnd(ctx, info, twincat.RequestResult{Latency: time.Second, Err: twincat.ErrTimeout})
+	m.RequestStart(context.Background(), info)
+	m.Reconnect("10.0.0.1:48898", nil)
+	m.Reconnect("10.0.0.1:48898", errors.New("refused"))
+	m.UnknownPacket(ams.AMSHeader{CmdID: ams.CmdADSDeviceNotification})
+	m.LateResponse(ams.AMSHeader{CmdID: ams.CmdADSRead})
+	m.QueueDepth("10.0.0.1:48898", 3)
+	m.QueueDepth("10.0.0.1:48898", 2)
+	m.InFlight("10.0.0.1:48898", 2)
+	m.InFlight("10.0.0.1:48898", 1)
+
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP twincat_requests_total Total number of ADS requests.
+# TYPE twincat_requests_total counter
+twincat_requests_total{cmd="Read",target="1.2.3.4.5.6:851",status="ads_error"} 1
+twincat_requests_total{cmd="Read",target="1.2.3.4.5.6:851",status="error"} 1
+twincat_requests_total{cmd="Read",target="1.2.3.4.5.6:851",status="ok"} 1
+# HELP twincat_request_duration_seconds Latency of ADS requests.
+# TYPE twincat_request_duration_seconds histogram
+twincat_request_duration_seconds_bucket{cmd="Read",target="1.2.3.4.5.6:851",le="0.01"} 1
+twincat_request_duration_seconds_bucket{cmd="Read",target="1.2.3.4.5.6:851",le="0.1"} 2
+twincat_request_duration_seconds_bucket{cmd="Read",target="1.2.3.4.5.6:851",le="+Inf"} 3
+twincat_request_duration_seconds_sum{cmd="Read",target="1.2.3.4.5.6:851"} 1.055
+twincat_request_duration_seconds_count{cmd="Read",target="1.2.3.4.5.6:851"} 3
+# HELP twincat_request_bytes_total Total number of bytes of requests and responses.
+# TYPE twincat_request_bytes_total counter
+twincat_request_bytes_total{cmd="Read",target="1.2.3.4.5.6:851",direction="received"} 88
+twincat_request_bytes_total{cmd="Read",target="1.2.3.4.5.6:851",direction="sent"} 150
+# HELP twincat_requests_in_flight Number of requests waiting for a response.
+# TYPE twincat_requests_in_flight gauge
+twincat_requests_in_flight{target="1.2.3.4.5.6:851"} 1
+# HELP twincat_connection_in_flight Number of requests of a connection waiting for a response.
+# TYPE twincat_connection_in_flight gauge
+twincat_connection_in_flight{addr="10.0.0.1:48898"} 1
+# HELP twincat_request_queue_depth Number of requests waiting for the in-flight or rate limit.
+# TYPE twincat_request_queue_depth gauge
+twincat_request_queue_depth{addr="10.0.0.1:48898"} 2
+# HELP twincat_timeouts_total Total number of timed out requests.
+# TYPE twincat_timeouts_total counter
+twincat_timeouts_total{cmd="Read",target="1.2.3.4.5.6:851"} 1
+# HELP twincat_reconnects_total Total number of reconnects.
+# TYPE twincat_reconnects_total counter
+twincat_reconnects_total{addr="10.0.0.1:48898",status="error"} 1
+twincat_reconnects_total{addr="10.0.0.1:48898",status="ok"} 1
+# HELP twincat_unknown_packets_total Total number of unknown packets.
+# TYPE twincat_unknown_packets_total counter
+twincat_unknown_packets_total{cmd="DeviceNotification"} 1
+# HELP twincat_late_responses_total Total number of responses to canceled or timed out requests.
+# TYPE twincat_late_responses_total counter
+twincat_late_responses_total{cmd="Read"} 1
+`
+	verify.Values(t, "", buf.String(), want)
+}
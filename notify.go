@@ -0,0 +1,414 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// NotificationOptions configure when the target sends notifications.
+type NotificationOptions struct {
+	// Mode is the transmission mode. If zero,
+	// ams.TransModeServerOnChange is used.
+	Mode uint32
+
+	// MaxDelay is the time after which the target sends a
+	// notification at the latest.
+	MaxDelay time.Duration
+
+	// CycleTime is the interval in which the target checks the
+	// value for changes or sends it.
+	CycleTime time.Duration
+}
+
+// Notification is a value which the target has sent for a
+// subscription.
+type Notification struct {
+	Time time.Time
+	Data []byte
+}
+
+// Subscription is a device notification of a target. Notifications
+// are bound to the connection and the client adds them again after
+// it has dialed again. Subscriptions of a symbol path are resolved
+// again after an online change if SymbolWatch.Resolve is set.
+type Subscription struct {
+	c              *Client
+	target, sender ams.Addr
+	path           string
+	opts           NotificationOptions
+	f              func(Notification)
+
+	// mu protects the fields below and serializes add and delete.
+	mu                    sync.Mutex
+	group, offset, length uint32
+	handle                uint32
+	active                bool
+	closed                bool
+
+	// queued are the notifications which arrived before the handle
+	// was bound. They are protected by the mutex of subscriptions.
+	queued []Notification
+}
+
+// subscriptions are the subscriptions of a client by target and
+// notification handle.
+type subscriptions struct {
+	mu       sync.Mutex
+	all      map[*Subscription]bool
+	byHandle map[string]*Subscription
+
+	// adding counts the notifications which are being added by
+	// target. The target sends the first sample right after the
+	// response, so meanwhile the samples of unknown handles are kept
+	// in pending until the handle is bound.
+	adding  map[string]int
+	pending map[string][]Notification
+
+	// deliver serializes the calls of the notification callbacks.
+	deliver sync.Mutex
+}
+
+// maxPending is the maximum number of samples which are kept for an
+// unknown notification handle.
+const maxPending = 16
+
+func (l *subscriptions) add(s *Subscription) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.all == nil {
+		l.all = map[*Subscription]bool{}
+	}
+	l.all[s] = true
+}
+
+func (l *subscriptions) remove(s *Subscription) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.all, s)
+}
+
+func (l *subscriptions) bind(s *Subscription, handle uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.byHandle == nil {
+		l.byHandle = map[string]*Subscription{}
+	}
+	k := handleKey(s.target, handle)
+	l.byHandle[k] = s
+	s.queued = append(s.queued, l.pending[k]...)
+	delete(l.pending, k)
+}
+
+func (l *subscriptions) unbind(s *Subscription, handle uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	k := handleKey(s.target, handle)
+	if l.byHandle[k] == s {
+		delete(l.byHandle, k)
+	}
+	s.queued = nil
+}
+
+// begin marks a notification of the target as being added. The
+// returned func must be called when the request is done. It drops
+// the samples which were kept but not bound.
+func (l *subscriptions) begin(target ams.Addr) func() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.adding == nil {
+		l.adding = map[string]int{}
+	}
+	t := target.String()
+	l.adding[t]++
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.adding[t]--
+		if l.adding[t] > 0 {
+			return
+		}
+		delete(l.adding, t)
+		for k := range l.pending {
+			if strings.HasPrefix(k, t+"/") {
+				delete(l.pending, k)
+			}
+		}
+	}
+}
+
+// dispatch passes a sample to the subscription of the handle. The
+// samples of an unknown handle are kept while a notification of the
+// target is being added. It returns false if the sample was dropped.
+func (l *subscriptions) dispatch(target ams.Addr, handle uint32, n Notification) bool {
+	l.deliver.Lock()
+	defer l.deliver.Unlock()
+	l.mu.Lock()
+	k := handleKey(target, handle)
+	s := l.byHandle[k]
+	if s == nil {
+		keep := l.adding[target.String()] > 0 && len(l.pending[k]) < maxPending
+		if keep {
+			if l.pending == nil {
+				l.pending = map[string][]Notification{}
+			}
+			l.pending[k] = append(l.pending[k], n)
+		}
+		l.mu.Unlock()
+		return keep
+	}
+	queued := s.queued
+	s.queued = nil
+	l.mu.Unlock()
+	for _, q := range queued {
+		s.f(q)
+	}
+	s.f(n)
+	return true
+}
+
+// flush passes the samples which arrived before the handle of the
+// subscription was bound. s.mu must not be held since the callback
+// may call the methods of the subscription.
+func (l *subscriptions) flush(s *Subscription) {
+	l.deliver.Lock()
+	defer l.deliver.Unlock()
+	l.mu.Lock()
+	queued := s.queued
+	s.queued = nil
+	l.mu.Unlock()
+	for _, n := range queued {
+		s.f(n)
+	}
+}
+
+// list returns the subscriptions for which keep returns true.
+func (l *subscriptions) list(keep func(s *Subscription) bool) []*Subscription {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var list []*Subscription
+	for s := range l.all {
+		if keep(s) {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// Subscribe adds a device notification for the memory at the index
+// group and offset. f is called with every notification. It is
+// called synchronously by the receiver of the client and must not
+// block. The samples which arrive before Subscribe returns are
+// passed to f by the caller of Subscribe, one call at a time.
+func (c *Client) Subscribe(ctx context.Context, target, sender ams.Addr, group, offset, length uint32, opts NotificationOptions, f func(Notification)) (*Subscription, error) {
+	s := &Subscription{c: c, target: target, sender: sender, opts: opts, f: f, group: group, offset: offset, length: length}
+	if err := c.subscribe(ctx, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SubscribeSymbol adds a device notification for a symbol path like
+// MAIN.arr[2].x. The path is resolved with the symbol table of the
+// target. See Subscribe.
+func (c *Client) SubscribeSymbol(ctx context.Context, target, sender ams.Addr, path string, opts NotificationOptions, f func(Notification)) (*Subscription, error) {
+	s := &Subscription{c: c, target: target, sender: sender, path: path, opts: opts, f: f}
+	if err := c.subscribe(ctx, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *Client) subscribe(ctx context.Context, s *Subscription) error {
+	s.mu.Lock()
+	err := s.add(ctx)
+	if err == nil {
+		c.subs.add(s)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	c.subs.flush(s)
+	return nil
+}
+
+// add resolves the path of the subscription and adds the
+// notification on the target. s.mu must be held.
+func (s *Subscription) add(ctx context.Context) error {
+	c := s.c
+	if s.path != "" {
+		table, err := c.Symbols(ctx, s.target, s.sender)
+		if err != nil {
+			return err
+		}
+		loc, err := c.ResolveSymbol(ctx, s.target, s.sender, table, s.path)
+		if err != nil {
+			return err
+		}
+		s.group, s.offset, s.length = loc.IndexGroup, loc.IndexOffset, loc.Size
+	}
+	mode := s.opts.Mode
+	if mode == 0 {
+		mode = ams.TransModeServerOnChange
+	}
+	req := ams.NewAddDeviceNotificationRequest(s.target, s.sender, s.group, s.offset, s.length, mode,
+		uint32(s.opts.MaxDelay/time.Millisecond), uint32(s.opts.CycleTime/time.Millisecond))
+	defer c.subs.begin(s.target)()
+	resp, err := c.AddDeviceNotification(ctx, req)
+	if err != nil {
+		return err
+	}
+	if resp.Result != ams.NoError {
+		if s.path != "" {
+			return fmt.Errorf("failed to subscribe %s: %w", s.path, &ADSError{Code: resp.Result})
+		}
+		return &ADSError{Code: resp.Result}
+	}
+	s.handle, s.active = resp.NotificationHandle, true
+	c.subs.bind(s, s.handle)
+	return nil
+}
+
+// delete deletes the notification on the target. s.mu must be held.
+func (s *Subscription) delete(ctx context.Context) error {
+	if !s.active {
+		return nil
+	}
+	s.active = false
+	s.c.subs.unbind(s, s.handle)
+	resp, err := s.c.DeleteDeviceNotification(ctx, ams.NewDeleteDeviceNotificationRequest(s.target, s.sender, s.handle))
+	if err != nil {
+		return err
+	}
+	if resp.Result != ams.NoError {
+		return &ADSError{Code: resp.Result}
+	}
+	return nil
+}
+
+// renew adds the notification again. If del is set the current
+// notification is deleted first. Errors of the delete are ignored
+// since the notification may already be gone.
+func (s *Subscription) renew(ctx context.Context, del bool) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	if del {
+		s.delete(ctx)
+	} else if s.active {
+		s.active = false
+		s.c.subs.unbind(s, s.handle)
+	}
+	err := s.add(ctx)
+	s.mu.Unlock()
+	s.c.subs.flush(s)
+	return err
+}
+
+// Path returns the symbol path of the subscription or an empty
+// string for subscriptions of an index group and offset.
+func (s *Subscription) Path() string {
+	return s.path
+}
+
+// Active returns false if the notification could not be added again
+// after a reconnect or a change of the symbol version. See
+// EventSubscriptionFailed. An inactive subscription is added again
+// with the next reconnect.
+func (s *Subscription) Active() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active
+}
+
+// Location returns the index group, offset and length of the
+// subscribed memory. They change when the path is resolved again.
+func (s *Subscription) Location() (group, offset, length uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.group, s.offset, s.length
+}
+
+// Close deletes the notification on the target.
+func (s *Subscription) Close(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.c.subs.remove(s)
+	return s.delete(ctx)
+}
+
+// notify passes the samples of a DeviceNotification request to the
+// subscriptions.
+func (c *Client) notify(ctx context.Context, req *ams.DeviceNotificationRequest) {
+	for i := range req.Stamps {
+		stamp := &req.Stamps[i]
+		for _, sample := range stamp.Samples {
+			n := Notification{Time: stamp.Time(), Data: sample.Data}
+			if !c.subs.dispatch(req.Header().Sender, sample.NotificationHandle, n) {
+				c.log(ctx, LevelDebug, "unknown notification", "target", req.Header().Sender, "handle", sample.NotificationHandle)
+			}
+		}
+	}
+}
+
+// restoreSubscriptions adds the notifications again after the client
+// has dialed again since the target deletes them with the connection.
+// A subscription which cannot be added again stays inactive and an
+// EventSubscriptionFailed is emitted.
+func (c *Client) restoreSubscriptions(ctx context.Context) {
+	for _, s := range c.subs.list(func(*Subscription) bool { return true }) {
+		if err := s.renew(ctx, false); err != nil {
+			c.subscriptionFailed(s, err)
+		}
+	}
+}
+
+// subscriptionFailed reports a subscription which could not be added
+// again.
+func (c *Client) subscriptionFailed(s *Subscription, err error) {
+	c.log(context.Background(), LevelWarn, "failed to restore subscription", "target", s.target, "path", s.path, "err", err)
+	c.emit(Event{Type: EventSubscriptionFailed, Subscription: s, Err: err})
+}
+
+// AddDeviceNotification sends an AddDeviceNotification request to the
+// server. Use Subscribe to receive the notifications.
+func (c *Client) AddDeviceNotification(ctx context.Context, r *ams.AddDeviceNotificationRequest) (*ams.AddDeviceNotificationResponse, error) {
+	var resp *ams.AddDeviceNotificationResponse
+	err := c.send(ctx, r, func(r ams.Response) error {
+		if x, ok := r.(*ams.AddDeviceNotificationResponse); ok {
+			resp = x
+			return nil
+		}
+		return fmt.Errorf("got %T want %T", r, resp)
+	})
+	return resp, err
+}
+
+// DeleteDeviceNotification sends a DeleteDeviceNotification request to
+// the server.
+func (c *Client) DeleteDeviceNotification(ctx context.Context, r *ams.DeleteDeviceNotificationRequest) (*ams.DeleteDeviceNotificationResponse, error) {
+	var resp *ams.DeleteDeviceNotificationResponse
+	err := c.send(ctx, r, func(r ams.Response) error {
+		if x, ok := r.(*ams.DeleteDeviceNotificationResponse); ok {
+			resp = x
+			return nil
+		}
+		return fmt.Errorf("got %T want %T", r, resp)
+	})
+	return resp, err
+}
@@ -0,0 +1,171 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// nextNotification returns the next notification of a channel.
+func nextNotification(t *testing.T, ch <-chan Notification) Notification {
+	t.Helper()
+	select {
+	case n := <-ch:
+		return n
+	case <-time.After(time.Second):
+		t.Fatal("no notification")
+		return Notification{}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+
+	ch := make(chan Notification, 10)
+	sub, err := c.Subscribe(context.Background(), testTarget, testSender, 0x4020, 4, 2, NotificationOptions{}, func(n Notification) { ch <- n })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	s.write(0x4020, 4, []byte{1, 2})
+	s.mu.Unlock()
+	s.notify(0x4020, 4)
+	n := nextNotification(t, ch)
+	verify.Values(t, "data", n.Data, []byte{1, 2})
+	if time.Since(n.Time) > time.Minute {
+		t.Errorf("invalid time %v", n.Time)
+	}
+
+	if err := sub.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	notes := len(s.notes)
+	s.mu.Unlock()
+	verify.Values(t, "notifications after close", notes, 0)
+}
+
+func TestSubscribeInitial(t *testing.T) {
+	s := newTestServer(t, func(s *testServer) { s.initial = true })
+	s.write(0x4020, 4, []byte{1, 2})
+	c := s.dial(t)
+
+	ch := make(chan Notification, 10)
+	sub, err := c.Subscribe(context.Background(), testTarget, testSender, 0x4020, 4, 2, NotificationOptions{}, func(n Notification) { ch <- n })
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "initial", nextNotification(t, ch).Data, []byte{1, 2})
+
+	s.mu.Lock()
+	s.write(0x4020, 4, []byte{3, 4})
+	s.mu.Unlock()
+	s.notify(0x4020, 4)
+	verify.Values(t, "next", nextNotification(t, ch).Data, []byte{3, 4})
+
+	// the initial value of a restored subscription is not lost either
+	c.connection().Close()
+	s.mu.Lock()
+	s.notes = map[uint32]*testNotification{}
+	s.mu.Unlock()
+	if err := c.Dial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "restored", nextNotification(t, ch).Data, []byte{3, 4})
+	if err := sub.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubscribeReconnect(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+
+	ch := make(chan Notification, 10)
+	_, err := c.Subscribe(context.Background(), testTarget, testSender, 0x4020, 0, 1, NotificationOptions{}, func(n Notification) { ch <- n })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the server drops the notifications with the connection
+	c.connection().Close()
+	s.mu.Lock()
+	s.notes = map[uint32]*testNotification{}
+	s.mu.Unlock()
+	if err := c.Dial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the subscriptions are restored after the dial
+	waitFor(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.notes) == 1
+	})
+	s.mu.Lock()
+	s.write(0x4020, 0, []byte{7})
+	s.mu.Unlock()
+	s.notify(0x4020, 0)
+	verify.Values(t, "data", nextNotification(t, ch).Data, []byte{7})
+}
+
+func TestSubscribeRestoreError(t *testing.T) {
+	s := newTestServer(t)
+	events := make(chan Event, 10)
+	c := s.dial(t, withEvents(events))
+
+	sub, err := c.Subscribe(context.Background(), testTarget, testSender, 0x4020, 0, 1, NotificationOptions{}, func(Notification) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the target rejects the notification after the reconnect
+	s.setHandler(func(req ams.Packet) ams.Packet {
+		if _, ok := req.(*ams.AddDeviceNotificationRequest); ok {
+			return ams.NewAddDeviceNotificationResponse(req.Header().Sender, req.Header().Target, ams.DeviceInvalidOffset, 0)
+		}
+		return s.respond(req)
+	})
+	c.connection().Close()
+	if err := c.Dial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	e := nextEvent(t, events, EventSubscriptionFailed)
+	if e.Subscription != sub {
+		t.Fatalf("got subscription %p want %p", e.Subscription, sub)
+	}
+	verify.Values(t, "err", e.Err, &ADSError{Code: ams.DeviceInvalidOffset})
+	verify.Values(t, "active", sub.Active(), false)
+}
+
+func TestSubscribeError(t *testing.T) {
+	s := newTestServer(t)
+	s.setHandler(func(req ams.Packet) ams.Packet {
+		if _, ok := req.(*ams.AddDeviceNotificationRequest); ok {
+			return ams.NewAddDeviceNotificationResponse(req.Header().Sender, req.Header().Target, ams.DeviceInvalidOffset, 0)
+		}
+		return s.respond(req)
+	})
+	c := s.dial(t)
+
+	sub, err := c.Subscribe(context.Background(), testTarget, testSender, 0x4020, 0, 1, NotificationOptions{}, func(Notification) {})
+	verify.Values(t, "err", err, &ADSError{Code: ams.DeviceInvalidOffset})
+	if sub != nil {
+		t.Errorf("got subscription %p want nil", sub)
+	}
+
+	sub, err = c.SubscribeSymbol(context.Background(), testTarget, testSender, "MAIN.unknown", NotificationOptions{}, func(Notification) {})
+	if err == nil || sub != nil {
+		t.Errorf("got %p, %v want nil and an error", sub, err)
+	}
+}
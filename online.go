@@ -0,0 +1,392 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// SymbolWatch configures the monitoring of the symbol version of a
+// target. The version changes with an online change or a download
+// of the PLC program. Afterwards cached symbol handles and resolved
+// offsets may point to other memory.
+//
+// The client subscribes to the symbol version and reads it again
+// after it has dialed again and, with a Heartbeat, after the PLC was
+// restarted. On a change it forgets the symbol handles and the symbol
+// table of the target and emits an EventSymbolVersion.
+type SymbolWatch struct {
+	Target, Sender ams.Addr
+
+	// Resolve resolves the registered variables and the
+	// subscriptions of symbol paths again after a change.
+	// Otherwise variables are resolved with their next access and
+	// subscriptions keep their memory location.
+	Resolve bool
+}
+
+// symbolWatch holds the state of the symbol version monitoring.
+type symbolWatch struct {
+	// mu protects the fields below.
+	mu      sync.Mutex
+	sub     *Subscription
+	known   bool
+	version uint8
+}
+
+// startSymbolWatch subscribes to the symbol version of the target
+// and reads the current version. It is called after every dial.
+func (c *Client) startSymbolWatch(ctx context.Context) {
+	w := c.SymbolWatch
+	if w == nil {
+		return
+	}
+	c.watch.mu.Lock()
+	subscribed := c.watch.sub != nil
+	c.watch.mu.Unlock()
+
+	if !subscribed {
+		sub, err := c.Subscribe(ctx, w.Target, w.Sender, ams.IdxSymVersion, 0, 1, NotificationOptions{}, func(n Notification) {
+			if len(n.Data) == 0 {
+				return
+			}
+			// the check sends requests and must not block the receiver
+			v := n.Data[0]
+			go func() {
+				ctx, cancel := c.workContext()
+				defer cancel()
+				c.checkSymbolVersion(ctx, v)
+			}()
+		})
+		if err != nil {
+			c.log(ctx, LevelWarn, "failed to subscribe to the symbol version", "target", w.Target, "err", err)
+		} else {
+			c.watch.mu.Lock()
+			c.watch.sub = sub
+			c.watch.mu.Unlock()
+		}
+	}
+
+	// the version may have changed while the client was disconnected
+	c.readSymbolVersion(ctx)
+}
+
+// readSymbolVersion reads the symbol version of the watched target
+// and checks it for a change.
+func (c *Client) readSymbolVersion(ctx context.Context) {
+	w := c.SymbolWatch
+	r, err := c.Read(Idempotent(ctx), ams.NewReadRequest(w.Target, w.Sender, ams.IdxSymVersion, 0, 1))
+	switch {
+	case err != nil:
+		c.log(ctx, LevelWarn, "failed to read the symbol version", "target", w.Target, "err", err)
+	case r.Result != ams.NoError || len(r.Data) < 1:
+		c.log(ctx, LevelWarn, "failed to read the symbol version", "target", w.Target, "err", &ADSError{Code: r.Result})
+	default:
+		c.checkSymbolVersion(ctx, r.Data[0])
+	}
+}
+
+// checkSymbolVersion handles a change of the symbol version. The
+// first version is only recorded.
+func (c *Client) checkSymbolVersion(ctx context.Context, v uint8) {
+	c.watch.mu.Lock()
+	old, known := c.watch.version, c.watch.known
+	c.watch.version, c.watch.known = v, true
+	c.watch.mu.Unlock()
+	if !known || old == v {
+		return
+	}
+
+	w := c.SymbolWatch
+	c.log(ctx, LevelInfo, "symbol version changed", "target", w.Target, "old", old, "new", v)
+	err := c.invalidateSymbols(ctx, w.Target, w.Resolve)
+	c.emit(Event{Type: EventSymbolVersion, OldSymbolVersion: old, NewSymbolVersion: v, Err: err})
+}
+
+// restarted is called when the heartbeat has seen the target enter
+// the RUN state. A restart can change the symbol version without a
+// notification.
+func (c *Client) restarted() {
+	if c.SymbolWatch == nil {
+		return
+	}
+	ctx, cancel := c.workContext()
+	defer cancel()
+	c.readSymbolVersion(ctx)
+}
+
+// SymbolVersion returns the last known symbol version of the watched
+// target. ok is false if the version is not known yet.
+func (c *Client) SymbolVersion() (v uint8, ok bool) {
+	c.watch.mu.Lock()
+	defer c.watch.mu.Unlock()
+	return c.watch.version, c.watch.known
+}
+
+// invalidateSymbols forgets the symbol handles and the symbol table of
+// the target and the locations of its variables. With resolve set the
+// variables and the subscriptions of symbol paths are resolved again
+// and the first error is returned.
+func (c *Client) invalidateSymbols(ctx context.Context, target ams.Addr, resolve bool) error {
+	c.handles.clear(target)
+	c.tables.remove(target)
+	vars := c.vars.list(target)
+	for _, v := range vars {
+		v.invalidate()
+	}
+	if !resolve {
+		return nil
+	}
+
+	var first error
+	fail := func(msg, path string, err error) {
+		c.log(ctx, LevelWarn, msg, "target", target, "path", path, "err", err)
+		if first == nil {
+			first = err
+		}
+	}
+	for _, v := range vars {
+		if _, err := v.Location(ctx); err != nil {
+			fail("failed to resolve variable", v.path, err)
+		}
+	}
+	subs := c.subs.list(func(s *Subscription) bool { return s.path != "" && s.target.String() == target.String() })
+	for _, s := range subs {
+		if err := s.renew(ctx, true); err != nil {
+			c.subscriptionFailed(s, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// symbolTables caches the symbol tables of a client by target.
+type symbolTables struct {
+	mu     sync.Mutex
+	tables map[string]*SymbolTable // by target
+}
+
+func (t *symbolTables) get(target ams.Addr) *SymbolTable {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tables[target.String()]
+}
+
+func (t *symbolTables) set(target ams.Addr, table *SymbolTable) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tables == nil {
+		t.tables = map[string]*SymbolTable{}
+	}
+	t.tables[target.String()] = table
+}
+
+func (t *symbolTables) remove(target ams.Addr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.tables, target.String())
+}
+
+// Symbols returns the symbol table of the target. The table is
+// uploaded once and cached until the symbol version changes. See
+// SymbolWatch.
+func (c *Client) Symbols(ctx context.Context, target, sender ams.Addr) (*SymbolTable, error) {
+	if t := c.tables.get(target); t != nil {
+		return t, nil
+	}
+	t, err := c.UploadSymbols(ctx, target, sender)
+	if err != nil {
+		return nil, err
+	}
+	c.tables.set(target, t)
+	return t, nil
+}
+
+// variables are the registered variables of a client.
+type variables struct {
+	mu   sync.Mutex
+	vars map[*Variable]bool
+}
+
+func (l *variables) add(v *Variable) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.vars == nil {
+		l.vars = map[*Variable]bool{}
+	}
+	l.vars[v] = true
+}
+
+func (l *variables) remove(v *Variable) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.vars, v)
+}
+
+func (l *variables) list(target ams.Addr) []*Variable {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var list []*Variable
+	for v := range l.vars {
+		if v.target.String() == target.String() {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// Variable is a registered symbol path. Its location is resolved with
+// the symbol table of the target and resolved again after the symbol
+// version has changed.
+type Variable struct {
+	c              *Client
+	target, sender ams.Addr
+	path           string
+
+	mu  sync.Mutex
+	loc *Location
+}
+
+// Register resolves a symbol path like MAIN.arr[2].x and registers it
+// as a variable of the client.
+func (c *Client) Register(ctx context.Context, target, sender ams.Addr, path string) (*Variable, error) {
+	v := &Variable{c: c, target: target, sender: sender, path: path}
+	if _, err := v.Location(ctx); err != nil {
+		return nil, err
+	}
+	c.vars.add(v)
+	return v, nil
+}
+
+// Unregister removes the variable from the client. It is no longer
+// resolved again after a change of the symbol version.
+func (v *Variable) Unregister() {
+	v.c.vars.remove(v)
+}
+
+// Path returns the symbol path of the variable.
+func (v *Variable) Path() string {
+	return v.path
+}
+
+// Location returns the location of the variable. It is resolved if
+// the symbol version has changed since the last call.
+func (v *Variable) Location(ctx context.Context) (*Location, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.loc != nil {
+		return v.loc, nil
+	}
+	t, err := v.c.Symbols(ctx, v.target, v.sender)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := v.c.ResolveSymbol(ctx, v.target, v.sender, t, v.path)
+	if err != nil {
+		return nil, err
+	}
+	v.loc = loc
+	return loc, nil
+}
+
+func (v *Variable) invalidate() {
+	v.mu.Lock()
+	v.loc = nil
+	v.mu.Unlock()
+}
+
+// Read reads the value of the variable into the value ptr points to.
+// See Client.WriteSymbol for the supported types.
+func (v *Variable) Read(ctx context.Context, ptr interface{}) error {
+	b, err := v.read(ctx)
+	if err != nil {
+		return err
+	}
+	return decodeValue(b, ptr)
+}
+
+// Value reads the value of the variable and decodes it like
+// SymbolTable.Decode.
+func (v *Variable) Value(ctx context.Context) (interface{}, error) {
+	b, err := v.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t, err := v.c.Symbols(ctx, v.target, v.sender)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := v.Location(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return t.Decode(loc.Type, b)
+}
+
+func (v *Variable) read(ctx context.Context) ([]byte, error) {
+	loc, err := v.Location(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r, err := v.c.Read(ctx, ams.NewReadRequest(v.target, v.sender, loc.IndexGroup, loc.IndexOffset, loc.Size))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", v.path, err)
+	}
+	if r.Result != ams.NoError {
+		v.stale(loc, r.Result)
+		return nil, fmt.Errorf("failed to read %s: %w", v.path, &ADSError{Code: r.Result})
+	}
+	return r.Data, nil
+}
+
+// Write writes a value to the variable. See Client.WriteSymbol for the
+// supported types. Strings must fit into the variable and other
+// values must have its size.
+func (v *Variable) Write(ctx context.Context, val interface{}) error {
+	b, err := encodeValue(val)
+	if err != nil {
+		return err
+	}
+	loc, err := v.Location(ctx)
+	if err != nil {
+		return err
+	}
+	_, isString := val.(string)
+	if len(b) > int(loc.Size) || !isString && len(b) != int(loc.Size) {
+		return fmt.Errorf("failed to write %s: got %d bytes want %d", v.path, len(b), loc.Size)
+	}
+	r, err := v.c.Write(ctx, ams.NewWriteRequest(v.target, v.sender, loc.IndexGroup, loc.IndexOffset, b))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", v.path, err)
+	}
+	if r.Result != ams.NoError {
+		v.stale(loc, r.Result)
+		return fmt.Errorf("failed to write %s: %w", v.path, &ADSError{Code: r.Result})
+	}
+	return nil
+}
+
+// stale resolves the variable again with the next access if the
+// target reports that its handle is no longer valid.
+func (v *Variable) stale(loc *Location, result uint32) {
+	if loc.IndexGroup != ams.IdxReadWriteSymValueByHandle {
+		return
+	}
+	switch result {
+	case ams.DeviceSymbolNotFound, ams.DeviceSymbolVersionInvalid, ams.DeviceNotifyHandleInvalid:
+		v.c.staleHandle(v.target, loc.IndexOffset, result)
+		v.mu.Lock()
+		if v.loc == loc {
+			v.loc = nil
+		}
+		v.mu.Unlock()
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// onlineChange moves MAIN.nValue to another offset and increments the
+// symbol version like an online change of the PLC program.
+func (s *testServer) onlineChange(offset uint32) {
+	symbols, types := testSymbols()
+	symbols[0].IndexOffset = offset
+	s.serveSymbols(symbols, types)
+	s.mu.Lock()
+	s.handles = map[string]uint32{"other": 1}
+	s.write(ams.IdxSymVersion, 0, []byte{s.read(ams.IdxSymVersion, 0, 1)[0] + 1})
+	s.mu.Unlock()
+}
+
+func TestSymbolWatch(t *testing.T) {
+	for _, resolve := range []bool{false, true} {
+		t.Run("", func(t *testing.T) {
+			s := newTestServer(t)
+			s.serveSymbols(testSymbols())
+			s.mem[ams.IdxSymVersion] = []byte{3}
+
+			events := make(chan Event, 10)
+			c := s.dial(t, withEvents(events), func(c *Client) {
+				c.SymbolWatch = &SymbolWatch{Target: testTarget, Sender: testSender, Resolve: resolve}
+			})
+			ctx := context.Background()
+
+			// the watch starts after the dial
+			waitFor(t, func() bool {
+				_, ok := c.SymbolVersion()
+				return ok
+			})
+			if v, ok := c.SymbolVersion(); !ok || v != 3 {
+				t.Fatalf("got symbol version %d, %v want 3", v, ok)
+			}
+
+			v, err := c.Register(ctx, testTarget, testSender, "MAIN.nValue")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sub, err := c.SubscribeSymbol(ctx, testTarget, testSender, "MAIN.nValue", NotificationOptions{}, func(Notification) {})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.GetSymHandleByName(ctx, testTarget, testSender, "MAIN.fb"); err != nil {
+				t.Fatal(err)
+			}
+
+			s.onlineChange(10)
+			s.notify(ams.IdxSymVersion, 0)
+			e := nextEvent(t, events, EventSymbolVersion)
+			verify.Values(t, "versions", []uint8{e.OldSymbolVersion, e.NewSymbolVersion}, []uint8{3, 4})
+			verify.Values(t, "err", e.Err, nil)
+
+			if _, ok := c.handles.handle(testTarget, "MAIN.fb"); ok {
+				t.Error("handle was not invalidated")
+			}
+			_, offset, _ := sub.Location()
+			want := uint32(0)
+			if resolve {
+				want = 10
+			}
+			verify.Values(t, "subscription offset", offset, want)
+
+			s.mu.Lock()
+			s.write(0x4020, 10, []byte{42, 0})
+			s.mu.Unlock()
+			var n int16
+			if err := v.Read(ctx, &n); err != nil {
+				t.Fatal(err)
+			}
+			verify.Values(t, "value", n, int16(42))
+		})
+	}
+}
+
+func TestSymbolWatchResolving(t *testing.T) {
+	s := newTestServer(t)
+	s.serveSymbols(testSymbols())
+	s.mem[ams.IdxSymVersion] = []byte{1}
+
+	events := make(chan Event, 10)
+	c := s.dial(t, withEvents(events), func(c *Client) {
+		c.SymbolWatch = &SymbolWatch{Target: testTarget, Sender: testSender, Resolve: true}
+	})
+	ctx := context.Background()
+	waitFor(t, func() bool {
+		_, ok := c.SymbolVersion()
+		return ok
+	})
+	if _, err := c.Register(ctx, testTarget, testSender, "MAIN.nValue"); err != nil {
+		t.Fatal(err)
+	}
+
+	// hold the upload of the new symbol table
+	release := make(chan struct{})
+	s.setHandler(func(req ams.Packet) ams.Packet {
+		if r, ok := req.(*ams.ReadRequest); ok && r.IndexGroup == ams.IdxSymUploadInfo2 {
+			<-release
+		}
+		return s.respond(req)
+	})
+	s.onlineChange(10)
+	s.notify(ams.IdxSymVersion, 0)
+
+	// the new version is visible while the variables are resolved
+	waitFor(t, func() bool {
+		v, _ := c.SymbolVersion()
+		return v == 2
+	})
+	close(release)
+	e := nextEvent(t, events, EventSymbolVersion)
+	verify.Values(t, "err", e.Err, nil)
+}
+
+func TestSymbolWatchReconnect(t *testing.T) {
+	s := newTestServer(t)
+	s.serveSymbols(testSymbols())
+	s.mem[ams.IdxSymVersion] = []byte{1}
+
+	events := make(chan Event, 10)
+	c := s.dial(t, withEvents(events), func(c *Client) {
+		c.SymbolWatch = &SymbolWatch{Target: testTarget, Sender: testSender}
+	})
+	ctx := context.Background()
+	if _, err := c.Symbols(ctx, testTarget, testSender); err != nil {
+		t.Fatal(err)
+	}
+
+	// the PLC program changes while the client is disconnected
+	c.connection().Close()
+	s.onlineChange(20)
+	if err := c.Dial(ctx); err != nil {
+		t.Fatal(err)
+	}
+	nextEvent(t, events, EventSymbolVersion)
+
+	table, err := c.Symbols(ctx, testTarget, testSender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "offset", table.Symbol("MAIN.nValue").IndexOffset, uint32(20))
+}
+
+func TestSymbolWatchRestart(t *testing.T) {
+	s := newTestServer(t)
+	s.serveSymbols(testSymbols())
+	s.mem[ams.IdxSymVersion] = []byte{1}
+
+	events := make(chan Event, 100)
+	c := s.dial(t, withEvents(events), func(c *Client) {
+		c.SymbolWatch = &SymbolWatch{Target: testTarget, Sender: testSender}
+		c.Heartbeat = &Heartbeat{Target: testTarget, Sender: testSender, Interval: 10 * time.Millisecond}
+	})
+	waitFor(t, func() bool { return !c.Health().LastBeat.IsZero() })
+
+	// a download without a notification while the PLC is stopped
+	s.mu.Lock()
+	s.state = ams.ADSStateStop
+	s.mu.Unlock()
+	nextEvent(t, events, EventStateChange)
+	s.onlineChange(20)
+	s.mu.Lock()
+	s.state = ams.ADSStateRun
+	s.mu.Unlock()
+
+	e := nextEvent(t, events, EventSymbolVersion)
+	verify.Values(t, "versions", []uint8{e.OldSymbolVersion, e.NewSymbolVersion}, []uint8{1, 2})
+}
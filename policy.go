@@ -0,0 +1,279 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// Errors of a WritePolicy. They are wrapped in a *WriteDeniedError.
+var (
+	ErrReadOnly         = errors.New("client is read-only")
+	ErrSymbolNotAllowed = errors.New("symbol not allowed")
+	ErrUnknownHandle    = errors.New("unknown symbol handle")
+	ErrRangeNotAllowed  = errors.New("index group/offset not allowed")
+)
+
+// WritePolicy restricts the writes of a client. Writes to symbols by
+// handle are checked against the symbol lists and all other writes
+// against the allowed ranges. Handles are resolved from the responses
+// to GetSymHandleByName requests of the same client. Since a symbol
+// can also be written by its index group and offset, a policy with
+// symbol lists denies all other writes unless AllowRanges contains
+// them.
+//
+// Requests which only write a request for data, e.g. handle lookups
+// or sum reads, are not writes. Sum writes are checked for every
+// contained write.
+type WritePolicy struct {
+	// ReadOnly denies all writes and WriteControl requests.
+	ReadOnly bool
+
+	// AllowSymbols contains the names or patterns of the symbols
+	// which can be written. A pattern can contain '*' for any
+	// sequence of characters and '?' for a single character. Names
+	// are compared case-insensitive like in TwinCAT. If empty, all
+	// symbols can be written unless they are denied.
+	AllowSymbols []string
+
+	// DenySymbols contains the names or patterns of the symbols
+	// which cannot be written. It has priority over AllowSymbols.
+	DenySymbols []string
+
+	// AllowRanges contains the memory areas which can be written
+	// by index group and offset. If empty, all areas can be written
+	// unless AllowSymbols or DenySymbols is set.
+	AllowRanges []AddrRange
+
+	// DryRun validates and logs the writes but does not send them.
+	// The client returns a successful response instead.
+	DryRun bool
+}
+
+// AddrRange is a memory area of an index group.
+type AddrRange struct {
+	IndexGroup uint32
+	Offset     uint32
+
+	// Length is the size of the area in bytes. If zero, the area
+	// covers the whole index group from Offset.
+	Length uint32
+}
+
+func (r AddrRange) contains(group, offset, n uint32) bool {
+	if group != r.IndexGroup || offset < r.Offset {
+		return false
+	}
+	return r.Length == 0 || uint64(offset)+uint64(n) <= uint64(r.Offset)+uint64(r.Length)
+}
+
+// WriteDeniedError is returned for a write which violates the
+// WritePolicy. Err is one of the errors of the policy.
+type WriteDeniedError struct {
+	Target      ams.Addr
+	Symbol      string // empty for writes by index group and offset
+	IndexGroup  uint32
+	IndexOffset uint32
+	Err         error
+}
+
+func (e *WriteDeniedError) Error() string {
+	if e.Symbol != "" {
+		return fmt.Sprintf("write to %s on %s denied: %s", e.Symbol, e.Target, e.Err)
+	}
+	return fmt.Sprintf("write to 0x%x/0x%x on %s denied: %s", e.IndexGroup, e.IndexOffset, e.Target, e.Err)
+}
+
+func (e *WriteDeniedError) Unwrap() error { return e.Err }
+
+// writeAccess is a single write of a request.
+type writeAccess struct {
+	group, offset, length uint32
+	data                  []byte
+	index                 int    // index of the write in a sum request
+	handle                bool   // write by symbol handle
+	symbol                string // resolved symbol of the handle
+	control               bool   // WriteControl request
+}
+
+// check returns an error if the policy denies w.
+func (p *WritePolicy) check(target ams.Addr, w writeAccess) error {
+	err := p.deny(w)
+	if err == nil {
+		return nil
+	}
+	return &WriteDeniedError{
+		Target:      target,
+		Symbol:      w.symbol,
+		IndexGroup:  w.group,
+		IndexOffset: w.offset,
+		Err:         err,
+	}
+}
+
+func (p *WritePolicy) deny(w writeAccess) error {
+	switch {
+	case p.ReadOnly:
+		return ErrReadOnly
+	case w.control:
+		return nil
+	case w.handle:
+		if len(p.AllowSymbols) == 0 && len(p.DenySymbols) == 0 {
+			return nil
+		}
+		if w.symbol == "" {
+			return ErrUnknownHandle
+		}
+		if matchAny(p.DenySymbols, w.symbol) {
+			return ErrSymbolNotAllowed
+		}
+		if len(p.AllowSymbols) > 0 && !matchAny(p.AllowSymbols, w.symbol) {
+			return ErrSymbolNotAllowed
+		}
+		return nil
+	default:
+		if len(p.AllowRanges) == 0 && len(p.AllowSymbols) == 0 && len(p.DenySymbols) == 0 {
+			return nil
+		}
+		for _, r := range p.AllowRanges {
+			if r.contains(w.group, w.offset, w.length) {
+				return nil
+			}
+		}
+		return ErrRangeNotAllowed
+	}
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if matchSymbol(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSymbol reports whether name matches the pattern with '*' and
+// '?' wildcards. The comparison is case-insensitive.
+func matchSymbol(pattern, name string) bool {
+	p, n := []rune(strings.ToLower(pattern)), []rune(strings.ToLower(name))
+	var i, j int
+	star, next := -1, 0
+	for j < len(n) {
+		switch {
+		case i < len(p) && (p[i] == '?' || p[i] == n[j]):
+			i++
+			j++
+		case i < len(p) && p[i] == '*':
+			star, next = i, j
+			i++
+		case star >= 0:
+			// let the last star match one more character
+			next++
+			i, j = star+1, next
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
+
+// writes returns the writes of a request.
+func (c *Client) writes(req ams.Packet) []writeAccess {
+	target := req.Header().Target
+	access := func(group, offset, length uint32, data []byte) []writeAccess {
+		switch group {
+		case ams.IdxGetSymHandleByName, ams.IdxReserved, ams.IdxReleaseSymHandle, ams.IdxADSIGRP_SUMUP_READ:
+			return nil
+		case ams.IdxReadWriteSymValueByHandle:
+			return []writeAccess{{group: group, offset: offset, length: length, data: data, handle: true, symbol: c.handles.name(target, offset)}}
+		default:
+			return []writeAccess{{group: group, offset: offset, length: length, data: data}}
+		}
+	}
+
+	switch r := req.(type) {
+	case *ams.WriteRequest:
+		return access(r.IndexGroup, r.IndexOffset, uint32(len(r.Data)), r.Data)
+	case *ams.WriteControlRequest:
+		return []writeAccess{{control: true, data: r.Data}}
+	case *ams.ReadWriteRequest:
+		switch r.IndexGroup {
+		case ams.IdxADSIGRP_SUMUP_WRITE, ams.IdxADSIGRP_SUMUP_READWRITE:
+			// the sub requests precede the data and contain the index
+			// group, offset, the read length for sum read/writes and
+			// the write length.
+			n, size := int(r.IndexOffset), 12
+			if r.IndexGroup == ams.IdxADSIGRP_SUMUP_READWRITE {
+				size = 16
+			}
+			if n*size > len(r.Data) {
+				// invalid request, check it as a whole
+				return access(r.IndexGroup, r.IndexOffset, uint32(len(r.Data)), r.Data)
+			}
+			var w []writeAccess
+			data := r.Data[n*size:]
+			for i := 0; i < n; i++ {
+				b := r.Data[i*size:]
+				length := binary.LittleEndian.Uint32(b[size-4:])
+				var d []byte
+				if int(length) <= len(data) {
+					d, data = data[:length], data[length:]
+				}
+				for _, a := range access(binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint32(b[4:]), length, d) {
+					a.index = i
+					w = append(w, a)
+				}
+			}
+			return w
+		}
+		return access(r.IndexGroup, r.IndexOffset, uint32(len(r.Data)), r.Data)
+	}
+	return nil
+}
+
+// dryRunResponse returns a successful response for a request which
+// is not sent.
+func dryRunResponse(req ams.Packet) ams.Response {
+	hdr := req.Header()
+	switch r := req.(type) {
+	case *ams.WriteRequest:
+		return ams.NewWriteResponse(hdr.Sender, hdr.Target, ams.NoError)
+	case *ams.ReadWriteRequest:
+		return ams.NewReadWriteResponse(hdr.Sender, hdr.Target, ams.NoError, make([]byte, r.ReadLength))
+	case *ams.WriteControlRequest:
+		return ams.NewWriteControlResponse(hdr.Sender, hdr.Target, ams.NoError)
+	}
+	return nil
+}
+
+// guard is the innermost interceptor of a client with a WritePolicy.
+func (c *Client) guard(p *WritePolicy) Interceptor {
+	return func(ctx context.Context, req ams.Packet, invoke Invoker) (ams.Response, error) {
+		w := c.writes(req)
+		for _, w := range w {
+			if err := p.check(req.Header().Target, w); err != nil {
+				c.log(ctx, LevelWarn, "write denied", append(logArgs(req.Header(), nil), "err", err)...)
+				return nil, err
+			}
+		}
+		if len(w) > 0 && p.DryRun {
+			if r := dryRunResponse(req); r != nil {
+				c.log(ctx, LevelInfo, "dry run", append(logArgs(req.Header(), nil), "writes", len(w))...)
+				return r, nil
+			}
+		}
+		return invoke(ctx, req)
+	}
+}
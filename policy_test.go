@@ -0,0 +1,196 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// countWrites returns a server handler which counts the Write requests.
+func countWrites(s *testServer, count *int32) func(ams.Packet) ams.Packet {
+	return func(req ams.Packet) ams.Packet {
+		if _, ok := req.(*ams.WriteRequest); ok {
+			atomic.AddInt32(count, 1)
+		}
+		return s.respond(req)
+	}
+}
+
+func TestMatchSymbol(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"MAIN.nValue", "MAIN.nValue", true},
+		{"MAIN.nValue", "main.NVALUE", true},
+		{"MAIN.nValue", "MAIN.nValue2", false},
+		{"MAIN.*", "MAIN.fb.nValue", true},
+		{"MAIN.*", "GVL.nValue", false},
+		{"*.bStart", "MAIN.fbMotor.bStart", true},
+		{"GVL.a[?]", "GVL.a[3]", true},
+		{"GVL.a[?]", "GVL.a[10]", false},
+		{"*a*b", "xaxxb", true},
+		{"*a*b", "xaxxbc", false},
+		{"*", "", true},
+	}
+	for _, tt := range tests {
+		if got := matchSymbol(tt.pattern, tt.name); got != tt.match {
+			t.Errorf("matchSymbol(%q, %q) = %v want %v", tt.pattern, tt.name, got, tt.match)
+		}
+	}
+}
+
+func TestWritePolicyReadOnly(t *testing.T) {
+	var writes int32
+	s := newTestServer(t)
+	s.setHandler(countWrites(s, &writes))
+	c := s.dial(t, func(c *Client) { c.WritePolicy = &WritePolicy{ReadOnly: true} })
+	ctx := context.Background()
+
+	_, err := c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, 0x4020, 0, []byte{1}))
+	var werr *WriteDeniedError
+	if !errors.As(err, &werr) || werr.Err != ErrReadOnly {
+		t.Fatalf("got %v want %v", err, ErrReadOnly)
+	}
+	verify.Values(t, "error", err.Error(), "write to 0x4020/0x0 on 1.2.3.4.5.6:851 denied: client is read-only")
+	verify.Values(t, "writes", atomic.LoadInt32(&writes), int32(0))
+
+	// reads and handle lookups are not writes
+	if _, err := c.Read(ctx, ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetSymHandleByName(ctx, testTarget, testSender, "MAIN.nValue"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWritePolicySymbols(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t, func(c *Client) {
+		c.WritePolicy = &WritePolicy{
+			AllowSymbols: []string{"MAIN.set*"},
+			DenySymbols:  []string{"MAIN.setLocked"},
+		}
+	})
+	ctx := context.Background()
+
+	write := func(name string) error {
+		h, err := c.GetSymHandleByName(ctx, testTarget, testSender, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, ams.IdxReadWriteSymValueByHandle, h, []byte{1}))
+		return err
+	}
+	if err := write("MAIN.setPoint"); err != nil {
+		t.Fatal(err)
+	}
+	if err := write("MAIN.setLocked"); !errors.Is(err, ErrSymbolNotAllowed) {
+		t.Fatalf("got %v want %v", err, ErrSymbolNotAllowed)
+	}
+	if err := write("MAIN.nCounter"); !errors.Is(err, ErrSymbolNotAllowed) {
+		t.Fatalf("got %v want %v", err, ErrSymbolNotAllowed)
+	}
+
+	// the symbols cannot be written by index group and offset
+	_, err := c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, 0x4020, 0, []byte{1}))
+	if !errors.Is(err, ErrRangeNotAllowed) {
+		t.Fatalf("got %v want %v", err, ErrRangeNotAllowed)
+	}
+	_, err = c.ReadWrite(ctx, ams.NewReadWriteRequest(testTarget, testSender, 0x4020, 0, 1, []byte{1}))
+	if !errors.Is(err, ErrRangeNotAllowed) {
+		t.Fatalf("got %v want %v", err, ErrRangeNotAllowed)
+	}
+
+	// a released handle is unknown
+	h, err := c.GetSymHandleByName(ctx, testTarget, testSender, "MAIN.setPoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, h)
+	if _, err := c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, ams.IdxReleaseSymHandle, 0, data)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, ams.IdxReadWriteSymValueByHandle, h, []byte{1}))
+	if !errors.Is(err, ErrUnknownHandle) {
+		t.Fatalf("got %v want %v", err, ErrUnknownHandle)
+	}
+}
+
+func TestWritePolicyRanges(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t, func(c *Client) {
+		c.WritePolicy = &WritePolicy{AllowRanges: []AddrRange{{IndexGroup: 0x4020, Offset: 10, Length: 4}}}
+	})
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		group, offset uint32
+		n             int
+		err           error
+	}{
+		{"inside", 0x4020, 10, 4, nil},
+		{"overlap", 0x4020, 12, 4, ErrRangeNotAllowed},
+		{"before", 0x4020, 9, 1, ErrRangeNotAllowed},
+		{"other group", 0x4040, 10, 1, ErrRangeNotAllowed},
+	}
+	for _, tt := range tests {
+		_, err := c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, tt.group, tt.offset, make([]byte, tt.n)))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v want %v", tt.name, err, tt.err)
+		}
+	}
+
+	// every write of a sum write is checked
+	var b ams.Buffer
+	for _, w := range [][3]uint32{{0x4020, 10, 2}, {0x4020, 20, 2}} {
+		b.WriteUint32(w[0])
+		b.WriteUint32(w[1])
+		b.WriteUint32(w[2])
+	}
+	b.WriteN([]byte{1, 2, 3, 4}, 4)
+	_, err := c.ReadWrite(ctx, ams.NewReadWriteRequest(testTarget, testSender, ams.IdxADSIGRP_SUMUP_WRITE, 2, 8, b.Bytes()))
+	var werr *WriteDeniedError
+	if !errors.As(err, &werr) || werr.IndexOffset != 20 {
+		t.Fatalf("got %v want denied write at offset 20", err)
+	}
+}
+
+func TestWritePolicyDryRun(t *testing.T) {
+	var writes int32
+	s := newTestServer(t)
+	s.setHandler(countWrites(s, &writes))
+	l := &memLogger{}
+	c := s.dial(t, func(c *Client) {
+		c.Logger = l
+		c.WritePolicy = &WritePolicy{
+			DryRun:      true,
+			AllowRanges: []AddrRange{{IndexGroup: 0x4020}},
+		}
+	})
+	ctx := context.Background()
+
+	r, err := c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, 0x4020, 0, []byte{1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "result", r.Result, uint32(ams.NoError))
+	verify.Values(t, "writes", atomic.LoadInt32(&writes), int32(0))
+
+	// dry run still validates
+	if _, err := c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, 0x4040, 0, []byte{1})); !errors.Is(err, ErrRangeNotAllowed) {
+		t.Fatalf("got %v want %v", err, ErrRangeNotAllowed)
+	}
+	verify.Values(t, "log", l.msgs(), []string{"INFO dry run", "WARN write denied"})
+}
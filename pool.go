@@ -0,0 +1,336 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// ErrPoolClosed is returned by a pool after Close was called.
+var ErrPoolClosed = errors.New("pool closed")
+
+// Pool manages the clients for many targets which are identified by
+// their AMS address. A client is created and dialed on first use and
+// shared by all requests for the target.
+//
+// Set the fields before the pool is used.
+type Pool struct {
+	// NewClient returns a new client for the target which is not
+	// yet dialed. It is required.
+	NewClient func(target ams.Addr) *Client
+
+	// Sender is the AMS address which is used as sender for the
+	// health checks.
+	Sender ams.Addr
+
+	// MaxConcurrent limits the number of concurrent requests per
+	// target. If zero, there is no limit.
+	MaxConcurrent int
+
+	// HealthInterval is the interval of the health checks. Every
+	// dialed target is checked with a ReadState request. A client
+	// which fails the check is closed and dialed again on next
+	// use. If zero, there are no health checks.
+	HealthInterval time.Duration
+
+	// DialTimeout limits the time to dial a target. The dial is
+	// also limited by the context of the request which needs the
+	// client. If zero, only the context limits the dial.
+	DialTimeout time.Duration
+
+	once    sync.Once
+	stop    chan struct{}
+	running sync.WaitGroup
+
+	mu      sync.Mutex
+	targets map[string]*poolTarget
+	closed  bool
+}
+
+// TargetStatus is the health of a target.
+type TargetStatus struct {
+	Target    ams.Addr
+	Healthy   bool
+	LastCheck time.Time // zero if not checked yet
+	Err       error     // error of the last dial or health check
+}
+
+type poolTarget struct {
+	addr ams.Addr
+	sem  chan struct{} // nil without limit
+
+	mu      sync.Mutex
+	client  *Client
+	dialing chan struct{} // closed when the running dial is done
+	status  TargetStatus
+}
+
+func (p *Pool) init() {
+	p.once.Do(func() {
+		p.stop = make(chan struct{})
+		p.targets = map[string]*poolTarget{}
+		if p.HealthInterval > 0 {
+			go p.healthLoop()
+		}
+	})
+}
+
+// target returns the entry for the target and creates it if needed.
+func (p *Pool) target(addr ams.Addr) (*poolTarget, error) {
+	p.init()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	k := addr.String()
+	t := p.targets[k]
+	if t == nil {
+		t = &poolTarget{addr: addr, status: TargetStatus{Target: addr}}
+		if p.MaxConcurrent > 0 {
+			t.sem = make(chan struct{}, p.MaxConcurrent)
+		}
+		p.targets[k] = t
+	}
+	// register the caller while holding the lock so that
+	// Close waits for it.
+	p.running.Add(1)
+	return t, nil
+}
+
+// client returns the client of the target and dials it if needed.
+// Only one caller dials at a time and the others wait for it. t.mu
+// is not held during the dial so that Status and the health checks
+// do not block.
+func (p *Pool) client(ctx context.Context, t *poolTarget) (*Client, error) {
+	t.mu.Lock()
+	for t.client == nil && t.dialing != nil {
+		dialing := t.dialing
+		t.mu.Unlock()
+		select {
+		case <-dialing:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		t.mu.Lock()
+	}
+	if t.client != nil {
+		c := t.client
+		t.mu.Unlock()
+		return c, nil
+	}
+	done := make(chan struct{})
+	t.dialing = done
+	t.mu.Unlock()
+
+	c := p.NewClient(t.addr)
+
+	// the client outlives the request, so ctx only limits the dial
+	// and the connection runs on the context of the client.
+	var cancel context.CancelFunc
+	if p.DialTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, p.DialTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	err := c.Dial(ctx)
+	cancel()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dialing = nil
+	close(done)
+	if err != nil {
+		t.status.Healthy, t.status.Err = false, err
+		return nil, err
+	}
+	// Close may have given up waiting for the dial
+	if p.isClosed() {
+		c.Close()
+		return nil, ErrPoolClosed
+	}
+	t.client = c
+	t.status.Healthy, t.status.Err = true, nil
+	return c, nil
+}
+
+// isClosed returns true after Close was called.
+func (p *Pool) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// Do calls f with the client of the target. The client is dialed
+// if needed. Do blocks while the target has MaxConcurrent running
+// calls or until ctx is done.
+func (p *Pool) Do(ctx context.Context, target ams.Addr, f func(ctx context.Context, c *Client) error) error {
+	t, err := p.target(target)
+	if err != nil {
+		return err
+	}
+	defer p.running.Done()
+
+	if t.sem != nil {
+		select {
+		case t.sem <- struct{}{}:
+			defer func() { <-t.sem }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	c, err := p.client(ctx, t)
+	if err != nil {
+		return err
+	}
+	return f(ctx, c)
+}
+
+// Result is the outcome of a request to a single target.
+type Result struct {
+	Target ams.Addr
+	Value  interface{}
+	Err    error
+}
+
+// FanOut calls f concurrently for every target and returns the
+// results in the order of the targets. The concurrency limit per
+// target applies.
+func (p *Pool) FanOut(ctx context.Context, targets []ams.Addr, f func(ctx context.Context, target ams.Addr, c *Client) (interface{}, error)) []Result {
+	res := make([]Result, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target ams.Addr) {
+			defer wg.Done()
+			res[i].Target = target
+			res[i].Err = p.Do(ctx, target, func(ctx context.Context, c *Client) error {
+				v, err := f(ctx, target, c)
+				res[i].Value = v
+				return err
+			})
+		}(i, target)
+	}
+	wg.Wait()
+	return res
+}
+
+// Status returns the health of all known targets sorted by address.
+func (p *Pool) Status() []TargetStatus {
+	p.init()
+	var st []TargetStatus
+	for _, t := range p.snapshot() {
+		t.mu.Lock()
+		st = append(st, t.status)
+		t.mu.Unlock()
+	}
+	return st
+}
+
+// snapshot returns the current targets sorted by address.
+func (p *Pool) snapshot() []*poolTarget {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make([]string, 0, len(p.targets))
+	for k := range p.targets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	ts := make([]*poolTarget, len(keys))
+	for i, k := range keys {
+		ts[i] = p.targets[k]
+	}
+	return ts
+}
+
+func (p *Pool) healthLoop() {
+	tick := time.NewTicker(p.HealthInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-tick.C:
+			var wg sync.WaitGroup
+			for _, t := range p.snapshot() {
+				wg.Add(1)
+				go func(t *poolTarget) {
+					defer wg.Done()
+					p.check(t)
+				}(t)
+			}
+			wg.Wait()
+		}
+	}
+}
+
+// check sends a ReadState request to a dialed target and closes
+// the client if the request fails.
+func (p *Pool) check(t *poolTarget) {
+	t.mu.Lock()
+	c := t.client
+	t.mu.Unlock()
+	if c == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.HealthInterval)
+	defer cancel()
+	_, err := c.ReadState(ctx, ams.NewReadStateRequest(t.addr, p.Sender))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.LastCheck = time.Now()
+	t.status.Healthy, t.status.Err = err == nil, err
+	if err != nil && t.client == c {
+		c.Close()
+		t.client = nil
+	}
+}
+
+// Close stops the health checks, waits for the running calls to
+// finish and closes all clients. If ctx is done before the calls
+// have finished, the clients are closed anyway and the error of
+// ctx is returned.
+func (p *Pool) Close(ctx context.Context) error {
+	p.init()
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPoolClosed
+	}
+	p.closed = true
+	close(p.stop)
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.running.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	for _, t := range p.snapshot() {
+		t.mu.Lock()
+		if t.client != nil {
+			t.client.Close()
+			t.client = nil
+		}
+		t.mu.Unlock()
+	}
+	return err
+}
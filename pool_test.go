@@ -0,0 +1,309 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// testPool returns a pool which connects the targets to the
+// network addresses. It counts the created clients in n.
+func testPool(addrs map[string]string, n *int32) *Pool {
+	return &Pool{
+		Sender: testSender,
+		NewClient: func(target ams.Addr) *Client {
+			atomic.AddInt32(n, 1)
+			return &Client{Addr: addrs[target.String()], ReadTimeout: time.Second}
+		},
+	}
+}
+
+// closedAddr returns an address without a listener.
+func closedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func readState(ctx context.Context, target ams.Addr, c *Client) (interface{}, error) {
+	resp, err := c.ReadState(ctx, ams.NewReadStateRequest(target, testSender))
+	if err != nil {
+		return nil, err
+	}
+	return resp.ADSState, nil
+}
+
+func TestPoolFanOut(t *testing.T) {
+	s1 := newTestServer(t)
+	s2 := newTestServer(t, func(s *testServer) { s.state = ams.ADSStateStop })
+
+	a := ams.MustParseAddr("1.1.1.1.1.1:851")
+	b := ams.MustParseAddr("2.2.2.2.1.1:851")
+	c := ams.MustParseAddr("3.3.3.3.1.1:851")
+	var n int32
+	p := testPool(map[string]string{
+		a.String(): s1.l.Addr().String(),
+		b.String(): s2.l.Addr().String(),
+		c.String(): closedAddr(t),
+	}, &n)
+	defer p.Close(context.Background())
+
+	res := p.FanOut(context.Background(), []ams.Addr{a, b, c}, readState)
+	if len(res) != 3 {
+		t.Fatalf("got %d results want 3", len(res))
+	}
+	verify.Values(t, "a", res[0], Result{Target: a, Value: uint16(ams.ADSStateRun)})
+	verify.Values(t, "b", res[1], Result{Target: b, Value: uint16(ams.ADSStateStop)})
+	if res[2].Target.String() != c.String() || res[2].Err == nil {
+		t.Fatalf("got %v want dial error for %s", res[2], c)
+	}
+
+	// the clients are reused
+	p.FanOut(context.Background(), []ams.Addr{a, b}, readState)
+	if got, want := atomic.LoadInt32(&n), int32(3); got != want {
+		t.Fatalf("got %d clients want %d", got, want)
+	}
+
+	st := p.Status()
+	if len(st) != 3 || !st[0].Healthy || !st[1].Healthy || st[2].Healthy || st[2].Err == nil {
+		t.Fatalf("unexpected status %v", st)
+	}
+}
+
+func TestPoolDialContext(t *testing.T) {
+	s := newTestServer(t)
+	a := ams.MustParseAddr("1.1.1.1.1.1:851")
+	var n int32
+	p := testPool(map[string]string{a.String(): s.l.Addr().String()}, &n)
+	p.DialTimeout = time.Second
+	defer p.Close(context.Background())
+
+	// the client outlives the request which has dialed it
+	ctx, cancel := context.WithCancel(context.Background())
+	err := p.Do(ctx, a, func(ctx context.Context, c *Client) error {
+		_, err := readState(ctx, a, c)
+		return err
+	})
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Do(context.Background(), a, func(ctx context.Context, c *Client) error {
+		_, err := readState(ctx, a, c)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "clients", atomic.LoadInt32(&n), int32(1))
+}
+
+func TestPoolSlowDial(t *testing.T) {
+	s := newTestServer(t)
+	a := ams.MustParseAddr("1.1.1.1.1.1:851")
+	release := make(chan struct{})
+	var dials int32
+	p := &Pool{
+		Sender: testSender,
+		NewClient: func(target ams.Addr) *Client {
+			return &Client{Addr: s.l.Addr().String(), ReadTimeout: time.Second, Dialer: DialerFunc(func(ctx context.Context, addr string) (net.Conn, error) {
+				atomic.AddInt32(&dials, 1)
+				<-release
+				return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+			})}
+		},
+	}
+	defer p.Close(context.Background())
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	defer unblock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = p.Do(context.Background(), a, func(ctx context.Context, c *Client) error {
+				_, err := readState(ctx, a, c)
+				return err
+			})
+		}(i)
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(&dials) == 1 })
+
+	// the status does not wait for the dial
+	st := make(chan []TargetStatus)
+	go func() { st <- p.Status() }()
+	select {
+	case got := <-st:
+		verify.Values(t, "status", got, []TargetStatus{{Target: a}})
+	case <-time.After(time.Second):
+		t.Fatal("Status blocked by the dial")
+	}
+
+	unblock()
+	wg.Wait()
+	verify.Values(t, "errors", errs, make([]error, 3))
+	verify.Values(t, "dials", atomic.LoadInt32(&dials), int32(1))
+}
+
+func TestPoolMaxConcurrent(t *testing.T) {
+	s := newTestServer(t)
+	var n int32
+	p := testPool(map[string]string{testTarget.String(): s.l.Addr().String()}, &n)
+	p.MaxConcurrent = 2
+	defer p.Close(context.Background())
+
+	var running, max int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.Do(context.Background(), testTarget, func(ctx context.Context, c *Client) error {
+				r := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&max)
+					if r <= m || atomic.CompareAndSwapInt32(&max, m, r) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got, want := atomic.LoadInt32(&max), int32(2); got != want {
+		t.Fatalf("got %d concurrent calls want %d", got, want)
+	}
+
+	// waiting for a slot honors the context
+	block := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go p.Do(context.Background(), testTarget, func(context.Context, *Client) error {
+			<-block
+			return nil
+		})
+	}
+	defer close(block)
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Do(ctx, testTarget, func(context.Context, *Client) error { return nil }); err != context.DeadlineExceeded {
+		t.Fatalf("got %v want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	var drop int32
+	s := newTestServer(t)
+	s.setHandler(func(req ams.Packet) ams.Packet {
+		if atomic.LoadInt32(&drop) == 1 {
+			return nil
+		}
+		return s.respond(req)
+	})
+
+	var n int32
+	p := testPool(map[string]string{testTarget.String(): s.l.Addr().String()}, &n)
+	p.HealthInterval = 20 * time.Millisecond
+	defer p.Close(context.Background())
+
+	if _, err := readStateDo(p); err != nil {
+		t.Fatal(err)
+	}
+
+	// wait until the health check fails
+	atomic.StoreInt32(&drop, 1)
+	waitFor(t, func() bool {
+		st := p.Status()
+		return len(st) == 1 && !st[0].Healthy && st[0].Err != nil
+	})
+
+	// the target is dialed again
+	atomic.StoreInt32(&drop, 0)
+	if _, err := readStateDo(p); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := atomic.LoadInt32(&n), int32(2); got != want {
+		t.Fatalf("got %d clients want %d", got, want)
+	}
+	waitFor(t, func() bool {
+		st := p.Status()
+		return len(st) == 1 && st[0].Healthy && !st[0].LastCheck.IsZero()
+	})
+}
+
+func readStateDo(p *Pool) (v interface{}, err error) {
+	err = p.Do(context.Background(), testTarget, func(ctx context.Context, c *Client) error {
+		v, err = readState(ctx, testTarget, c)
+		return err
+	})
+	return v, err
+}
+
+// waitFor waits up to one second until f returns true.
+func waitFor(t *testing.T, f func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if f() {
+			return
+		}
+	}
+	t.Fatal("timeout")
+}
+
+func TestPoolClose(t *testing.T) {
+	s := newTestServer(t)
+	var n int32
+	p := testPool(map[string]string{testTarget.String(): s.l.Addr().String()}, &n)
+
+	started, finish := make(chan struct{}), make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Do(context.Background(), testTarget, func(ctx context.Context, c *Client) error {
+			close(started)
+			<-finish
+			_, err := readState(ctx, testTarget, c)
+			return err
+		})
+	}()
+	<-started
+
+	closed := make(chan error, 1)
+	go func() { closed <- p.Close(context.Background()) }()
+
+	// the running call can finish
+	time.Sleep(10 * time.Millisecond)
+	close(finish)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Do(context.Background(), testTarget, func(context.Context, *Client) error { return nil }); err != ErrPoolClosed {
+		t.Fatalf("got %v want %v", err, ErrPoolClosed)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package record
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Conn is a net.Conn which records all data read from and
+// written to the underlying connection.
+type Conn struct {
+	net.Conn
+
+	mu  sync.Mutex
+	w   *Writer
+	err error
+}
+
+// NewConn returns a connection which records the traffic
+// of conn to w. The file header is written right away so
+// that a session without traffic is a valid recording.
+func NewConn(conn net.Conn, w io.Writer) *Conn {
+	c := &Conn{Conn: conn, w: NewWriter(w)}
+	c.err = c.w.WriteHeader()
+	return c
+}
+
+// Read reads from the underlying connection and records the data.
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.record(Received, b[:n])
+	}
+	return n, err
+}
+
+// Write records the data and writes it to the underlying connection.
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.record(Sent, b[:n])
+	}
+	return n, err
+}
+
+// Err returns the first error which occurred while writing
+// the recording.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Conn) record(dir Direction, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = c.w.WriteEntry(Entry{Time: time.Now(), Dir: dir, Data: b})
+}
@@ -0,0 +1,174 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package record records the AMS traffic of a client session to a file
+// and replays it with a fake server.
+//
+// A recording starts with an 8 byte magic string and a 2 byte format
+// version followed by a sequence of entries. Each entry consists of a
+// timestamp (int64 unix nanoseconds), the direction (1 byte), the data
+// length (uint32) and the data. All numbers are little endian.
+package record
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Magic is the file signature of a recording.
+const Magic = "TCAMSREC"
+
+// Version is the current version of the file format.
+const Version = 1
+
+// maxEntryLen limits the size of a single entry when reading
+// a recording.
+const maxEntryLen = 64 << 20
+
+// Direction describes whether data was sent or received by the client.
+type Direction byte
+
+const (
+	// Sent marks data written by the client to the server.
+	Sent Direction = 1
+
+	// Received marks data read by the client from the server.
+	Received Direction = 2
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Sent:
+		return "sent"
+	case Received:
+		return "received"
+	default:
+		return fmt.Sprintf("Direction(%d)", byte(d))
+	}
+}
+
+// Entry is a chunk of data which was sent or received
+// at a given time.
+type Entry struct {
+	Time time.Time
+	Dir  Direction
+	Data []byte
+}
+
+// Writer writes entries in the recording format.
+// The file header is written before the first entry
+// unless WriteHeader was called.
+type Writer struct {
+	w           io.Writer
+	wroteHeader bool
+}
+
+// NewWriter returns a writer which writes a recording to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteHeader writes the file header if it was not written yet.
+// A recording without entries still needs the header.
+func (w *Writer) WriteHeader() error {
+	if w.wroteHeader {
+		return nil
+	}
+	hdr := make([]byte, len(Magic)+2)
+	copy(hdr, Magic)
+	binary.LittleEndian.PutUint16(hdr[len(Magic):], Version)
+	if _, err := w.w.Write(hdr); err != nil {
+		return err
+	}
+	w.wroteHeader = true
+	return nil
+}
+
+// WriteEntry writes a single entry.
+func (w *Writer) WriteEntry(e Entry) error {
+	if err := w.WriteHeader(); err != nil {
+		return err
+	}
+
+	b := make([]byte, 13+len(e.Data))
+	binary.LittleEndian.PutUint64(b[0:8], uint64(e.Time.UnixNano()))
+	b[8] = byte(e.Dir)
+	binary.LittleEndian.PutUint32(b[9:13], uint32(len(e.Data)))
+	copy(b[13:], e.Data)
+	_, err := w.w.Write(b)
+	return err
+}
+
+// Reader reads entries from a recording.
+type Reader struct {
+	r          io.Reader
+	readHeader bool
+}
+
+// NewReader returns a reader which reads a recording from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Next returns the next entry. It returns io.EOF when
+// there are no more entries. A missing file header is
+// an error, also for an empty input.
+func (r *Reader) Next() (Entry, error) {
+	if !r.readHeader {
+		hdr := make([]byte, len(Magic)+2)
+		if _, err := io.ReadFull(r.r, hdr); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return Entry{}, errors.New("record: not a recording: missing header")
+			}
+			return Entry{}, err
+		}
+		if string(hdr[:len(Magic)]) != Magic {
+			return Entry{}, errors.New("record: not a recording")
+		}
+		if v := binary.LittleEndian.Uint16(hdr[len(Magic):]); v != Version {
+			return Entry{}, fmt.Errorf("record: unsupported version %d", v)
+		}
+		r.readHeader = true
+	}
+
+	b := make([]byte, 13)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return Entry{}, err
+	}
+	n := binary.LittleEndian.Uint32(b[9:13])
+	if n > maxEntryLen {
+		return Entry{}, fmt.Errorf("record: entry too large: %d bytes", n)
+	}
+	e := Entry{
+		Time: time.Unix(0, int64(binary.LittleEndian.Uint64(b[0:8]))),
+		Dir:  Direction(b[8]),
+		Data: make([]byte, n),
+	}
+	if _, err := io.ReadFull(r.r, e.Data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+// ReadAll reads all entries of a recording.
+func ReadAll(r io.Reader) ([]Entry, error) {
+	rr := NewReader(r)
+	var entries []Entry
+	for {
+		e, err := rr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package record
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestWriterReader(t *testing.T) {
+	entries := []Entry{
+		{Time: time.Unix(1, 2), Dir: Sent, Data: []byte{0x1, 0x2}},
+		{Time: time.Unix(3, 4), Dir: Received, Data: []byte{0x3}},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, e := range entries {
+		if err := w.WriteEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	verify.Values(t, "header", buf.Bytes()[:10], []byte("TCAMSREC\x01\x00"))
+
+	got, err := ReadAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "entries", got, entries)
+}
+
+func TestEmptyRecording(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "entries", len(got), 0)
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"short header", []byte("TCAMS")},
+		{"bad magic", []byte("TCAMSRED\x01\x00")},
+		{"bad version", []byte("TCAMSREC\x02\x00")},
+		{"short entry", []byte("TCAMSREC\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x05\x00\x00\x00\x01")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadAll(bytes.NewReader(tt.b)); err == nil {
+				t.Fatal("got nil want error")
+			}
+		})
+	}
+}
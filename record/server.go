@@ -0,0 +1,259 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package record
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// Mode controls how the replay server matches incoming requests
+// against the recording.
+type Mode int
+
+const (
+	// Strict requires that requests arrive in the recorded order
+	// and that they are identical to the recorded requests
+	// including the invoke id.
+	Strict Mode = iota
+
+	// Lenient matches requests in any order and ignores the
+	// invoke id. Responses are sent with the invoke id of the
+	// incoming request. Recorded requests can be matched
+	// more than once.
+	Lenient
+)
+
+// invokeIDOffset is the offset of the invoke id in an AMS/TCP frame.
+const invokeIDOffset = ams.HeaderLen - 4
+
+// exchange is a recorded request and the responses
+// the client received for it.
+type exchange struct {
+	req  []byte
+	resp [][]byte
+	used bool
+}
+
+// Server replays a recording. It answers requests which match
+// a recorded request with the recorded responses.
+//
+// Requests which the recorded server sent to the client and
+// the responses of the client are not replayed.
+type Server struct {
+	Mode Mode
+
+	mu        sync.Mutex
+	exchanges []*exchange
+	next      int
+	err       error
+	ln        net.Listener
+	conns     map[net.Conn]bool
+}
+
+// NewServer creates a replay server from the entries of a recording.
+func NewServer(entries []Entry, mode Mode) (*Server, error) {
+	var sent, received bytes.Buffer
+	for _, e := range entries {
+		switch e.Dir {
+		case Sent:
+			sent.Write(e.Data)
+		case Received:
+			received.Write(e.Data)
+		default:
+			return nil, fmt.Errorf("record: invalid direction %d", e.Dir)
+		}
+	}
+
+	reqs, err := splitFrames(sent.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	resps, err := splitFrames(received.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{Mode: mode}
+	byInvokeID := map[uint32]*exchange{}
+	for _, b := range reqs {
+		hdr, err := decodeHeader(b)
+		if err != nil {
+			return nil, err
+		}
+		if ams.HasState(hdr.AMSHeader, ams.StateResponse) {
+			continue
+		}
+		x := &exchange{req: b}
+		s.exchanges = append(s.exchanges, x)
+		byInvokeID[hdr.InvokeID] = x
+	}
+	for _, b := range resps {
+		hdr, err := decodeHeader(b)
+		if err != nil {
+			return nil, err
+		}
+		if !ams.HasState(hdr.AMSHeader, ams.StateResponse) {
+			continue
+		}
+		if x := byInvokeID[hdr.InvokeID]; x != nil {
+			x.resp = append(x.resp, b)
+		}
+	}
+	return s, nil
+}
+
+// Serve accepts connections on l and replays the recording
+// on each of them. Serve always returns a non-nil error.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.ln = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		if s.conns == nil {
+			s.conns = map[net.Conn]bool{}
+		}
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close closes the listener and all open connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Close()
+}
+
+// Err returns the first request which did not match the recording.
+func (s *Server) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		req, err := ams.ReadFrame(conn, maxEntryLen)
+		if err != nil {
+			return
+		}
+		resps, err := s.match(req)
+		if err != nil {
+			s.mu.Lock()
+			if s.err == nil {
+				s.err = err
+			}
+			s.mu.Unlock()
+			if s.Mode == Strict {
+				return
+			}
+			continue
+		}
+		for _, b := range resps {
+			if _, err := conn.Write(b); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// match returns the responses for the request.
+func (s *Server) match(req []byte) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Mode == Strict {
+		if s.next >= len(s.exchanges) {
+			return nil, fmt.Errorf("record: unexpected request % x", req)
+		}
+		x := s.exchanges[s.next]
+		if !bytes.Equal(x.req, req) {
+			return nil, fmt.Errorf("record: request %d: got % x want % x", s.next, req, x.req)
+		}
+		s.next++
+		x.used = true
+		return x.resp, nil
+	}
+
+	var found *exchange
+	for _, x := range s.exchanges {
+		if !equalIgnoreInvokeID(x.req, req) {
+			continue
+		}
+		if !x.used {
+			found = x
+			break
+		}
+		if found == nil {
+			found = x
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("record: no recorded request for % x", req)
+	}
+	found.used = true
+
+	// answer with the invoke id of the incoming request
+	resps := make([][]byte, len(found.resp))
+	for i, b := range found.resp {
+		resps[i] = append([]byte(nil), b...)
+		copy(resps[i][invokeIDOffset:], req[invokeIDOffset:invokeIDOffset+4])
+	}
+	return resps, nil
+}
+
+func equalIgnoreInvokeID(a, b []byte) bool {
+	if len(a) != len(b) || len(a) < ams.HeaderLen {
+		return false
+	}
+	return bytes.Equal(a[:invokeIDOffset], b[:invokeIDOffset]) &&
+		bytes.Equal(a[invokeIDOffset+4:], b[invokeIDOffset+4:])
+}
+
+func decodeHeader(b []byte) (ams.Header, error) {
+	var hdr ams.Header
+	err := hdr.Decode(ams.NewBuffer(b))
+	return hdr, err
+}
+
+// splitFrames splits a stream of AMS/TCP frames.
+func splitFrames(b []byte) ([][]byte, error) {
+	var frames [][]byte
+	r := bytes.NewReader(b)
+	for r.Len() > 0 {
+		f, err := ams.ReadFrame(r, maxEntryLen)
+		if err != nil {
+			return nil, fmt.Errorf("record: incomplete frame: %s", err)
+		}
+		frames = append(frames, f)
+	}
+	return frames, nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package record_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat"
+	"github.com/gotwincat/twincat/ams"
+	"github.com/gotwincat/twincat/record"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+var (
+	target = ams.MustParseAddr("1.2.3.4.5.6:851")
+	sender = ams.MustParseAddr("5.6.7.8.9.0:5678")
+)
+
+// serveReads answers every read request with the index offset
+// as a single byte.
+func serveReads(t *testing.T, l net.Listener) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	for {
+		b := make([]byte, 1500)
+		n, err := conn.Read(b)
+		if err != nil {
+			return
+		}
+		var req ams.ReadRequest
+		if err := req.Decode(ams.NewBuffer(b[:n])); err != nil {
+			t.Errorf("decode: %s", err)
+			return
+		}
+		resp := ams.NewReadResponse(sender, target, ams.NoError, []byte{byte(req.IndexOffset)})
+		resp.Header().InvokeID = req.Header().InvokeID
+		var buf ams.Buffer
+		if err := resp.Encode(&buf); err != nil {
+			t.Errorf("encode: %s", err)
+			return
+		}
+		if _, err := conn.Write(buf.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+func dial(t *testing.T, addr string, wrap func(net.Conn) net.Conn) *twincat.Client {
+	t.Helper()
+	c := &twincat.Client{Addr: addr, ReadTimeout: time.Second, WrapConn: wrap}
+	if err := c.Dial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func read(t *testing.T, c *twincat.Client, offset uint32) []byte {
+	t.Helper()
+	resp, err := c.Read(context.Background(), ams.NewReadRequest(target, sender, 0x4020, offset, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Data
+}
+
+func recordSession(t *testing.T) []record.Entry {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveReads(t, l)
+
+	var buf bytes.Buffer
+	var rc *record.Conn
+	c := dial(t, l.Addr().String(), func(conn net.Conn) net.Conn {
+		rc = record.NewConn(conn, &buf)
+		return rc
+	})
+	verify.Values(t, "read 1", read(t, c, 1), []byte{1})
+	verify.Values(t, "read 2", read(t, c, 2), []byte{2})
+	c.Close()
+
+	if err := rc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := record.ReadAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func replay(t *testing.T, entries []record.Entry, mode record.Mode) (*record.Server, string) {
+	t.Helper()
+	s, err := record.NewServer(entries, mode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String()
+}
+
+func TestReplayStrict(t *testing.T) {
+	entries := recordSession(t)
+
+	s, addr := replay(t, entries, record.Strict)
+	c := dial(t, addr, nil)
+	defer c.Close()
+
+	verify.Values(t, "read 1", read(t, c, 1), []byte{1})
+	verify.Values(t, "read 2", read(t, c, 2), []byte{2})
+	verify.Values(t, "err", s.Err(), nil)
+}
+
+func TestReplayStrictMismatch(t *testing.T) {
+	entries := recordSession(t)
+
+	s, addr := replay(t, entries, record.Strict)
+	c := dial(t, addr, nil)
+	defer c.Close()
+
+	if _, err := c.Read(context.Background(), ams.NewReadRequest(target, sender, 0x4020, 2, 1)); err == nil {
+		t.Fatal("got nil want error")
+	}
+	if s.Err() == nil {
+		t.Fatal("got nil want mismatch error")
+	}
+}
+
+func TestReplayLenient(t *testing.T) {
+	entries := recordSession(t)
+
+	s, addr := replay(t, entries, record.Lenient)
+	c := dial(t, addr, nil)
+	defer c.Close()
+
+	// different order and repeated requests
+	verify.Values(t, "read 2", read(t, c, 2), []byte{2})
+	verify.Values(t, "read 1", read(t, c, 1), []byte{1})
+	verify.Values(t, "read 1 again", read(t, c, 1), []byte{1})
+	verify.Values(t, "err", s.Err(), nil)
+}
@@ -0,0 +1,362 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// Errors of a *PathError.
+var (
+	ErrPathSyntax    = errors.New("invalid syntax")
+	ErrUnknownSymbol = errors.New("unknown symbol")
+	ErrUnknownMember = errors.New("unknown member")
+	ErrUnknownType   = errors.New("unknown type")
+	ErrNotStruct     = errors.New("type has no members")
+	ErrNotArray      = errors.New("type is not an array")
+	ErrNotPointer    = errors.New("type is not a pointer")
+	ErrIndexCount    = errors.New("wrong number of indexes")
+	ErrIndexRange    = errors.New("index out of range")
+	ErrDereference   = errors.New("path contains a dereference")
+)
+
+// PathError describes an invalid symbol path. Pos is the byte offset
+// of the invalid part of the path.
+type PathError struct {
+	Path   string
+	Pos    int
+	Err    error
+	Detail string
+}
+
+func (e *PathError) Error() string {
+	s := fmt.Sprintf("symbol path %q at %d: %s", e.Path, e.Pos, e.Err)
+	if e.Detail != "" {
+		s += ": " + e.Detail
+	}
+	return s
+}
+
+func (e *PathError) Unwrap() error { return e.Err }
+
+// Location is the memory location and the type of a symbol path.
+type Location struct {
+	Path        string
+	IndexGroup  uint32
+	IndexOffset uint32
+	Size        uint32
+
+	// Type is the name of the type of the value.
+	Type string
+
+	// TypeInfo is the uploaded type or nil for base types.
+	TypeInfo *TypeInfo
+}
+
+// pathElem is an element of a symbol path.
+type pathElem struct {
+	pos     int
+	name    string  // member or symbol name
+	indexes []int64 // array indexes
+	deref   bool
+}
+
+// parsePath splits a symbol path into names, array indexes and
+// dereferences.
+func parsePath(path string) ([]pathElem, error) {
+	var elems []pathElem
+	syntax := func(pos int, detail string) error {
+		return &PathError{Path: path, Pos: pos, Err: ErrPathSyntax, Detail: detail}
+	}
+	isIdent := func(c byte) bool {
+		return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+	}
+
+	for i := 0; i < len(path); {
+		switch c := path[i]; {
+		case c == '.':
+			if i == 0 || i+1 == len(path) || !isIdent(path[i+1]) {
+				return nil, syntax(i, "expected name after '.'")
+			}
+			i++
+		case isIdent(c):
+			if len(elems) > 0 && (path[i-1] != '.') {
+				return nil, syntax(i, "expected '.' before name")
+			}
+			start := i
+			for i < len(path) && isIdent(path[i]) {
+				i++
+			}
+			elems = append(elems, pathElem{pos: start, name: path[start:i]})
+		case c == '[':
+			if len(elems) == 0 {
+				return nil, syntax(i, "index without symbol")
+			}
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, syntax(i, "missing ']'")
+			}
+			e := pathElem{pos: i}
+			for _, s := range strings.Split(path[i+1:i+end], ",") {
+				n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 32)
+				if err != nil {
+					return nil, syntax(i, fmt.Sprintf("invalid index %q", strings.TrimSpace(s)))
+				}
+				e.indexes = append(e.indexes, n)
+			}
+			elems = append(elems, e)
+			i += end + 1
+		case c == '^':
+			if len(elems) == 0 {
+				return nil, syntax(i, "dereference without symbol")
+			}
+			elems = append(elems, pathElem{pos: i, deref: true})
+			i++
+		default:
+			return nil, syntax(i, fmt.Sprintf("unexpected %q", c))
+		}
+	}
+	if len(elems) == 0 {
+		return nil, syntax(0, "empty path")
+	}
+	return elems, nil
+}
+
+// Resolve returns the index group, offset, size and type of a symbol
+// path like MAIN.arr[1,2].member without acquiring a handle. Array
+// indexes are checked against the bounds of the array. Paths which
+// dereference a pointer or a reference are not at a fixed location
+// and return ErrDereference. Use Client.ResolveSymbol for them.
+func (t *SymbolTable) Resolve(path string) (*Location, error) {
+	loc, deref, err := t.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	if deref >= 0 {
+		return nil, &PathError{Path: path, Pos: deref, Err: ErrDereference}
+	}
+	return loc, nil
+}
+
+// resolve resolves a path and returns the position of the first
+// dereference or -1. The location of a dereferenced path only
+// contains the size and the type.
+func (t *SymbolTable) resolve(path string) (*Location, int, error) {
+	elems, err := parsePath(path)
+	if err != nil {
+		return nil, -1, err
+	}
+	fail := func(pos int, err error, format string, args ...interface{}) (*Location, int, error) {
+		return nil, -1, &PathError{Path: path, Pos: pos, Err: err, Detail: fmt.Sprintf(format, args...)}
+	}
+
+	// the longest sequence of names which is a symbol
+	var sym *SymbolInfo
+	n := 0
+	for n < len(elems) && elems[n].name != "" {
+		n++
+	}
+	for ; n > 0; n-- {
+		var names []string
+		for _, e := range elems[:n] {
+			names = append(names, e.name)
+		}
+		if sym = t.Symbol(strings.Join(names, ".")); sym != nil {
+			break
+		}
+	}
+	if sym == nil {
+		return fail(0, ErrUnknownSymbol, "%s", path)
+	}
+
+	loc := &Location{
+		IndexGroup:  sym.IndexGroup,
+		IndexOffset: sym.IndexOffset,
+		Size:        sym.Size,
+		Type:        sym.Type,
+	}
+	deref := -1
+	for _, e := range elems[n:] {
+		// references are dereferenced implicitly
+		if target, ok := cutPrefix(loc.Type, "REFERENCE TO "); ok && !e.deref {
+			if deref < 0 {
+				deref = e.pos
+			}
+			loc.Type = target
+			size, ok := t.typeSize(target)
+			if !ok {
+				return fail(e.pos, ErrUnknownType, "%s", target)
+			}
+			loc.Size = size
+		}
+
+		switch {
+		case e.deref:
+			target, ok := cutPrefix(loc.Type, "POINTER TO ")
+			if !ok {
+				return fail(e.pos, ErrNotPointer, "%s", loc.Type)
+			}
+			size, ok := t.typeSize(target)
+			if !ok {
+				return fail(e.pos, ErrUnknownType, "%s", target)
+			}
+			if deref < 0 {
+				deref = e.pos
+			}
+			loc.Type, loc.Size = target, size
+
+		case e.name != "":
+			typ := t.Type(loc.Type)
+			if typ == nil || len(typ.Members) == 0 {
+				return fail(e.pos, ErrNotStruct, "%s", loc.Type)
+			}
+			m := typ.member(e.name)
+			if m == nil {
+				return fail(e.pos, ErrUnknownMember, "%s has no member %s", loc.Type, e.name)
+			}
+			loc.IndexOffset += m.Offset
+			loc.Size = m.Size
+			loc.Type = m.Type
+
+		default:
+			dims, elemType, ok := t.arrayType(loc.Type)
+			if !ok {
+				return fail(e.pos, ErrNotArray, "%s", loc.Type)
+			}
+			if len(e.indexes) != len(dims) {
+				return fail(e.pos, ErrIndexCount, "got %d want %d", len(e.indexes), len(dims))
+			}
+			var index, count uint64 = 0, 1
+			for i, d := range dims {
+				lower, upper := int64(d.LowerBound), int64(d.LowerBound)+int64(d.Elements)-1
+				if x := e.indexes[i]; x < lower || x > upper {
+					return fail(e.pos, ErrIndexRange, "%d not in [%d..%d]", x, lower, upper)
+				}
+				index = index*uint64(d.Elements) + uint64(e.indexes[i]-lower)
+				count *= uint64(d.Elements)
+			}
+			elemSize := uint64(loc.Size) / count
+			if size, ok := t.typeSize(elemType); ok {
+				elemSize = uint64(size)
+			}
+			loc.IndexOffset += uint32(index * elemSize)
+			loc.Size = uint32(elemSize)
+			loc.Type = elemType
+		}
+	}
+
+	loc.Path = path
+	loc.TypeInfo = t.Type(loc.Type)
+	if deref >= 0 {
+		loc.IndexGroup, loc.IndexOffset = 0, 0
+	}
+	return loc, deref, nil
+}
+
+func cutPrefix(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return strings.TrimSpace(s[len(prefix):]), true
+}
+
+// arrayType returns the dimensions and the element type of an array
+// type. Array types which are not uploaded are parsed from their
+// name like ARRAY [0..9, 1..2] OF INT.
+func (t *SymbolTable) arrayType(name string) ([]ArrayDim, string, bool) {
+	if typ := t.Type(name); typ != nil && len(typ.ArrayDims) > 0 {
+		return typ.ArrayDims, typ.Type, true
+	}
+	rest, ok := cutPrefix(name, "ARRAY")
+	if !ok || !strings.HasPrefix(rest, "[") {
+		return nil, "", false
+	}
+	end := strings.IndexByte(rest, ']')
+	if end < 0 {
+		return nil, "", false
+	}
+	elemType, ok := cutPrefix(strings.TrimSpace(rest[end+1:]), "OF ")
+	if !ok {
+		return nil, "", false
+	}
+	var dims []ArrayDim
+	for _, r := range strings.Split(rest[1:end], ",") {
+		bounds := strings.SplitN(strings.TrimSpace(r), "..", 2)
+		if len(bounds) != 2 {
+			return nil, "", false
+		}
+		lower, err1 := strconv.ParseInt(strings.TrimSpace(bounds[0]), 10, 32)
+		upper, err2 := strconv.ParseInt(strings.TrimSpace(bounds[1]), 10, 32)
+		if err1 != nil || err2 != nil || upper < lower {
+			return nil, "", false
+		}
+		dims = append(dims, ArrayDim{LowerBound: int32(lower), Elements: uint32(upper - lower + 1)})
+	}
+	return dims, elemType, true
+}
+
+// baseTypeSizes contains the sizes of the IEC base types.
+var baseTypeSizes = map[string]uint32{
+	"bool": 1, "byte": 1, "sint": 1, "usint": 1,
+	"word": 2, "int": 2, "uint": 2,
+	"dword": 4, "dint": 4, "udint": 4, "real": 4,
+	"time": 4, "tod": 4, "time_of_day": 4, "date": 4, "dt": 4, "date_and_time": 4,
+	"lword": 8, "lint": 8, "ulint": 8, "lreal": 8, "ltime": 8,
+}
+
+// typeSize returns the size of a type in bytes.
+func (t *SymbolTable) typeSize(name string) (uint32, bool) {
+	if typ := t.Type(name); typ != nil {
+		return typ.Size, true
+	}
+	key := typeKey(name)
+	if n, ok := baseTypeSizes[key]; ok {
+		return n, true
+	}
+	for prefix, width := range map[string]uint32{"string": 1, "wstring": 2} {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		length := uint64(defaultStringLen)
+		if s := key[len(prefix):]; s != "" {
+			if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+				continue
+			}
+			n, err := strconv.ParseUint(s[1:len(s)-1], 10, 32)
+			if err != nil {
+				return 0, false
+			}
+			length = n
+		}
+		return uint32(length+1) * width, true
+	}
+	return 0, false
+}
+
+// ResolveSymbol resolves a symbol path like SymbolTable.Resolve. A
+// path with a dereference is resolved with a handle for the path. Its
+// location is the handle in the index group
+// ams.IdxReadWriteSymValueByHandle.
+func (c *Client) ResolveSymbol(ctx context.Context, target, sender ams.Addr, t *SymbolTable, path string) (*Location, error) {
+	loc, deref, err := t.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	if deref < 0 {
+		return loc, nil
+	}
+	h, err := c.symbolHandle(ctx, target, sender, path)
+	if err != nil {
+		return nil, err
+	}
+	loc.IndexGroup, loc.IndexOffset = ams.IdxReadWriteSymValueByHandle, h
+	return loc, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func testSymbolTable() *SymbolTable {
+	return NewSymbolTable(testSymbols())
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		path          string
+		group, offset uint32
+		size          uint32
+		typ           string
+		hasTypeInfo   bool
+	}{
+		{"MAIN.nValue", 0x4020, 0, 2, "INT", false},
+		{"main.NVALUE", 0x4020, 0, 2, "INT", false},
+		{"MAIN.arr", 0x4020, 100, 80, "ARRAY [1..10] OF ST_Point", true},
+		{"MAIN.arr[1]", 0x4020, 100, 8, "ST_Point", true},
+		{"MAIN.arr[10].y", 0x4020, 176, 4, "DINT", false},
+		{"MAIN.grid[-1,0]", 0x4020, 200, 2, "INT", false},
+		{"MAIN.grid[0, 2]", 0x4020, 212, 2, "INT", false},
+		{"MAIN.grid[1,3]", 0x4020, 222, 2, "INT", false},
+		{"MAIN.fb.aSpeeds[1]", 0x4020, 416, 8, "LREAL", false},
+		{"GVL.sName", 0x4040, 0, 21, "STRING(20)", false},
+	}
+	table := testSymbolTable()
+	for _, tt := range tests {
+		loc, err := table.Resolve(tt.path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+			continue
+		}
+		verify.Values(t, tt.path, []interface{}{loc.IndexGroup, loc.IndexOffset, loc.Size, loc.Type, loc.TypeInfo != nil},
+			[]interface{}{tt.group, tt.offset, tt.size, tt.typ, tt.hasTypeInfo})
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		path string
+		err  error
+		msg  string
+	}{
+		{"", ErrPathSyntax, `symbol path "" at 0: invalid syntax: empty path`},
+		{"MAIN.arr[1", ErrPathSyntax, `symbol path "MAIN.arr[1" at 8: invalid syntax: missing ']'`},
+		{"MAIN.arr[x]", ErrPathSyntax, `symbol path "MAIN.arr[x]" at 8: invalid syntax: invalid index "x"`},
+		{"MAIN.arr[1]x", ErrPathSyntax, `symbol path "MAIN.arr[1]x" at 11: invalid syntax: expected '.' before name`},
+		{"MAIN.nValue.", ErrPathSyntax, `symbol path "MAIN.nValue." at 11: invalid syntax: expected name after '.'`},
+		{"MAIN.missing", ErrUnknownSymbol, `symbol path "MAIN.missing" at 0: unknown symbol: MAIN.missing`},
+		{"MAIN.arr[1].z", ErrUnknownMember, `symbol path "MAIN.arr[1].z" at 12: unknown member: ST_Point has no member z`},
+		{"MAIN.nValue.x", ErrNotStruct, `symbol path "MAIN.nValue.x" at 12: type has no members: INT`},
+		{"MAIN.nValue[0]", ErrNotArray, `symbol path "MAIN.nValue[0]" at 11: type is not an array: INT`},
+		{"MAIN.arr[0]", ErrIndexRange, `symbol path "MAIN.arr[0]" at 8: index out of range: 0 not in [1..10]`},
+		{"MAIN.grid[2,0]", ErrIndexRange, `symbol path "MAIN.grid[2,0]" at 9: index out of range: 2 not in [-1..1]`},
+		{"MAIN.grid[0]", ErrIndexCount, `symbol path "MAIN.grid[0]" at 9: wrong number of indexes: got 1 want 2`},
+		{"MAIN.nValue^", ErrNotPointer, `symbol path "MAIN.nValue^" at 11: type is not a pointer: INT`},
+		{"MAIN.pPoint^.x", ErrDereference, `symbol path "MAIN.pPoint^.x" at 11: path contains a dereference`},
+		{"MAIN.refPoint.x", ErrDereference, `symbol path "MAIN.refPoint.x" at 14: path contains a dereference`},
+	}
+	table := testSymbolTable()
+	for _, tt := range tests {
+		_, err := table.Resolve(tt.path)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v want %v", tt.path, err, tt.err)
+			continue
+		}
+		verify.Values(t, tt.path, err.Error(), tt.msg)
+	}
+}
+
+func TestResolveSymbolDereference(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+	table := testSymbolTable()
+
+	loc, err := c.ResolveSymbol(context.Background(), testTarget, testSender, table, "MAIN.pPoint^.y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, _ := s.symbolHandle("MAIN.pPoint^.y")
+	verify.Values(t, "location", loc, &Location{
+		Path:        "MAIN.pPoint^.y",
+		IndexGroup:  ams.IdxReadWriteSymValueByHandle,
+		IndexOffset: h,
+		Size:        4,
+		Type:        "DINT",
+	})
+
+	// static paths need no handle
+	if _, err := c.ResolveSymbol(context.Background(), testTarget, testSender, table, "MAIN.arr[2].x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.symbolHandle("MAIN.arr[2].x"); ok {
+		t.Fatal("handle for static path")
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// RetryPolicy retries requests which failed with a transient error
+// with an exponential backoff.
+//
+// Read, ReadState and ReadDeviceInfo requests are retried by default.
+// Write and ReadWrite requests are only retried if Writes is set or
+// if the context of the request is marked with Idempotent since a
+// retried write can be applied twice, e.g. to a counter.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of a request
+	// including the first one. If zero, 3 is used.
+	MaxAttempts int
+
+	// InitialBackoff is the wait time before the first retry. It
+	// doubles with every further retry. If zero, 50ms is used.
+	InitialBackoff time.Duration
+
+	// MaxBackoff limits the wait time between two attempts. If zero,
+	// 1s is used.
+	MaxBackoff time.Duration
+
+	// Writes enables retries for all Write, ReadWrite and
+	// WriteControl requests.
+	Writes bool
+
+	// Transient reports whether an ADS return code is transient. If
+	// nil, IsTransient is used.
+	Transient func(code uint32) bool
+}
+
+// IsTransient reports whether an ADS return code is caused by a
+// temporary condition of the target, e.g. a restarting PLC, so that
+// the request can succeed later. All other codes are permanent.
+func IsTransient(code uint32) bool {
+	switch code {
+	case ams.MailboxFull,
+		ams.TargetPortNotFound,
+		ams.TargetMachineNotFound,
+		ams.PortNotConnected,
+		ams.PortDisabled,
+		ams.SyncTimeout,
+		ams.NoMemory,
+		ams.TCPSendError,
+		ams.HostUnreachable,
+		ams.RouterMailboxFull,
+		ams.RouterNotInitialized,
+		ams.RouterNotRegistered,
+		ams.RouterNotActivated,
+		ams.DeviceNotReady,
+		ams.DeviceBusy,
+		ams.DeviceNoMemory,
+		ams.DeviceInvalidState,
+		ams.DeviceNotInitialized,
+		ams.DeviceTimeout,
+		ams.DevicePending,
+		ams.DeviceAborted,
+		ams.ClientSyncTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// ADSError is an error response of the target.
+type ADSError struct {
+	Code uint32
+}
+
+func (e *ADSError) Error() string {
+	return fmt.Sprintf("ads error 0x%x (%s)", e.Code, ams.ErrorName(e.Code))
+}
+
+// Attempt describes a failed attempt of a request.
+type Attempt struct {
+	Start   time.Time
+	Latency time.Duration
+	Err     error // *ADSError for error responses
+}
+
+// RetryError is returned when a request failed with a transient
+// error and the retry policy has given up.
+type RetryError struct {
+	// Attempts contains all attempts of the request in order.
+	Attempts []Attempt
+
+	// Err is the error of the last attempt or the error of the
+	// context if it ended during the backoff.
+	Err error
+}
+
+func (e *RetryError) Error() string {
+	var errs []string
+	for _, a := range e.Attempts {
+		errs = append(errs, a.Err.Error())
+	}
+	attempts := "attempts"
+	if len(e.Attempts) == 1 {
+		attempts = "attempt"
+	}
+	s := fmt.Sprintf("request failed after %d %s: %s", len(e.Attempts), attempts, strings.Join(errs, "; "))
+	if len(e.Attempts) == 0 || e.Attempts[len(e.Attempts)-1].Err != e.Err {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+func (e *RetryError) Unwrap() error { return e.Err }
+
+type idempotentKey struct{}
+
+// Idempotent marks the requests sent with ctx as safe to retry, e.g.
+// a Write of a set point.
+func Idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// retryable reports whether the policy allows to retry req.
+func (p *RetryPolicy) retryable(ctx context.Context, req ams.Packet) bool {
+	switch req.Header().CmdID {
+	case ams.CmdADSRead, ams.CmdADSReadState, ams.CmdADSReadDeviceInfo:
+		return true
+	}
+	return p.Writes || ctx.Value(idempotentKey{}) != nil
+}
+
+// transient returns the error of a failed attempt and whether it is
+// transient. An error response is returned as *ADSError.
+func (p *RetryPolicy) transient(r ams.Response, err error) (error, bool) {
+	if err != nil {
+		var nerr net.Error
+		return err, err == ErrTimeout || errors.As(err, &nerr) && nerr.Timeout()
+	}
+	code := r.Header().ErrorCode
+	if code == ams.NoError {
+		code = adsResult(r)
+	}
+	if code == ams.NoError {
+		return nil, false
+	}
+	f := p.Transient
+	if f == nil {
+		f = IsTransient
+	}
+	return &ADSError{Code: code}, f(code)
+}
+
+// backoff returns the wait time before the retry after attempt n.
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	d, max := p.InitialBackoff, p.MaxBackoff
+	if d <= 0 {
+		d = 50 * time.Millisecond
+	}
+	if max <= 0 {
+		max = time.Second
+	}
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// retry returns an invoker which calls invoke until the request
+// succeeds, fails permanently or the policy gives up.
+func (c *Client) retry(p *RetryPolicy, invoke Invoker) Invoker {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	return func(ctx context.Context, req ams.Packet) (ams.Response, error) {
+		if !p.retryable(ctx, req) {
+			return invoke(ctx, req)
+		}
+		var attempts []Attempt
+		for n := 1; ; n++ {
+			start := time.Now()
+			r, err := invoke(ctx, req)
+			aerr, transient := p.transient(r, err)
+			if !transient || ctx.Err() != nil {
+				if len(attempts) > 0 && aerr != nil {
+					attempts = append(attempts, Attempt{start, time.Since(start), aerr})
+					return nil, &RetryError{Attempts: attempts, Err: aerr}
+				}
+				return r, err
+			}
+			attempts = append(attempts, Attempt{start, time.Since(start), aerr})
+			if n == maxAttempts {
+				return nil, &RetryError{Attempts: attempts, Err: aerr}
+			}
+
+			// give up early if the backoff ends after the deadline
+			d := p.backoff(n)
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+				return nil, &RetryError{Attempts: attempts, Err: aerr}
+			}
+			c.log(ctx, LevelDebug, "retrying request", append(logArgs(req.Header(), nil), "attempt", n, "backoff", d, "err", aerr)...)
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return nil, &RetryError{Attempts: attempts, Err: ctx.Err()}
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// failing returns a server handler which responds with the ADS error
+// code to the first n requests and counts all requests.
+func failing(s *testServer, code uint32, n int32, count *int32) func(ams.Packet) ams.Packet {
+	return func(req ams.Packet) ams.Packet {
+		if atomic.AddInt32(count, 1) > n {
+			return s.respond(req)
+		}
+		hdr := req.Header()
+		switch req.(type) {
+		case *ams.ReadRequest:
+			return ams.NewReadResponse(hdr.Sender, hdr.Target, code, nil)
+		case *ams.WriteRequest:
+			return ams.NewWriteResponse(hdr.Sender, hdr.Target, code)
+		default:
+			return s.respond(req)
+		}
+	}
+}
+
+var fastRetry = &RetryPolicy{InitialBackoff: time.Millisecond}
+
+func TestRetryBackoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	var got []time.Duration
+	for n := 1; n <= 4; n++ {
+		got = append(got, p.backoff(n))
+	}
+	verify.Values(t, "backoff", got, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond})
+}
+
+func TestRetryRead(t *testing.T) {
+	var count int32
+	s := newTestServer(t)
+	s.setHandler(failing(s, ams.DeviceBusy, 2, &count))
+	c := s.dial(t, func(c *Client) { c.Retry = fastRetry })
+
+	r, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "result", r.Result, uint32(ams.NoError))
+	verify.Values(t, "requests", atomic.LoadInt32(&count), int32(3))
+}
+
+func TestRetryExhausted(t *testing.T) {
+	var count int32
+	s := newTestServer(t)
+	s.setHandler(failing(s, ams.TargetPortNotFound, 10, &count))
+	c := s.dial(t, func(c *Client) { c.Retry = fastRetry })
+
+	_, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1))
+	var rerr *RetryError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("got %v want *RetryError", err)
+	}
+	verify.Values(t, "attempts", len(rerr.Attempts), 3)
+	var aerr *ADSError
+	if !errors.As(err, &aerr) || aerr.Code != ams.TargetPortNotFound {
+		t.Fatalf("got %v want TargetPortNotFound", err)
+	}
+	verify.Values(t, "requests", atomic.LoadInt32(&count), int32(3))
+}
+
+func TestRetryPermanent(t *testing.T) {
+	var count int32
+	s := newTestServer(t)
+	s.setHandler(failing(s, ams.DeviceSymbolNotFound, 10, &count))
+	c := s.dial(t, func(c *Client) { c.Retry = fastRetry })
+
+	r, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "result", r.Result, uint32(ams.DeviceSymbolNotFound))
+	verify.Values(t, "requests", atomic.LoadInt32(&count), int32(1))
+}
+
+func TestRetryWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		writes   bool
+		ctx      context.Context
+		requests int32
+	}{
+		{"not retried", false, context.Background(), 1},
+		{"idempotent", false, Idempotent(context.Background()), 2},
+		{"all writes", true, context.Background(), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var count int32
+			s := newTestServer(t)
+			s.setHandler(failing(s, ams.DeviceBusy, 1, &count))
+			c := s.dial(t, func(c *Client) {
+				c.Retry = &RetryPolicy{InitialBackoff: time.Millisecond, Writes: tt.writes}
+			})
+
+			if _, err := c.Write(tt.ctx, ams.NewWriteRequest(testTarget, testSender, 0x4020, 0, []byte{1})); err != nil {
+				t.Fatal(err)
+			}
+			verify.Values(t, "requests", atomic.LoadInt32(&count), tt.requests)
+		})
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	var count int32
+	s := newTestServer(t)
+	s.setHandler(func(ams.Packet) ams.Packet {
+		atomic.AddInt32(&count, 1)
+		return nil
+	})
+	c := s.dial(t, func(c *Client) {
+		c.ReadTimeout = 10 * time.Millisecond
+		c.Retry = &RetryPolicy{InitialBackoff: 200 * time.Millisecond}
+	})
+
+	// the backoff ends after the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.Read(ctx, ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1))
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v want %v", err, ErrTimeout)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Fatalf("request took %v", d)
+	}
+	verify.Values(t, "error", err.Error(), "request failed after 1 attempt: timeout")
+	verify.Values(t, "requests", atomic.LoadInt32(&count), int32(1))
+}
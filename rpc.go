@@ -0,0 +1,152 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// ErrUnknownMethod is returned by CallMethod for a method which is not
+// in the uploaded data type of the function block.
+var ErrUnknownMethod = errors.New("unknown method")
+
+// MethodResult contains the decoded return value and output
+// parameters of a method call. See SymbolTable.Decode for the types of
+// the values.
+type MethodResult struct {
+	// Return is the return value or nil for methods without a
+	// return value.
+	Return interface{}
+
+	// Outputs contains the VAR_OUTPUT and VAR_IN_OUT parameters by
+	// name.
+	Outputs map[string]interface{}
+}
+
+// CallMethod calls a method of a function block instance like
+// MAIN.fbMotor. The method must have the attribute 'TcRpcEnable' so
+// that its description is part of the data type upload. args contains
+// the VAR_INPUT and VAR_IN_OUT parameters by name and is encoded like
+// SymbolTable.Encode. Every input parameter is required.
+//
+// The inputs are sent with a ReadWrite request to the handle of
+// path#method. The response contains the return value followed by the
+// output parameters.
+func (c *Client) CallMethod(ctx context.Context, target, sender ams.Addr, t *SymbolTable, path, method string, args map[string]interface{}) (*MethodResult, error) {
+	m, err := t.method(path, method)
+	if err != nil {
+		return nil, err
+	}
+	name := path + "#" + m.Name
+	fail := func(err error) (*MethodResult, error) {
+		return nil, fmt.Errorf("failed to call %s: %w", name, err)
+	}
+
+	in, err := t.methodInputs(m, args)
+	if err != nil {
+		return fail(err)
+	}
+	n := m.ReturnSize
+	for _, p := range m.Params {
+		if p.IsOutput() {
+			n += p.Size
+		}
+	}
+
+	h, err := c.symbolHandle(ctx, target, sender, name)
+	if err != nil {
+		return fail(err)
+	}
+	r, err := c.ReadWrite(ctx, ams.NewReadWriteRequest(target, sender, ams.IdxReadWriteSymValueByHandle, h, n, in))
+	if err != nil {
+		return fail(err)
+	}
+	if r.Result != ams.NoError {
+		c.staleHandle(target, h, r.Result)
+		return fail(&ADSError{Code: r.Result})
+	}
+	if uint32(len(r.Data)) < n {
+		return fail(fmt.Errorf("got %d bytes want %d", len(r.Data), n))
+	}
+
+	res := &MethodResult{Outputs: map[string]interface{}{}}
+	b := r.Data
+	if m.ReturnSize > 0 {
+		if res.Return, err = t.Decode(m.ReturnType, b[:m.ReturnSize]); err != nil {
+			return fail(err)
+		}
+		b = b[m.ReturnSize:]
+	}
+	for _, p := range m.Params {
+		if !p.IsOutput() {
+			continue
+		}
+		if res.Outputs[p.Name], err = t.Decode(p.Type, b[:p.Size]); err != nil {
+			return fail(err)
+		}
+		b = b[p.Size:]
+	}
+	return res, nil
+}
+
+// method returns the method of the function block at path.
+func (t *SymbolTable) method(path, name string) (*MethodInfo, error) {
+	loc, _, err := t.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	if loc.TypeInfo != nil {
+		if m := loc.TypeInfo.Method(name); m != nil {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("%s of %s (%s): %w", name, path, loc.Type, ErrUnknownMethod)
+}
+
+// methodInputs encodes the input parameters of a method in the order
+// of their declaration.
+func (t *SymbolTable) methodInputs(m *MethodInfo, args map[string]interface{}) ([]byte, error) {
+	used := map[string]bool{}
+	var in []byte
+	for _, p := range m.Params {
+		if !p.IsInput() {
+			continue
+		}
+		var v interface{}
+		key := ""
+		for k, x := range args {
+			if !strings.EqualFold(k, p.Name) {
+				continue
+			}
+			if key != "" {
+				return nil, fmt.Errorf("parameter %s given more than once", p.Name)
+			}
+			v, key = x, k
+			used[k] = true
+		}
+		if key == "" {
+			return nil, fmt.Errorf("missing parameter %s", p.Name)
+		}
+		b, err := t.Encode(p.Type, v)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", p.Name, err)
+		}
+		if uint32(len(b)) != p.Size {
+			return nil, fmt.Errorf("parameter %s: got %d bytes want %d", p.Name, len(b), p.Size)
+		}
+		in = append(in, b...)
+	}
+	for k := range args {
+		if !used[k] {
+			return nil, fmt.Errorf("unknown parameter %s", k)
+		}
+	}
+	return in, nil
+}
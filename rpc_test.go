@@ -0,0 +1,80 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestCallMethod(t *testing.T) {
+	s := newTestServer(t)
+	var in []byte
+	s.setHandler(func(req ams.Packet) ams.Packet {
+		r, ok := req.(*ams.ReadWriteRequest)
+		if !ok || r.IndexGroup != ams.IdxReadWriteSymValueByHandle {
+			return s.respond(req)
+		}
+		if h, _ := s.symbolHandle("MAIN.fb#Start"); r.IndexOffset != h {
+			return ams.NewReadWriteResponse(r.Header().Sender, r.Header().Target, ams.DeviceSymbolNotFound, nil)
+		}
+		in = r.Data
+		// returns TRUE and eState Fault
+		return ams.NewReadWriteResponse(r.Header().Sender, r.Header().Target, ams.NoError, []byte{1, 0xFF, 0xFF})
+	})
+	c := s.dial(t)
+	table := testSymbolTable()
+	ctx := context.Background()
+
+	res, err := c.CallMethod(ctx, testTarget, testSender, table, "MAIN.fb", "start", map[string]interface{}{"fSpeed": 1.5, "NRAMP": 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "result", res, &MethodResult{Return: true, Outputs: map[string]interface{}{"eState": "Fault"}})
+	want := make([]byte, 10)
+	binary.LittleEndian.PutUint64(want, math.Float64bits(1.5))
+	binary.LittleEndian.PutUint16(want[8:], 300)
+	verify.Values(t, "inputs", in, want)
+}
+
+func TestCallMethodErrors(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+	table := testSymbolTable()
+	ctx := context.Background()
+
+	_, err := c.CallMethod(ctx, testTarget, testSender, table, "MAIN.fb", "Stop", nil)
+	if !errors.Is(err, ErrUnknownMethod) {
+		t.Errorf("got %v want ErrUnknownMethod", err)
+	}
+	_, err = c.CallMethod(ctx, testTarget, testSender, table, "MAIN.nValue", "Start", nil)
+	if !errors.Is(err, ErrUnknownMethod) {
+		t.Errorf("got %v want ErrUnknownMethod", err)
+	}
+
+	tests := []struct {
+		args map[string]interface{}
+		err  string
+	}{
+		{map[string]interface{}{"fSpeed": 1.0}, "failed to call MAIN.fb#Start: missing parameter nRamp"},
+		{map[string]interface{}{"fSpeed": 1.0, "nRamp": 1, "eState": 0}, "failed to call MAIN.fb#Start: unknown parameter eState"},
+		{map[string]interface{}{"fSpeed": 1.0, "FSPEED": 2.0, "nRamp": 1}, "failed to call MAIN.fb#Start: parameter fSpeed given more than once"},
+		{map[string]interface{}{"fSpeed": 1.0, "nRamp": -1}, "failed to call MAIN.fb#Start: parameter nRamp: UINT value: -1 is not an unsigned 16 bit integer"},
+	}
+	for _, tt := range tests {
+		_, err := c.CallMethod(ctx, testTarget, testSender, table, "MAIN.fb", "Start", tt.args)
+		if err == nil {
+			t.Errorf("%v: no error", tt.args)
+			continue
+		}
+		verify.Values(t, "err", err.Error(), tt.err)
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// SecureADSPort is the TCP port of the Secure ADS service.
+const SecureADSPort = 8016
+
+// SecureADS is a Dialer for an encrypted connection to the Secure
+// ADS port of a TwinCAT router.
+//
+// https://infosys.beckhoff.com/english.php?content=../content/1033/tc3_grundlagen/6798121739.html&id=
+type SecureADS struct {
+	// Config is the TLS configuration. Client certificates and custom
+	// CA pools are configured here. If nil, a default configuration
+	// is used. The configuration is not modified.
+	Config *tls.Config
+
+	// Fingerprints are the hex encoded SHA-256 fingerprints of the
+	// accepted server certificates. Colons between the bytes are
+	// ignored. If set, the server certificate is accepted if it
+	// matches one of the fingerprints instead of verifying the
+	// certificate chain. This allows self-signed certificates.
+	Fingerprints []string
+
+	// NetID is the AMS NetID of the client which is announced
+	// to the server during the connect handshake.
+	NetID []byte
+
+	// Hostname is announced to the server during the connect
+	// handshake. It is truncated to 31 bytes.
+	Hostname string
+
+	// User and Password authenticate the client when the router
+	// uses self-signed certificates.
+	User     string
+	Password string
+
+	// Transport dials the connection which is encrypted.
+	// If nil, a TCPDialer is used.
+	Transport Dialer
+
+	// HandshakeTimeout limits the duration of the TLS and the
+	// connect handshake. If zero, there is no limit besides the
+	// context deadline.
+	HandshakeTimeout time.Duration
+}
+
+// Flags of the Secure ADS connect info.
+const (
+	secureFlagResponse   = 0x0001
+	secureFlagAmsAllowed = 0x0002
+	secureFlagSelfSigned = 0x0010
+	secureFlagIgnoreCn   = 0x0040
+)
+
+// Error codes of the Secure ADS connect info.
+const (
+	SecureErrorNone        = 0
+	SecureErrorVersion     = 1
+	SecureErrorCNMismatch  = 2
+	SecureErrorUnknownCert = 3
+	SecureErrorUnknownUser = 4
+)
+
+// SecureADSError is returned when the server rejects the
+// Secure ADS connect handshake.
+type SecureADSError struct {
+	Code uint8
+}
+
+func (e *SecureADSError) Error() string {
+	switch e.Code {
+	case SecureErrorVersion:
+		return "secure ads: unsupported version"
+	case SecureErrorCNMismatch:
+		return "secure ads: common name mismatch"
+	case SecureErrorUnknownCert:
+		return "secure ads: unknown certificate"
+	case SecureErrorUnknownUser:
+		return "secure ads: unknown user"
+	default:
+		return fmt.Sprintf("secure ads: error %d", e.Code)
+	}
+}
+
+// ErrSecureADSNotAllowed is returned when the server accepts the
+// connection but does not allow AMS traffic.
+var ErrSecureADSNotAllowed = errors.New("secure ads: ams not allowed")
+
+const (
+	secureVersion     = 1
+	secureInfoLen     = 64
+	secureHostnameLen = 32
+)
+
+// secureConnectInfo is the block which is exchanged after the TLS
+// handshake. It has the following layout (little endian):
+//
+//	0  uint16    total length including credentials
+//	2  uint16    flags
+//	4  uint8     version
+//	5  uint8     error
+//	6  [6]byte   AMS NetID
+//	12 [20]byte  reserved
+//	32 [32]byte  hostname, zero terminated
+//	64           optional credentials:
+//	             uint8 user length, uint8 password length,
+//	             user, password
+type secureConnectInfo struct {
+	Flags    uint16
+	Version  uint8
+	Error    uint8
+	NetID    []byte
+	Hostname string
+	User     string
+	Password string
+}
+
+func (ci *secureConnectInfo) encode() ([]byte, error) {
+	if len(ci.User) > 255 || len(ci.Password) > 255 {
+		return nil, errors.New("secure ads: user or password too long")
+	}
+	n := secureInfoLen
+	if ci.User != "" || ci.Password != "" {
+		n += 2 + len(ci.User) + len(ci.Password)
+	}
+	b := make([]byte, n)
+	binary.LittleEndian.PutUint16(b[0:2], uint16(n))
+	binary.LittleEndian.PutUint16(b[2:4], ci.Flags)
+	b[4] = ci.Version
+	b[5] = ci.Error
+	copy(b[6:12], ci.NetID)
+	copy(b[32:32+secureHostnameLen-1], ci.Hostname)
+	if n > secureInfoLen {
+		b[64] = uint8(len(ci.User))
+		b[65] = uint8(len(ci.Password))
+		copy(b[66:], ci.User)
+		copy(b[66+len(ci.User):], ci.Password)
+	}
+	return b, nil
+}
+
+// readSecureConnectInfo reads a connect info block from r.
+func readSecureConnectInfo(r io.Reader) (*secureConnectInfo, error) {
+	b := make([]byte, secureInfoLen)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	n := int(binary.LittleEndian.Uint16(b[0:2]))
+	if n < secureInfoLen {
+		return nil, fmt.Errorf("secure ads: invalid connect info length %d", n)
+	}
+	if n > secureInfoLen {
+		creds := make([]byte, n-secureInfoLen)
+		if _, err := io.ReadFull(r, creds); err != nil {
+			return nil, err
+		}
+		b = append(b, creds...)
+	}
+
+	ci := &secureConnectInfo{
+		Flags:   binary.LittleEndian.Uint16(b[2:4]),
+		Version: b[4],
+		Error:   b[5],
+		NetID:   append([]byte(nil), b[6:12]...),
+	}
+	host := b[32:64]
+	if i := bytes.IndexByte(host, 0); i >= 0 {
+		host = host[:i]
+	}
+	ci.Hostname = string(host)
+	if len(b) > secureInfoLen {
+		if len(b) < 66 || len(b) != 66+int(b[64])+int(b[65]) {
+			return nil, errors.New("secure ads: invalid credentials")
+		}
+		ci.User = string(b[66 : 66+int(b[64])])
+		ci.Password = string(b[66+int(b[64]):])
+	}
+	return ci, nil
+}
+
+// tlsConfig returns the TLS configuration for connecting to addr.
+func (s *SecureADS) tlsConfig(addr string) (*tls.Config, error) {
+	var cfg *tls.Config
+	if s.Config != nil {
+		cfg = s.Config.Clone()
+	} else {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ServerName = host
+	}
+	if len(s.Fingerprints) == 0 {
+		return cfg, nil
+	}
+
+	pins := make([][]byte, len(s.Fingerprints))
+	for i, fp := range s.Fingerprints {
+		b, err := hex.DecodeString(strings.ReplaceAll(fp, ":", ""))
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("secure ads: invalid fingerprint %q", fp)
+		}
+		pins[i] = b
+	}
+
+	// the pinned fingerprint replaces the verification of the chain.
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("secure ads: no server certificate")
+		}
+		sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+		for _, pin := range pins {
+			if bytes.Equal(sum[:], pin) {
+				return nil
+			}
+		}
+		return fmt.Errorf("secure ads: certificate fingerprint %x not pinned", sum)
+	}
+	return cfg, nil
+}
+
+// DialContext implements Dialer. Addr usually uses the SecureADSPort.
+func (s *SecureADS) DialContext(ctx context.Context, addr string) (net.Conn, error) {
+	d := s.Transport
+	if d == nil {
+		d = &TCPDialer{}
+	}
+	conn, err := d.DialContext(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn, err := s.handshake(ctx, conn, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// handshake establishes the TLS session on conn and performs the
+// Secure ADS connect handshake.
+func (s *SecureADS) handshake(ctx context.Context, conn net.Conn, addr string) (*tls.Conn, error) {
+	if s.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.HandshakeTimeout)
+		defer cancel()
+	}
+
+	cfg, err := s.tlsConfig(addr)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+
+	// the connect handshake uses the context deadline for the connection
+	if d, ok := ctx.Deadline(); ok {
+		tlsConn.SetDeadline(d)
+		defer tlsConn.SetDeadline(time.Time{})
+	}
+
+	req := &secureConnectInfo{
+		Version:  secureVersion,
+		NetID:    s.NetID,
+		Hostname: s.Hostname,
+		User:     s.User,
+		Password: s.Password,
+	}
+	if s.User != "" {
+		req.Flags |= secureFlagSelfSigned
+	}
+	if len(s.Fingerprints) > 0 {
+		req.Flags |= secureFlagIgnoreCn
+	}
+	b, err := req.encode()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tlsConn.Write(b); err != nil {
+		return nil, err
+	}
+
+	resp, err := readSecureConnectInfo(tlsConn)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Flags&secureFlagResponse == 0 {
+		return nil, errors.New("secure ads: invalid connect response")
+	}
+	if resp.Error != SecureErrorNone {
+		return nil, &SecureADSError{Code: resp.Error}
+	}
+	if resp.Flags&secureFlagAmsAllowed == 0 {
+		return nil, ErrSecureADSNotAllowed
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,227 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// newCert creates a certificate for 127.0.0.1 which is signed by
+// parent or self-signed if parent is nil.
+func newCert(t *testing.T, cn string, isCA bool, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// secureServer returns a test server option which wraps the
+// connections with TLS and answers the connect handshake with resp.
+// The connect info of the client is sent to info.
+func secureServer(cfg *tls.Config, resp secureConnectInfo, info chan<- *secureConnectInfo) func(s *testServer) {
+	return func(s *testServer) {
+		s.wrap = func(conn net.Conn) (net.Conn, error) {
+			tlsConn := tls.Server(conn, cfg)
+			if err := tlsConn.Handshake(); err != nil {
+				return nil, err
+			}
+			ci, err := readSecureConnectInfo(tlsConn)
+			if err != nil {
+				return nil, err
+			}
+			info <- ci
+			b, err := resp.encode()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := tlsConn.Write(b); err != nil {
+				return nil, err
+			}
+			return tlsConn, nil
+		}
+	}
+}
+
+var secureOK = secureConnectInfo{
+	Flags:   secureFlagResponse | secureFlagAmsAllowed,
+	Version: secureVersion,
+}
+
+func dialSecure(s *testServer, sec *SecureADS) (*Client, error) {
+	c := &Client{Addr: s.l.Addr().String(), ReadTimeout: time.Second, Dialer: sec}
+	return c, c.Dial(context.Background())
+}
+
+func TestSecureADSFingerprint(t *testing.T) {
+	cert := newCert(t, "plc", false, nil)
+	sum := sha256.Sum256(cert.Certificate[0])
+
+	info := make(chan *secureConnectInfo, 1)
+	s := newTestServer(t, secureServer(&tls.Config{Certificates: []tls.Certificate{cert}}, secureOK, info))
+
+	c, err := dialSecure(s, &SecureADS{
+		Fingerprints: []string{colonHex(sum[:])},
+		NetID:        testSender.NetID,
+		Hostname:     "client",
+		User:         "Administrator",
+		Password:     "1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	verify.Values(t, "connect info", <-info, &secureConnectInfo{
+		Flags:    secureFlagSelfSigned | secureFlagIgnoreCn,
+		Version:  secureVersion,
+		NetID:    testSender.NetID,
+		Hostname: "client",
+		User:     "Administrator",
+		Password: "1",
+	})
+
+	resp, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "data", resp.Data, []byte{0})
+}
+
+func colonHex(b []byte) string {
+	s := ""
+	for i, x := range b {
+		if i > 0 {
+			s += ":"
+		}
+		s += fmt.Sprintf("%02X", x)
+	}
+	return s
+}
+
+func TestSecureADSFingerprintMismatch(t *testing.T) {
+	cert := newCert(t, "plc", false, nil)
+	s := newTestServer(t, secureServer(&tls.Config{Certificates: []tls.Certificate{cert}}, secureOK, make(chan *secureConnectInfo, 1)))
+
+	_, err := dialSecure(s, &SecureADS{Fingerprints: []string{fmt.Sprintf("%x", sha256.Sum256(nil))}})
+	if err == nil {
+		t.Fatal("got nil want error")
+	}
+}
+
+func TestSecureADSClientCertificate(t *testing.T) {
+	ca := newCert(t, "ca", true, nil)
+	serverCert := newCert(t, "plc", false, &ca)
+	clientCert := newCert(t, "client", false, &ca)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	info := make(chan *secureConnectInfo, 1)
+	s := newTestServer(t, secureServer(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}, secureOK, info))
+
+	c, err := dialSecure(s, &SecureADS{
+		Config: &tls.Config{
+			Certificates: []tls.Certificate{clientCert},
+			RootCAs:      pool,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	<-info
+
+	if _, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSecureADSUnknownCA(t *testing.T) {
+	cert := newCert(t, "plc", false, nil)
+	s := newTestServer(t, secureServer(&tls.Config{Certificates: []tls.Certificate{cert}}, secureOK, make(chan *secureConnectInfo, 1)))
+
+	var uerr x509.UnknownAuthorityError
+	if _, err := dialSecure(s, &SecureADS{}); !errors.As(err, &uerr) {
+		t.Fatalf("got %v want %T", err, uerr)
+	}
+}
+
+func TestSecureADSHandshakeError(t *testing.T) {
+	cert := newCert(t, "plc", false, nil)
+	sum := sha256.Sum256(cert.Certificate[0])
+
+	tests := []struct {
+		name string
+		resp secureConnectInfo
+		err  error
+	}{
+		{
+			name: "unknown user",
+			resp: secureConnectInfo{Flags: secureFlagResponse, Error: SecureErrorUnknownUser},
+			err:  &SecureADSError{Code: SecureErrorUnknownUser},
+		},
+		{
+			name: "ams not allowed",
+			resp: secureConnectInfo{Flags: secureFlagResponse},
+			err:  ErrSecureADSNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, secureServer(&tls.Config{Certificates: []tls.Certificate{cert}}, tt.resp, make(chan *secureConnectInfo, 1)))
+
+			_, err := dialSecure(s, &SecureADS{
+				Fingerprints:     []string{fmt.Sprintf("%x", sum)},
+				HandshakeTimeout: time.Second,
+			})
+			verify.Values(t, "", err, tt.err)
+		})
+	}
+}
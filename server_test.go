@@ -0,0 +1,297 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+var (
+	testTarget = ams.MustParseAddr("1.2.3.4.5.6:851")
+	testSender = ams.MustParseAddr("5.6.7.8.9.0:5678")
+)
+
+// testServer is a minimal ADS server for tests. It keeps
+// the memory of every index group in a byte slice.
+type testServer struct {
+	t *testing.T
+	l net.Listener
+
+	mu      sync.Mutex
+	mem     map[uint32][]byte
+	handles map[string]uint32
+	state   uint16
+	notes   map[uint32]*testNotification
+
+	// writeMu serializes the writes of responses and notifications.
+	writeMu sync.Mutex
+
+	// handle can override the response for a request.
+	// If it returns nil then the request is dropped.
+	handle func(req ams.Packet) ams.Packet
+
+	// initial sends the current value right after a notification
+	// was added like TwinCAT does.
+	initial bool
+
+	// wrap is called for every accepted connection before
+	// the requests are served.
+	wrap func(conn net.Conn) (net.Conn, error)
+}
+
+// testNotification is a device notification which was added by a client.
+type testNotification struct {
+	conn          net.Conn
+	group, offset uint32
+	length        uint32
+}
+
+// setHandler overrides the default responses.
+func (s *testServer) setHandler(f func(req ams.Packet) ams.Packet) {
+	s.mu.Lock()
+	s.handle = f
+	s.mu.Unlock()
+}
+
+// newTestServer starts a test server on the loopback interface.
+// The options are applied before the server is started and
+// can replace the listener.
+func newTestServer(t *testing.T, opts ...func(s *testServer)) *testServer {
+	t.Helper()
+	s := &testServer{
+		t:       t,
+		mem:     map[uint32][]byte{},
+		handles: map[string]uint32{},
+		state:   ams.ADSStateRun,
+		notes:   map[uint32]*testNotification{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.l == nil {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.l = l
+	}
+	go s.serve()
+	t.Cleanup(func() { s.l.Close() })
+	return s
+}
+
+// dial returns a client which is connected to the server.
+// The options are applied to the client before dialing.
+func (s *testServer) dial(t *testing.T, opts ...func(c *Client)) *Client {
+	t.Helper()
+	c := &Client{Addr: s.l.Addr().String(), ReadTimeout: time.Second}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if err := c.Dial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *testServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	if s.wrap != nil {
+		c, err := s.wrap(conn)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		conn = c
+	}
+	for {
+		frame, err := ams.ReadFrame(conn, ams.DefaultMaxFrameLen)
+		if err != nil {
+			return
+		}
+		req, err := ams.Decode(frame)
+		if err != nil {
+			s.t.Errorf("server: decode: %s", err)
+			return
+		}
+
+		s.mu.Lock()
+		handle := s.handle
+		s.mu.Unlock()
+
+		var resp ams.Packet
+		if handle != nil {
+			resp = handle(req)
+		} else {
+			resp = s.respond(req)
+		}
+		if resp == nil {
+			continue
+		}
+		resp.Header().InvokeID = req.Header().InvokeID
+
+		// notifications are sent on the connection which added them
+		var added *testNotification
+		if r, ok := resp.(*ams.AddDeviceNotificationResponse); ok && r.Result == ams.NoError {
+			s.mu.Lock()
+			if n := s.notes[r.NotificationHandle]; n != nil {
+				n.conn = conn
+				if s.initial {
+					added = n
+				}
+			}
+			s.mu.Unlock()
+		}
+
+		if err := s.send(conn, resp); err != nil {
+			return
+		}
+		if added != nil {
+			s.notify(added.group, added.offset)
+		}
+	}
+}
+
+func (s *testServer) send(conn net.Conn, pkt ams.Packet) error {
+	var b ams.Buffer
+	if err := pkt.Encode(&b); err != nil {
+		s.t.Errorf("server: encode: %s", err)
+		return err
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err := conn.Write(b.Bytes())
+	return err
+}
+
+// notify sends the current value of the memory to all notifications
+// of the index group and offset.
+func (s *testServer) notify(group, offset uint32) {
+	type sample struct {
+		conn net.Conn
+		ams.NotificationSample
+	}
+	var samples []sample
+	s.mu.Lock()
+	for h, n := range s.notes {
+		if n.group == group && n.offset == offset && n.conn != nil {
+			samples = append(samples, sample{n.conn, ams.NotificationSample{NotificationHandle: h, Data: s.read(group, offset, n.length)}})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, x := range samples {
+		stamp := ams.NotificationStamp{
+			Timestamp: uint64(time.Now().UnixNano()/100) + 116444736000000000,
+			Samples:   []ams.NotificationSample{x.NotificationSample},
+		}
+		s.send(x.conn, ams.NewDeviceNotificationRequest(testSender, testTarget, []ams.NotificationStamp{stamp}))
+	}
+}
+
+// respond returns the default response for a request.
+func (s *testServer) respond(req ams.Packet) ams.Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hdr := req.Header()
+	switch r := req.(type) {
+	case *ams.ReadRequest:
+		return ams.NewReadResponse(hdr.Sender, hdr.Target, ams.NoError, s.read(r.IndexGroup, r.IndexOffset, r.Length))
+	case *ams.WriteRequest:
+		s.write(r.IndexGroup, r.IndexOffset, r.Data)
+		return ams.NewWriteResponse(hdr.Sender, hdr.Target, ams.NoError)
+	case *ams.ReadWriteRequest:
+		if r.IndexGroup == ams.IdxGetSymHandleByName {
+			h, ok := s.handles[string(r.Data)]
+			if !ok {
+				h = uint32(len(s.handles) + 1)
+				s.handles[string(r.Data)] = h
+			}
+			data := make([]byte, 4)
+			binary.LittleEndian.PutUint32(data, h)
+			return ams.NewReadWriteResponse(hdr.Sender, hdr.Target, ams.NoError, data)
+		}
+		if r.IndexGroup == ams.IdxADSIGRP_SUMUP_READ || r.IndexGroup == ams.IdxADSIGRP_SUMUP_WRITE {
+			return ams.NewReadWriteResponse(hdr.Sender, hdr.Target, ams.NoError, s.sum(r))
+		}
+		s.write(r.IndexGroup, r.IndexOffset, r.Data)
+		return ams.NewReadWriteResponse(hdr.Sender, hdr.Target, ams.NoError, s.read(r.IndexGroup, r.IndexOffset, r.ReadLength))
+	case *ams.ReadStateRequest:
+		return ams.NewReadStateResponse(hdr.Sender, hdr.Target, ams.NoError, s.state, 0)
+	case *ams.AddDeviceNotificationRequest:
+		h := uint32(len(s.notes) + 1)
+		for s.notes[h] != nil {
+			h++
+		}
+		s.notes[h] = &testNotification{group: r.IndexGroup, offset: r.IndexOffset, length: r.Length}
+		return ams.NewAddDeviceNotificationResponse(hdr.Sender, hdr.Target, ams.NoError, h)
+	case *ams.DeleteDeviceNotificationRequest:
+		if s.notes[r.NotificationHandle] == nil {
+			return ams.NewDeleteDeviceNotificationResponse(hdr.Sender, hdr.Target, ams.DeviceNotifyHandleInvalid)
+		}
+		delete(s.notes, r.NotificationHandle)
+		return ams.NewDeleteDeviceNotificationResponse(hdr.Sender, hdr.Target, ams.NoError)
+	default:
+		s.t.Errorf("server: unexpected request %T", req)
+		return nil
+	}
+}
+
+// sum serves the sub requests of a sum read or sum write and returns
+// their results followed by the read data.
+func (s *testServer) sum(r *ams.ReadWriteRequest) []byte {
+	n := int(r.IndexOffset)
+	var results, values ams.Buffer
+	data := r.Data[12*n:]
+	for i := 0; i < n; i++ {
+		sub := r.Data[12*i:]
+		group, offset, length := binary.LittleEndian.Uint32(sub), binary.LittleEndian.Uint32(sub[4:]), binary.LittleEndian.Uint32(sub[8:])
+		results.WriteUint32(ams.NoError)
+		if r.IndexGroup == ams.IdxADSIGRP_SUMUP_READ {
+			values.Write(s.read(group, offset, length))
+			continue
+		}
+		s.write(group, offset, data[:length])
+		data = data[length:]
+	}
+	return append(results.Bytes(), values.Bytes()...)
+}
+
+func (s *testServer) read(group, offset, n uint32) []byte {
+	b := make([]byte, n)
+	m := s.mem[group]
+	if int(offset) < len(m) {
+		copy(b, m[offset:])
+	}
+	return b
+}
+
+func (s *testServer) write(group, offset uint32, data []byte) {
+	m := s.mem[group]
+	if end := int(offset) + len(data); end > len(m) {
+		m = append(m, make([]byte, end-len(m))...)
+	}
+	copy(m[offset:], data)
+	s.mem[group] = m
+}
@@ -0,0 +1,392 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// SnapshotVersion is the version of the snapshot format.
+const SnapshotVersion = 1
+
+// maxSumItems is the maximum number of sub requests of a sum request.
+const maxSumItems = 500
+
+// maxSumBytes is the default for the maximum number of bytes of the
+// sub requests of a sum request which keeps the frames small enough
+// for the targets.
+const maxSumBytes = 64 << 10
+
+// sumBatch returns the end of the batch of a sum request which starts
+// at start. A batch has at most maxItems sub requests and maxBytes of
+// sub request headers and data unless a single sub request is larger.
+func sumBatch(start, n, maxItems, maxBytes int, size func(i int) int) int {
+	end, total := start, 0
+	for end < n && end-start < maxItems {
+		s := 12 + size(end)
+		if end > start && total+s > maxBytes {
+			break
+		}
+		total += s
+		end++
+	}
+	return end
+}
+
+// Errors of a snapshot restore.
+var (
+	ErrTypeMismatch = errors.New("type does not match")
+	ErrNotRestored  = errors.New("not restored since other items are invalid")
+)
+
+// Snapshot contains the values of a set of variables, e.g. a machine
+// recipe. It is stored as JSON with WriteTo or as YAML with WriteYAML.
+type Snapshot struct {
+	Version int             `json:"version"`
+	Time    time.Time       `json:"time"`
+	Target  string          `json:"target,omitempty"`
+	Items   []*SnapshotItem `json:"items"`
+}
+
+// SnapshotItem is the value of a variable. Value is decoded like
+// SymbolTable.Decode.
+type SnapshotItem struct {
+	Path  string      `json:"path"`
+	Type  string      `json:"type"`
+	Size  uint32      `json:"size"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON encodes the item with the floats of its value which JSON
+// cannot represent as the strings NaN, +Inf and -Inf.
+func (item SnapshotItem) MarshalJSON() ([]byte, error) {
+	type plain SnapshotItem
+	x := plain(item)
+	x.Value = jsonValue(x.Value)
+	return json.Marshal(x)
+}
+
+// ReadSnapshot reads a snapshot which was written with WriteTo.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	var s Snapshot
+	if err := d.Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	if s.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+	return &s, nil
+}
+
+// WriteTo writes the snapshot as indented JSON.
+func (s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(b, '\n'))
+	return int64(n), err
+}
+
+// Match returns the symbol paths for a list of patterns. Patterns
+// with the wildcards '*' and '?' are matched case-insensitive against
+// the names of the symbols. Other patterns are symbol paths like
+// MAIN.arr[2].x and are returned as they are. Every path is returned
+// once in the order of the patterns.
+func (t *SymbolTable) Match(patterns ...string) ([]string, error) {
+	var paths []string
+	seen := map[string]bool{}
+	for _, p := range patterns {
+		if !strings.ContainsAny(p, "*?") {
+			if !seen[strings.ToLower(p)] {
+				seen[strings.ToLower(p)] = true
+				paths = append(paths, p)
+			}
+			continue
+		}
+		found := false
+		for _, s := range t.Symbols {
+			if matchSymbol(p, s.Name) {
+				found = true
+				if !seen[strings.ToLower(s.Name)] {
+					seen[strings.ToLower(s.Name)] = true
+					paths = append(paths, s.Name)
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%q: %w", p, ErrUnknownSymbol)
+		}
+	}
+	return paths, nil
+}
+
+// Snapshot reads the variables which match the patterns with sum
+// reads. See SymbolTable.Match for the patterns.
+func (c *Client) Snapshot(ctx context.Context, target, sender ams.Addr, t *SymbolTable, patterns ...string) (*Snapshot, error) {
+	paths, err := t.Match(patterns...)
+	if err != nil {
+		return nil, err
+	}
+	locs := make([]*Location, len(paths))
+	for i, p := range paths {
+		if locs[i], err = c.ResolveSymbol(ctx, target, sender, t, p); err != nil {
+			return nil, err
+		}
+	}
+	data, results, err := c.sumRead(ctx, target, sender, locs)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Snapshot{Version: SnapshotVersion, Time: time.Now().UTC(), Target: target.String()}
+	for i, loc := range locs {
+		if results[i] != ams.NoError {
+			return nil, fmt.Errorf("failed to read %s: %w", loc.Path, &ADSError{Code: results[i]})
+		}
+		v, err := t.Decode(loc.Type, data[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", loc.Path, err)
+		}
+		s.Items = append(s.Items, &SnapshotItem{Path: loc.Path, Type: loc.Type, Size: loc.Size, Value: v})
+	}
+	return s, nil
+}
+
+// sumRead reads the locations with sum read requests and returns the
+// data and the ADS result of every location.
+func (c *Client) sumRead(ctx context.Context, target, sender ams.Addr, locs []*Location) ([][]byte, []uint32, error) {
+	data := make([][]byte, len(locs))
+	results := make([]uint32, len(locs))
+	for start, end := 0, 0; start < len(locs); start = end {
+		end = sumBatch(start, len(locs), maxSumItems, maxSumBytes, func(i int) int { return int(locs[i].Size) })
+		batch := locs[start:end]
+		var b ams.Buffer
+		n := uint32(4 * len(batch))
+		for _, loc := range batch {
+			b.WriteUint32(loc.IndexGroup)
+			b.WriteUint32(loc.IndexOffset)
+			b.WriteUint32(loc.Size)
+			n += loc.Size
+		}
+		r, err := c.ReadWrite(Idempotent(ctx), ams.NewReadWriteRequest(target, sender, ams.IdxADSIGRP_SUMUP_READ, uint32(len(batch)), n, b.Bytes()))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %d variables: %w", len(batch), err)
+		}
+		if r.Result != ams.NoError {
+			return nil, nil, fmt.Errorf("failed to read %d variables: %w", len(batch), &ADSError{Code: r.Result})
+		}
+		if uint32(len(r.Data)) < n {
+			return nil, nil, fmt.Errorf("failed to read %d variables: got %d bytes want %d", len(batch), len(r.Data), n)
+		}
+		values := r.Data[4*len(batch):]
+		for i, loc := range batch {
+			results[start+i] = binary.LittleEndian.Uint32(r.Data[4*i:])
+			data[start+i], values = values[:loc.Size], values[loc.Size:]
+		}
+	}
+	return data, results, nil
+}
+
+// sumWrite writes the data to the locations with sum write requests
+// and returns the ADS result of every location. A failed request is
+// returned as the error of all its locations.
+func (c *Client) sumWrite(ctx context.Context, target, sender ams.Addr, locs []*Location, data [][]byte, batchSize, batchBytes int) []error {
+	errs := make([]error, len(locs))
+	for start, end := 0, 0; start < len(locs); start = end {
+		end = sumBatch(start, len(locs), batchSize, batchBytes, func(i int) int { return len(data[i]) })
+		var head, body ams.Buffer
+		for i := start; i < end; i++ {
+			head.WriteUint32(locs[i].IndexGroup)
+			head.WriteUint32(locs[i].IndexOffset)
+			head.WriteUint32(uint32(len(data[i])))
+			body.Write(data[i])
+		}
+		n := end - start
+		r, err := c.ReadWrite(ctx, ams.NewReadWriteRequest(target, sender, ams.IdxADSIGRP_SUMUP_WRITE, uint32(n), uint32(4*n), append(head.Bytes(), body.Bytes()...)))
+		switch {
+		case err != nil:
+		case r.Result != ams.NoError:
+			err = &ADSError{Code: r.Result}
+		case len(r.Data) < 4*n:
+			err = fmt.Errorf("got %d bytes want %d", len(r.Data), 4*n)
+		}
+		for i := start; i < end; i++ {
+			switch {
+			case err != nil:
+				errs[i] = err
+			default:
+				if code := binary.LittleEndian.Uint32(r.Data[4*(i-start):]); code != ams.NoError {
+					errs[i] = &ADSError{Code: code}
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// RestoreOptions configure the restore of a snapshot.
+type RestoreOptions struct {
+	// Partial writes the valid items even if other items are
+	// invalid. Otherwise nothing is written if an item is invalid.
+	Partial bool
+
+	// BatchSize is the maximum number of items of a sum write.
+	// If zero, 500 is used.
+	BatchSize int
+
+	// BatchBytes is the maximum number of bytes of the items of a
+	// sum write including 12 bytes per item. A larger item is
+	// written alone. If zero, 64 KiB are used.
+	BatchBytes int
+}
+
+// RestoreResult is the outcome of the restore of an item. Err is nil
+// if the value was written.
+type RestoreResult struct {
+	Path string
+	Err  error
+}
+
+// Restore writes the values of a snapshot. Every item is validated
+// against the symbol table first. Its path must resolve to a variable
+// of the same type and size and the value must be valid for the type
+// like with SymbolTable.Encode. The valid items are written with sum
+// writes. Restore returns the outcome of every item and an error if an
+// item was not restored.
+func (c *Client) Restore(ctx context.Context, target, sender ams.Addr, t *SymbolTable, s *Snapshot, opts RestoreOptions) ([]RestoreResult, error) {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 || batchSize > maxSumItems {
+		batchSize = maxSumItems
+	}
+	batchBytes := opts.BatchBytes
+	if batchBytes <= 0 {
+		batchBytes = maxSumBytes
+	}
+
+	results := make([]RestoreResult, len(s.Items))
+	var (
+		locs    []*Location
+		data    [][]byte
+		index   []int
+		invalid int
+	)
+	for i, item := range s.Items {
+		results[i].Path = item.Path
+		loc, b, err := c.restoreItem(ctx, target, sender, t, item)
+		if err != nil {
+			results[i].Err = err
+			invalid++
+			continue
+		}
+		locs, data, index = append(locs, loc), append(data, b), append(index, i)
+	}
+
+	if invalid > 0 && !opts.Partial {
+		for _, i := range index {
+			results[i].Err = ErrNotRestored
+		}
+		return results, fmt.Errorf("%d of %d items are invalid", invalid, len(s.Items))
+	}
+
+	failed := invalid
+	for j, err := range c.sumWrite(ctx, target, sender, locs, data, batchSize, batchBytes) {
+		if err != nil {
+			results[index[j]].Err = fmt.Errorf("failed to write %s: %w", locs[j].Path, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d items were not restored", failed, len(s.Items))
+	}
+	return results, nil
+}
+
+// restoreItem validates an item and returns its location and data.
+func (c *Client) restoreItem(ctx context.Context, target, sender ams.Addr, t *SymbolTable, item *SnapshotItem) (*Location, []byte, error) {
+	loc, err := c.ResolveSymbol(ctx, target, sender, t, item.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if typeKey(loc.Type) != typeKey(item.Type) || loc.Size != item.Size {
+		return nil, nil, fmt.Errorf("%s is %s of %d bytes, snapshot has %s of %d bytes: %w",
+			item.Path, loc.Type, loc.Size, item.Type, item.Size, ErrTypeMismatch)
+	}
+	b, err := t.Encode(loc.Type, item.Value)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", item.Path, err)
+	}
+	return loc, b, nil
+}
+
+// SnapshotDiff is a difference between two snapshots. Old is nil for
+// added items and New is nil for removed items.
+type SnapshotDiff struct {
+	Path             string
+	OldType, NewType string
+	Old, New         interface{}
+}
+
+// DiffSnapshots returns the items which differ between two snapshots
+// in the order of the items of a followed by the items which are only
+// in b. Values are compared by their JSON encoding and paths
+// case-insensitive.
+func DiffSnapshots(a, b *Snapshot) ([]SnapshotDiff, error) {
+	items := map[string]*SnapshotItem{}
+	for _, item := range b.Items {
+		items[strings.ToLower(item.Path)] = item
+	}
+
+	var diffs []SnapshotDiff
+	seen := map[string]bool{}
+	for _, old := range a.Items {
+		key := strings.ToLower(old.Path)
+		seen[key] = true
+		item := items[key]
+		if item == nil {
+			diffs = append(diffs, SnapshotDiff{Path: old.Path, OldType: old.Type, Old: old.Value})
+			continue
+		}
+		equal, err := equalJSON(old.Value, item.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", old.Path, err)
+		}
+		if !equal || typeKey(old.Type) != typeKey(item.Type) {
+			diffs = append(diffs, SnapshotDiff{Path: old.Path, OldType: old.Type, NewType: item.Type, Old: old.Value, New: item.Value})
+		}
+	}
+	for _, item := range b.Items {
+		if !seen[strings.ToLower(item.Path)] {
+			diffs = append(diffs, SnapshotDiff{Path: item.Path, NewType: item.Type, New: item.Value})
+		}
+	}
+	return diffs, nil
+}
+
+// equalJSON reports whether two values have the same JSON encoding.
+// Object keys are sorted by encoding/json.
+func equalJSON(a, b interface{}) (bool, error) {
+	x, err := json.Marshal(jsonValue(a))
+	if err != nil {
+		return false, err
+	}
+	y, err := json.Marshal(jsonValue(b))
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(x, y), nil
+}
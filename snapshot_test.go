@@ -0,0 +1,226 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	s := newTestServer(t)
+	s.mu.Lock()
+	s.write(0x4020, 0, []byte{0xFE, 0xFF})     // MAIN.nValue
+	s.write(0x4020, 402, []byte{1, 0})         // MAIN.fb.eState
+	s.write(0x4040, 0, []byte("recipe A\x00")) // GVL.sName
+	s.write(0x4040, 22, []byte{'x', 0, 0, 0})  // GVL.wsName
+	s.mu.Unlock()
+	c := s.dial(t)
+	table := testSymbolTable()
+	ctx := context.Background()
+
+	snap, err := c.Snapshot(ctx, testTarget, testSender, table, "MAIN.n*", "gvl.*", "MAIN.fb.eState", "MAIN.nValue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "items", snap.Items, []*SnapshotItem{
+		{Path: "MAIN.nValue", Type: "INT", Size: 2, Value: int64(-2)},
+		{Path: "GVL.sName", Type: "STRING(20)", Size: 21, Value: "recipe A"},
+		{Path: "GVL.wsName", Type: "WSTRING(3)", Size: 8, Value: "x"},
+		{Path: "MAIN.fb.eState", Type: "E_State", Size: 2, Value: "Running"},
+	})
+
+	var buf bytes.Buffer
+	if _, err := snap.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	snap, err = ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the PLC changes and the snapshot is restored
+	s.mu.Lock()
+	s.write(0x4020, 0, []byte{5, 0})
+	s.write(0x4040, 0, []byte("other recipe\x00"))
+	s.mu.Unlock()
+	results, err := c.Restore(ctx, testTarget, testSender, table, snap, RestoreOptions{BatchSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.Path, r.Err)
+		}
+	}
+	s.mu.Lock()
+	verify.Values(t, "nValue", s.read(0x4020, 0, 2), []byte{0xFE, 0xFF})
+	verify.Values(t, "sName", s.read(0x4040, 0, 21), append([]byte("recipe A"), make([]byte, 13)...))
+	s.mu.Unlock()
+}
+
+func TestRestoreBatchBytes(t *testing.T) {
+	s := newTestServer(t)
+	var mu sync.Mutex
+	var batches []uint32
+	s.setHandler(func(req ams.Packet) ams.Packet {
+		if r, ok := req.(*ams.ReadWriteRequest); ok && r.IndexGroup == ams.IdxADSIGRP_SUMUP_WRITE {
+			mu.Lock()
+			batches = append(batches, r.IndexOffset)
+			mu.Unlock()
+		}
+		return s.respond(req)
+	})
+	c := s.dial(t)
+	table := testSymbolTable()
+	ctx := context.Background()
+
+	snap := &Snapshot{Version: SnapshotVersion, Items: []*SnapshotItem{
+		{Path: "MAIN.nValue", Type: "INT", Size: 2, Value: json.Number("7")},
+		{Path: "GVL.sName", Type: "STRING(20)", Size: 21, Value: "recipe B"},
+		{Path: "GVL.wsName", Type: "WSTRING(3)", Size: 8, Value: "y"},
+		{Path: "MAIN.fb.eState", Type: "E_State", Size: 2, Value: "Idle"},
+	}}
+	// 12 bytes per item plus 2, 21, 8 and 2 bytes of data
+	if _, err := c.Restore(ctx, testTarget, testSender, table, snap, RestoreOptions{BatchBytes: 40}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	verify.Values(t, "batches", batches, []uint32{1, 1, 2})
+	s.mu.Lock()
+	verify.Values(t, "nValue", s.read(0x4020, 0, 2), []byte{7, 0})
+	verify.Values(t, "wsName", s.read(0x4040, 22, 2), []byte{'y', 0})
+	s.mu.Unlock()
+}
+
+func TestRestoreInvalid(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+	table := testSymbolTable()
+	ctx := context.Background()
+
+	snap := &Snapshot{Version: SnapshotVersion, Items: []*SnapshotItem{
+		{Path: "MAIN.nValue", Type: "INT", Size: 2, Value: json.Number("7")},
+		{Path: "MAIN.grid[0,0]", Type: "DINT", Size: 4, Value: json.Number("1")},
+		{Path: "MAIN.fb.eState", Type: "E_State", Size: 2, Value: "Stopped"},
+		{Path: "MAIN.missing", Type: "INT", Size: 2, Value: json.Number("1")},
+	}}
+	for _, partial := range []bool{false, true} {
+		results, err := c.Restore(ctx, testTarget, testSender, table, snap, RestoreOptions{Partial: partial})
+		if err == nil {
+			t.Fatal("no error")
+		}
+		if partial {
+			verify.Values(t, "written", results[0].Err, nil)
+		} else {
+			verify.Values(t, "not written", results[0].Err, ErrNotRestored)
+		}
+		if !errors.Is(results[1].Err, ErrTypeMismatch) {
+			t.Errorf("got %v want ErrTypeMismatch", results[1].Err)
+		}
+		var ve *ValueError
+		if !errors.As(results[2].Err, &ve) {
+			t.Errorf("got %v want *ValueError", results[2].Err)
+		}
+		if !errors.Is(results[3].Err, ErrUnknownSymbol) {
+			t.Errorf("got %v want ErrUnknownSymbol", results[3].Err)
+		}
+
+		s.mu.Lock()
+		want := []byte{0, 0}
+		if partial {
+			want = []byte{7, 0}
+		}
+		verify.Values(t, "nValue", s.read(0x4020, 0, 2), want)
+		s.mu.Unlock()
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	a := &Snapshot{Items: []*SnapshotItem{
+		{Path: "MAIN.nValue", Type: "INT", Value: int64(1)},
+		{Path: "MAIN.fb", Type: "FB_Motor", Value: map[string]interface{}{"bOn": true, "aSpeeds": []interface{}{1.5, 2.0}}},
+		{Path: "GVL.sName", Type: "STRING(20)", Value: "a"},
+	}}
+	b := &Snapshot{Items: []*SnapshotItem{
+		{Path: "main.nvalue", Type: "INT", Value: json.Number("1")},
+		{Path: "MAIN.fb", Type: "FB_Motor", Value: map[string]interface{}{"aSpeeds": []interface{}{json.Number("1.5"), json.Number("3")}, "bOn": true}},
+		{Path: "GVL.wsName", Type: "WSTRING(3)", Value: "b"},
+	}}
+	diffs, err := DiffSnapshots(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "diffs", diffs, []SnapshotDiff{
+		{Path: "MAIN.fb", OldType: "FB_Motor", NewType: "FB_Motor", Old: a.Items[1].Value, New: b.Items[1].Value},
+		{Path: "GVL.sName", OldType: "STRING(20)", Old: "a"},
+		{Path: "GVL.wsName", NewType: "WSTRING(3)", New: "b"},
+	})
+}
+
+func TestSnapshotNonFinite(t *testing.T) {
+	table := testSymbolTable()
+	snap := &Snapshot{Version: SnapshotVersion, Items: []*SnapshotItem{
+		{Path: "MAIN.fb.aSpeeds", Type: "ARRAY [0..1] OF LREAL", Size: 16, Value: []interface{}{math.NaN(), math.Inf(1)}},
+		{Path: "MAIN.r", Type: "REAL", Size: 4, Value: math.Inf(-1)},
+	}}
+	want := [][]byte{
+		{1, 0, 0, 0, 0, 0, 0xF8, 0x7F, 0, 0, 0, 0, 0, 0, 0xF0, 0x7F}, // math.NaN and +Inf
+		{0, 0, 0x80, 0xFF},
+	}
+
+	write := map[string]func(io.Writer) (int64, error){"json": snap.WriteTo, "yaml": snap.WriteYAML}
+	read := map[string]func(io.Reader) (*Snapshot, error){"json": ReadSnapshot, "yaml": ReadSnapshotYAML}
+	for _, format := range []string{"json", "yaml"} {
+		var buf bytes.Buffer
+		if _, err := write[format](&buf); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		got, err := read[format](&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		verify.Values(t, format, got.Items[0].Value, []interface{}{"NaN", "+Inf"})
+		for i, item := range got.Items {
+			b, err := table.Encode(item.Type, item.Value)
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			verify.Values(t, format+" "+item.Path, b, want[i])
+		}
+	}
+
+	diffs, err := DiffSnapshots(snap, snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "diffs", len(diffs), 0)
+}
+
+func TestSnapshotMatch(t *testing.T) {
+	table := testSymbolTable()
+	paths, err := table.Match("MAIN.*Point", "MAIN.arr[1].x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "paths", paths, []string{"MAIN.pPoint", "MAIN.refPoint", "MAIN.arr[1].x"})
+
+	if _, err := table.Match("FOO.*"); !errors.Is(err, ErrUnknownSymbol) {
+		t.Errorf("got %v want ErrUnknownSymbol", err)
+	}
+	if _, err := ReadSnapshot(bytes.NewReader([]byte(`{"version":2}`))); err == nil {
+		t.Error("unsupported version read")
+	}
+}
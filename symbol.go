@@ -0,0 +1,308 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// symbolHandles maps the symbol handles of a client to the names of
+// the symbols and back. The handles are recorded from the responses
+// to GetSymHandleByName requests.
+type symbolHandles struct {
+	mu      sync.Mutex
+	names   map[string]string // by target and handle
+	handles map[string]uint32 // by target and lower case name
+}
+
+func handleKey(target ams.Addr, handle uint32) string {
+	return fmt.Sprintf("%s/%d", target, handle)
+}
+
+func nameKey(target ams.Addr, name string) string {
+	return target.String() + "/" + strings.ToLower(name)
+}
+
+func (h *symbolHandles) add(target ams.Addr, handle uint32, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.names == nil {
+		h.names = map[string]string{}
+		h.handles = map[string]uint32{}
+	}
+	h.names[handleKey(target, handle)] = name
+	h.handles[nameKey(target, name)] = handle
+}
+
+func (h *symbolHandles) remove(target ams.Addr, handle uint32) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := handleKey(target, handle)
+	if name, ok := h.names[k]; ok {
+		if h.handles[nameKey(target, name)] == handle {
+			delete(h.handles, nameKey(target, name))
+		}
+		delete(h.names, k)
+	}
+}
+
+// clear forgets all handles of the target.
+func (h *symbolHandles) clear(target ams.Addr) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	prefix := target.String() + "/"
+	for k := range h.names {
+		if strings.HasPrefix(k, prefix) {
+			delete(h.names, k)
+		}
+	}
+	for k := range h.handles {
+		if strings.HasPrefix(k, prefix) {
+			delete(h.handles, k)
+		}
+	}
+}
+
+func (h *symbolHandles) name(target ams.Addr, handle uint32) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.names[handleKey(target, handle)]
+}
+
+func (h *symbolHandles) handle(target ams.Addr, name string) (uint32, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	handle, ok := h.handles[nameKey(target, name)]
+	return handle, ok
+}
+
+// trackHandles records the symbol handles of GetSymHandleByName
+// responses and forgets released handles.
+func (c *Client) trackHandles(req ams.Packet, resp ams.Response) {
+	target := req.Header().Target
+	switch r := req.(type) {
+	case *ams.ReadWriteRequest:
+		res, ok := resp.(*ams.ReadWriteResponse)
+		if r.IndexGroup != ams.IdxGetSymHandleByName || !ok || res.Result != ams.NoError || len(res.Data) < 4 {
+			return
+		}
+		name := strings.TrimRight(string(r.Data), "\x00")
+		c.handles.add(target, binary.LittleEndian.Uint32(res.Data), name)
+	case *ams.WriteRequest:
+		if r.IndexGroup == ams.IdxReleaseSymHandle && len(r.Data) >= 4 {
+			c.handles.remove(target, binary.LittleEndian.Uint32(r.Data))
+		}
+	}
+}
+
+// symbolHandle returns the handle of a symbol. Handles are reused
+// until they are released.
+func (c *Client) symbolHandle(ctx context.Context, target, sender ams.Addr, name string) (uint32, error) {
+	if h, ok := c.handles.handle(target, name); ok {
+		return h, nil
+	}
+	return c.GetSymHandleByName(ctx, target, sender, name)
+}
+
+// staleHandle forgets the handle of a symbol if the target reports
+// that it is no longer valid.
+func (c *Client) staleHandle(target ams.Addr, handle, result uint32) {
+	switch result {
+	case ams.DeviceSymbolNotFound, ams.DeviceSymbolVersionInvalid, ams.DeviceNotifyHandleInvalid:
+		c.handles.remove(target, handle)
+	}
+}
+
+// defaultStringLen is the length of a STRING without a size.
+const defaultStringLen = 80
+
+// ReadSymbol reads the value of a symbol into the value v points to.
+// See WriteSymbol for the supported types. A string is read with the
+// length of the string v points to or with the default length of 80
+// characters if it is empty.
+func (c *Client) ReadSymbol(ctx context.Context, target, sender ams.Addr, name string, v interface{}) error {
+	n := 0
+	if s, ok := v.(*string); ok {
+		n = len(*s) + 1
+		if *s == "" {
+			n = defaultStringLen + 1
+		}
+	} else {
+		n = binary.Size(v)
+	}
+	if n <= 0 {
+		return fmt.Errorf("unsupported type %T", v)
+	}
+	b, err := c.readSymbol(ctx, target, sender, name, n)
+	if err != nil {
+		return err
+	}
+	return decodeValue(b, v)
+}
+
+func (c *Client) readSymbol(ctx context.Context, target, sender ams.Addr, name string, n int) ([]byte, error) {
+	h, err := c.symbolHandle(ctx, target, sender, name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.Read(ctx, ams.NewReadRequest(target, sender, ams.IdxReadWriteSymValueByHandle, h, uint32(n)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if r.Result != ams.NoError {
+		c.staleHandle(target, h, r.Result)
+		return nil, fmt.Errorf("failed to read %s: %w", name, &ADSError{Code: r.Result})
+	}
+	return r.Data, nil
+}
+
+// WriteSymbol writes a value to a symbol. Fixed size values like
+// bool, sized integers, floats and arrays and structs of them are
+// encoded in little endian. Strings are written with a terminating
+// zero byte.
+func (c *Client) WriteSymbol(ctx context.Context, target, sender ams.Addr, name string, v interface{}) error {
+	b, err := encodeValue(v)
+	if err != nil {
+		return err
+	}
+	h, err := c.symbolHandle(ctx, target, sender, name)
+	if err != nil {
+		return err
+	}
+	r, err := c.Write(ctx, ams.NewWriteRequest(target, sender, ams.IdxReadWriteSymValueByHandle, h, b))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if r.Result != ams.NoError {
+		c.staleHandle(target, h, r.Result)
+		return fmt.Errorf("failed to write %s: %w", name, &ADSError{Code: r.Result})
+	}
+	return nil
+}
+
+// DefaultCycleCounter is the symbol with the cycle count of the first
+// PLC task.
+const DefaultCycleCounter = "TwinCAT_SystemInfoVarList._TaskInfo[1].CycleCount"
+
+// VerifyOptions configures when WriteSymbolVerified reads the value
+// back. If both are set, it waits for the delay and the cycles.
+type VerifyOptions struct {
+	// Delay is the time to wait after the write.
+	Delay time.Duration
+
+	// Cycles is the number of PLC cycles to wait after the write.
+	Cycles uint32
+
+	// CycleCounter is the UDINT symbol with the cycle count of the
+	// task which writes the symbol. If empty, DefaultCycleCounter
+	// is used.
+	CycleCounter string
+
+	// PollInterval is the time between two reads of the cycle
+	// counter. If zero, 1ms is used.
+	PollInterval time.Duration
+}
+
+// MismatchError is returned by WriteSymbolVerified when the value
+// which was read back differs from the written value.
+type MismatchError struct {
+	Symbol   string
+	Expected interface{}
+	Actual   interface{}
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("%s is %v after write, want %v", e.Symbol, e.Actual, e.Expected)
+}
+
+// WriteSymbolVerified writes a value to a symbol, waits according to
+// opt and reads the value back. It returns a *MismatchError if the
+// PLC has changed the value in the meantime, e.g. because the PLC
+// logic overwrites it every cycle.
+func (c *Client) WriteSymbolVerified(ctx context.Context, target, sender ams.Addr, name string, v interface{}, opt VerifyOptions) error {
+	var start uint32
+	counter := opt.CycleCounter
+	if counter == "" {
+		counter = DefaultCycleCounter
+	}
+	if opt.Cycles > 0 {
+		if err := c.ReadSymbol(ctx, target, sender, counter, &start); err != nil {
+			return err
+		}
+	}
+
+	if err := c.WriteSymbol(ctx, target, sender, name, v); err != nil {
+		return err
+	}
+
+	if opt.Delay > 0 {
+		t := time.NewTimer(opt.Delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+	if opt.Cycles > 0 {
+		if err := c.waitCycles(ctx, target, sender, counter, start, opt); err != nil {
+			return err
+		}
+	}
+
+	// read back the written bytes and compare them. Unlike the
+	// decoded values this also works for NaN.
+	want, err := encodeValue(v)
+	if err != nil {
+		return err
+	}
+	got, err := c.readSymbol(ctx, target, sender, name, len(want))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		actual := reflect.New(reflect.TypeOf(v))
+		if err := decodeValue(got, actual.Interface()); err != nil {
+			return &MismatchError{Symbol: name, Expected: v, Actual: got}
+		}
+		return &MismatchError{Symbol: name, Expected: v, Actual: actual.Elem().Interface()}
+	}
+	return nil
+}
+
+// waitCycles polls the cycle counter until opt.Cycles cycles have
+// passed since start.
+func (c *Client) waitCycles(ctx context.Context, target, sender ams.Addr, counter string, start uint32, opt VerifyOptions) error {
+	interval := opt.PollInterval
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		var n uint32
+		if err := c.ReadSymbol(ctx, target, sender, counter, &n); err != nil {
+			return err
+		}
+		// the subtraction handles the overflow of the counter
+		if n-start >= opt.Cycles {
+			return nil
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
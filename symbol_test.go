@@ -0,0 +1,129 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// symbolHandle returns the handle of a symbol of the test server.
+func (s *testServer) symbolHandle(name string) (uint32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.handles[name]
+	return h, ok
+}
+
+func TestSymbolHandleCache(t *testing.T) {
+	var lookups, stale int32
+	s := newTestServer(t)
+	s.setHandler(func(req ams.Packet) ams.Packet {
+		switch r := req.(type) {
+		case *ams.ReadWriteRequest:
+			if r.IndexGroup == ams.IdxGetSymHandleByName {
+				atomic.AddInt32(&lookups, 1)
+			}
+		case *ams.WriteRequest:
+			if atomic.CompareAndSwapInt32(&stale, 1, 0) {
+				return ams.NewWriteResponse(r.Header().Sender, r.Header().Target, ams.DeviceSymbolVersionInvalid)
+			}
+		}
+		return s.respond(req)
+	})
+	c := s.dial(t)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := c.WriteSymbol(ctx, testTarget, testSender, "MAIN.nValue", uint16(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	verify.Values(t, "lookups", atomic.LoadInt32(&lookups), int32(1))
+
+	// an invalid handle is looked up again
+	atomic.StoreInt32(&stale, 1)
+	var aerr *ADSError
+	if err := c.WriteSymbol(ctx, testTarget, testSender, "MAIN.nValue", uint16(3)); !errors.As(err, &aerr) || aerr.Code != ams.DeviceSymbolVersionInvalid {
+		t.Fatalf("got %v want DeviceSymbolVersionInvalid", err)
+	}
+	var v uint16
+	if err := c.ReadSymbol(ctx, testTarget, testSender, "MAIN.nValue", &v); err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "lookups", atomic.LoadInt32(&lookups), int32(2))
+	verify.Values(t, "value", v, uint16(1))
+}
+
+func TestWriteSymbolVerified(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+	ctx := context.Background()
+
+	if err := c.WriteSymbolVerified(ctx, testTarget, testSender, "MAIN.sName", "abc", VerifyOptions{Delay: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := c.ReadSymbol(ctx, testTarget, testSender, "MAIN.sName", &v); err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "value", v, "abc")
+
+	// NaN is not equal to itself but has the same bytes
+	if err := c.WriteSymbolVerified(ctx, testTarget, testSender, "MAIN.fValue", math.NaN(), VerifyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteSymbolVerifiedMismatch(t *testing.T) {
+	s := newTestServer(t)
+	s.setHandler(func(req ams.Packet) ams.Packet {
+		// the PLC logic resets the value
+		if w, ok := req.(*ams.WriteRequest); ok {
+			return ams.NewWriteResponse(w.Header().Sender, w.Header().Target, ams.NoError)
+		}
+		return s.respond(req)
+	})
+	c := s.dial(t)
+
+	err := c.WriteSymbolVerified(context.Background(), testTarget, testSender, "MAIN.nValue", int16(42), VerifyOptions{})
+	var merr *MismatchError
+	if !errors.As(err, &merr) {
+		t.Fatalf("got %v want *MismatchError", err)
+	}
+	verify.Values(t, "error", merr, &MismatchError{Symbol: "MAIN.nValue", Expected: int16(42), Actual: int16(0)})
+	verify.Values(t, "message", err.Error(), "MAIN.nValue is 0 after write, want 42")
+}
+
+func TestWriteSymbolVerifiedCycles(t *testing.T) {
+	var cycles uint32
+	s := newTestServer(t)
+	s.setHandler(func(req ams.Packet) ams.Packet {
+		// every read of the cycle counter advances it
+		if r, ok := req.(*ams.ReadRequest); ok {
+			if h, ok := s.symbolHandle(DefaultCycleCounter); ok && r.IndexOffset == h {
+				data := make([]byte, 4)
+				binary.LittleEndian.PutUint32(data, atomic.AddUint32(&cycles, 1))
+				return ams.NewReadResponse(r.Header().Sender, r.Header().Target, ams.NoError, data)
+			}
+		}
+		return s.respond(req)
+	})
+	c := s.dial(t)
+
+	if err := c.WriteSymbolVerified(context.Background(), testTarget, testSender, "MAIN.nValue", int16(42), VerifyOptions{Cycles: 3}); err != nil {
+		t.Fatal(err)
+	}
+	// one read before the write and three while waiting
+	verify.Values(t, "counter reads", atomic.LoadUint32(&cycles), uint32(4))
+}
@@ -0,0 +1,159 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+// Package tracing creates OpenTelemetry style spans for the requests
+// of twincat clients.
+//
+// The Tracer and Span interfaces mirror the subset of the
+// OpenTelemetry trace API which is needed for the client. This keeps
+// the module free of dependencies and an OpenTelemetry tracer can be
+// plugged in with a small adapter. The Recorder is an in-memory
+// tracer for tests.
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gotwincat/twincat"
+	"github.com/gotwincat/twincat/ams"
+)
+
+// Attribute keys of the request spans.
+const (
+	AttrCommand      = "ads.command"
+	AttrTarget       = "ads.target"
+	AttrInvokeID     = "ads.invoke_id"
+	AttrIndexGroup   = "ads.index_group"
+	AttrIndexOffset  = "ads.index_offset"
+	AttrRequestSize  = "ads.request.size"
+	AttrResponseSize = "ads.response.size"
+	AttrErrorCode    = "ads.error_code"
+	AttrResult       = "ads.result"
+)
+
+// Attribute is a key/value pair of a span.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Span is a single traced operation.
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Tracer creates spans.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Instrumentation implements twincat.Instrumentation and creates
+// a span for every request. The span name is "ads." followed by
+// the command name, e.g. "ads.Read".
+type Instrumentation struct {
+	twincat.NopInstrumentation
+	tracer Tracer
+}
+
+// New returns an instrumentation which creates spans with t.
+func New(t Tracer) *Instrumentation {
+	return &Instrumentation{tracer: t}
+}
+
+type spanKey struct{}
+
+// RequestStart implements twincat.Instrumentation.
+func (in *Instrumentation) RequestStart(ctx context.Context, info twincat.RequestInfo) context.Context {
+	ctx, span := in.tracer.Start(ctx, "ads."+ams.CmdName(info.Cmd),
+		Attribute{AttrCommand, ams.CmdName(info.Cmd)},
+		Attribute{AttrTarget, info.Target.String()},
+		Attribute{AttrInvokeID, int64(info.InvokeID)},
+		Attribute{AttrIndexGroup, int64(info.IndexGroup)},
+		Attribute{AttrIndexOffset, int64(info.IndexOffset)},
+		Attribute{AttrRequestSize, int64(info.Bytes)},
+	)
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// RequestEnd implements twincat.Instrumentation.
+func (in *Instrumentation) RequestEnd(ctx context.Context, info twincat.RequestInfo, res twincat.RequestResult) {
+	span, ok := ctx.Value(spanKey{}).(Span)
+	if !ok {
+		return
+	}
+	span.SetAttributes(
+		Attribute{AttrResponseSize, int64(res.Bytes)},
+		Attribute{AttrErrorCode, int64(res.ErrorCode)},
+		Attribute{AttrResult, int64(res.Result)},
+	)
+	if res.Err != nil {
+		span.RecordError(res.Err)
+	}
+	span.End()
+}
+
+// RecordedSpan is a span recorded by the Recorder.
+type RecordedSpan struct {
+	Name       string
+	Attributes map[string]interface{}
+	Errors     []error
+	Start      time.Time
+	End        time.Time
+}
+
+// Recorder is an in-memory Tracer which records all ended spans.
+type Recorder struct {
+	mu    sync.Mutex
+	spans []RecordedSpan
+}
+
+// Start implements Tracer.
+func (r *Recorder) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	s := &recorderSpan{r: r, s: RecordedSpan{Name: name, Attributes: map[string]interface{}{}, Start: time.Now()}}
+	s.SetAttributes(attrs...)
+	return ctx, s
+}
+
+// Spans returns the ended spans in the order they ended.
+func (r *Recorder) Spans() []RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedSpan(nil), r.spans...)
+}
+
+type recorderSpan struct {
+	r *Recorder
+
+	mu sync.Mutex
+	s  RecordedSpan
+}
+
+func (s *recorderSpan) SetAttributes(attrs ...Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range attrs {
+		s.s.Attributes[a.Key] = a.Value
+	}
+}
+
+func (s *recorderSpan) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.s.Errors = append(s.s.Errors, err)
+}
+
+func (s *recorderSpan) End() {
+	s.mu.Lock()
+	s.s.End = time.Now()
+	rs := s.s
+	s.mu.Unlock()
+
+	s.r.mu.Lock()
+	s.r.spans = append(s.r.spans, rs)
+	s.r.mu.Unlock()
+}
@@ -0,0 +1,53 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package tracing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat"
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestInstrumentation(t *testing.T) {
+	var r Recorder
+	in := New(&r)
+
+	info := twincat.RequestInfo{
+		Cmd:         ams.CmdADSWrite,
+		Target:      ams.MustParseAddr("1.2.3.4.5.6:851"),
+		InvokeID:    7,
+		IndexGroup:  0x4020,
+		IndexOffset: 0x10,
+		Bytes:       52,
+	}
+	ctx := in.RequestStart(context.Background(), info)
+	in.RequestEnd(ctx, info, twincat.RequestResult{Bytes: 42, Latency: time.Millisecond, Err: twincat.ErrTimeout})
+
+	spans := r.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans want 1", len(spans))
+	}
+	s := spans[0]
+	verify.Values(t, "name", s.Name, "ads.Write")
+	verify.Values(t, "attributes", s.Attributes, map[string]interface{}{
+		AttrCommand:      "Write",
+		AttrTarget:       "1.2.3.4.5.6:851",
+		AttrInvokeID:     int64(7),
+		AttrIndexGroup:   int64(0x4020),
+		AttrIndexOffset:  int64(0x10),
+		AttrRequestSize:  int64(52),
+		AttrResponseSize: int64(42),
+		AttrErrorCode:    int64(0),
+		AttrResult:       int64(0),
+	})
+	verify.Values(t, "errors", s.Errors, []error{twincat.ErrTimeout})
+	if s.End.Before(s.Start) {
+		t.Fatalf("span ended before it started")
+	}
+}
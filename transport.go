@@ -0,0 +1,126 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// Dialer creates the connection of a client. The connection carries
+// AMS/TCP frames in both directions. Transports which use a different
+// framing, like UDP, convert the frames in the returned connection.
+//
+// DialContext is called by Client.Dial with the address of the client
+// and is called again on every reconnect.
+type Dialer interface {
+	DialContext(ctx context.Context, addr string) (net.Conn, error)
+}
+
+// DialerFunc is an adapter to use an ordinary function as Dialer.
+type DialerFunc func(ctx context.Context, addr string) (net.Conn, error)
+
+// DialContext calls f(ctx, addr).
+func (f DialerFunc) DialContext(ctx context.Context, addr string) (net.Conn, error) {
+	return f(ctx, addr)
+}
+
+// TCPDialer connects to a TwinCAT router via TCP. It is the default
+// dialer of the client.
+type TCPDialer struct {
+	// Dialer is used to dial the connection. If nil, a zero
+	// net.Dialer is used.
+	Dialer *net.Dialer
+}
+
+// DialContext implements Dialer.
+func (d *TCPDialer) DialContext(ctx context.Context, addr string) (net.Conn, error) {
+	nd := d.Dialer
+	if nd == nil {
+		nd = &net.Dialer{}
+	}
+	return nd.DialContext(ctx, "tcp", addr)
+}
+
+// ErrConnUsed is returned by the dialer of ConnDialer when the
+// connection has already been used.
+var ErrConnUsed = errors.New("connection already used")
+
+// ConnDialer returns a dialer which returns conn on the first call
+// and ErrConnUsed afterwards. It uses an existing connection, e.g.
+// one which is tunneled over SSH or a serial bridge. The address
+// of the client is ignored.
+func ConnDialer(conn net.Conn) Dialer {
+	var mu sync.Mutex
+	return DialerFunc(func(context.Context, string) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if conn == nil {
+			return nil, ErrConnUsed
+		}
+		c := conn
+		conn = nil
+		return c, nil
+	})
+}
+
+// PipeListener is an in-memory transport. It implements net.Listener
+// for the server side and Dialer for the client side and connects
+// both with net.Pipe. The address of the client is ignored.
+type PipeListener struct {
+	once   sync.Once
+	conns  chan net.Conn
+	closed chan struct{}
+}
+
+// NewPipeListener returns a new in-memory transport.
+func NewPipeListener() *PipeListener {
+	return &PipeListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// DialContext implements Dialer. It blocks until the connection
+// is accepted.
+func (l *PipeListener) DialContext(ctx context.Context, _ string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Accept implements net.Listener.
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener.
+func (l *PipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+// Addr implements net.Listener.
+func (l *PipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
@@ -0,0 +1,79 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestPipeListener(t *testing.T) {
+	l := NewPipeListener()
+	s := newTestServer(t, func(s *testServer) { s.l = l })
+	c := s.dial(t, func(c *Client) { c.Dialer = l })
+
+	ctx := context.Background()
+	if _, err := c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, 0x4020, 0, []byte{1, 2})); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Read(ctx, ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "data", resp.Data, []byte{1, 2})
+
+	l.Close()
+	if _, err := l.DialContext(ctx, ""); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("got %v want %v", err, net.ErrClosed)
+	}
+}
+
+func TestConnDialer(t *testing.T) {
+	l := NewPipeListener()
+	s := newTestServer(t, func(s *testServer) { s.l = l })
+
+	ctx := context.Background()
+	conn, err := l.DialContext(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := ConnDialer(conn)
+	c := s.dial(t, func(c *Client) { c.Dialer = d })
+
+	resp, err := c.Read(ctx, ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "data", resp.Data, []byte{0})
+
+	if _, err := d.DialContext(ctx, ""); err != ErrConnUsed {
+		t.Fatalf("got %v want %v", err, ErrConnUsed)
+	}
+}
+
+func TestSecureADSTransport(t *testing.T) {
+	cert := newCert(t, "plc", false, nil)
+	sum := sha256.Sum256(cert.Certificate[0])
+
+	l := NewPipeListener()
+	info := make(chan *secureConnectInfo, 1)
+	s := newTestServer(t, func(s *testServer) { s.l = l }, secureServer(&tls.Config{Certificates: []tls.Certificate{cert}}, secureOK, info))
+	c := s.dial(t, func(c *Client) {
+		c.Addr = "127.0.0.1:8016" // for the server name
+		c.Dialer = &SecureADS{Transport: l, Fingerprints: []string{hex.EncodeToString(sum[:])}}
+	})
+
+	if _, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,486 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// ADS data type ids of SymbolInfo.DataType and TypeInfo.DataType.
+const (
+	DataTypeVoid    = 0
+	DataTypeInt16   = 2
+	DataTypeInt32   = 3
+	DataTypeReal32  = 4
+	DataTypeReal64  = 5
+	DataTypeInt8    = 16
+	DataTypeUint8   = 17
+	DataTypeUint16  = 18
+	DataTypeUint32  = 19
+	DataTypeInt64   = 20
+	DataTypeUint64  = 21
+	DataTypeString  = 30
+	DataTypeWString = 31
+	DataTypeReal80  = 32
+	DataTypeBit     = 33
+	DataTypeBig     = 65
+)
+
+// Flags of TypeInfo.Flags.
+const (
+	TypeFlagDataType    = 0x1
+	TypeFlagDataItem    = 0x2
+	TypeFlagReferenceTo = 0x4
+	TypeFlagMethodDeref = 0x8
+	TypeFlagBitValues   = 0x20
+	TypeFlagPropItem    = 0x40
+	TypeFlagTypeGUID    = 0x80
+	TypeFlagPersistent  = 0x100
+	TypeFlagCopyMask    = 0x200
+	TypeFlagMethodInfos = 0x800
+	TypeFlagAttributes  = 0x1000
+	TypeFlagEnumInfos   = 0x2000
+)
+
+// SymbolInfo describes a symbol of the symbol upload.
+type SymbolInfo struct {
+	Name        string
+	Type        string
+	Comment     string
+	IndexGroup  uint32
+	IndexOffset uint32
+	Size        uint32
+	DataType    uint32
+	Flags       uint32
+}
+
+// TypeInfo describes a data type of the data type upload or a member
+// of a data type.
+type TypeInfo struct {
+	// Name is the name of the type or of the member.
+	Name string
+
+	// Type is the name of the base type of the type, the element
+	// type of an array or the type of a member.
+	Type string
+
+	Comment  string
+	Size     uint32
+	Offset   uint32 // offset of a member in its type
+	DataType uint32
+	Flags    uint32
+
+	// ArrayDims contains the dimensions of an array type.
+	ArrayDims []ArrayDim
+
+	// Members contains the members of a struct or function block.
+	Members []*TypeInfo
+
+	Attributes []Attribute
+
+	// Enum contains the values of an enum type.
+	Enum []EnumValue
+
+	// Methods contains the methods of a function block which are
+	// enabled for RPC calls.
+	Methods []*MethodInfo
+}
+
+// ArrayDim is a dimension of an array.
+type ArrayDim struct {
+	LowerBound int32
+	Elements   uint32
+}
+
+// Flags of MethodParam.Flags.
+const (
+	MethodParamIn          = 0x1
+	MethodParamOut         = 0x2
+	MethodParamInOut       = 0x3
+	MethodParamByReference = 0x4
+)
+
+// MethodInfo describes a method of a function block.
+type MethodInfo struct {
+	Name    string
+	Comment string
+
+	// ReturnType, ReturnSize and ReturnDataType describe the return
+	// value. ReturnSize is zero for methods without a return value.
+	ReturnType     string
+	ReturnSize     uint32
+	ReturnDataType uint32
+
+	VTableIndex uint32
+	Flags       uint32
+	Params      []*MethodParam
+}
+
+// MethodParam is a parameter of a method.
+type MethodParam struct {
+	Name     string
+	Type     string
+	Comment  string
+	Size     uint32
+	DataType uint32
+	Flags    uint32
+}
+
+// IsInput returns true for VAR_INPUT and VAR_IN_OUT parameters.
+func (p *MethodParam) IsInput() bool {
+	return p.Flags&MethodParamIn != 0
+}
+
+// IsOutput returns true for VAR_OUTPUT and VAR_IN_OUT parameters.
+func (p *MethodParam) IsOutput() bool {
+	return p.Flags&MethodParamOut != 0
+}
+
+// Method returns the method with the name or nil. Names are compared
+// case-insensitive.
+func (t *TypeInfo) Method(name string) *MethodInfo {
+	for _, m := range t.Methods {
+		if strings.EqualFold(m.Name, name) {
+			return m
+		}
+	}
+	return nil
+}
+
+// EnumValue is a named value of an enum type.
+type EnumValue struct {
+	Name  string
+	Value int64
+}
+
+// Attribute is a pragma attribute of a type.
+type Attribute struct {
+	Name  string
+	Value string
+}
+
+// member returns the member with the name or nil. Names are compared
+// case-insensitive.
+func (t *TypeInfo) member(name string) *TypeInfo {
+	for _, m := range t.Members {
+		if strings.EqualFold(m.Name, name) {
+			return m
+		}
+	}
+	return nil
+}
+
+// SymbolTable contains the uploaded symbols and data types of a
+// target.
+type SymbolTable struct {
+	Symbols []*SymbolInfo
+	Types   []*TypeInfo
+
+	symbols map[string]*SymbolInfo
+	types   map[string]*TypeInfo
+}
+
+// NewSymbolTable returns a symbol table for the symbols and types.
+func NewSymbolTable(symbols []*SymbolInfo, types []*TypeInfo) *SymbolTable {
+	t := &SymbolTable{
+		Symbols: symbols,
+		Types:   types,
+		symbols: map[string]*SymbolInfo{},
+		types:   map[string]*TypeInfo{},
+	}
+	for _, s := range symbols {
+		t.symbols[strings.ToLower(s.Name)] = s
+	}
+	for _, typ := range types {
+		t.types[typeKey(typ.Name)] = typ
+	}
+	return t
+}
+
+// typeKey normalizes a type name since the spaces in names like
+// "ARRAY [0..9] OF INT" differ between the symbols and the types.
+func typeKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), ""))
+}
+
+// Symbol returns the symbol with the name or nil. Names are compared
+// case-insensitive.
+func (t *SymbolTable) Symbol(name string) *SymbolInfo {
+	return t.symbols[strings.ToLower(name)]
+}
+
+// Type returns the data type with the name or nil. Names are compared
+// case-insensitive.
+func (t *SymbolTable) Type(name string) *TypeInfo {
+	return t.types[typeKey(name)]
+}
+
+// UploadSymbols uploads the symbols and data types of the target.
+func (c *Client) UploadSymbols(ctx context.Context, target, sender ams.Addr) (*SymbolTable, error) {
+	read := func(group, n uint32) ([]byte, error) {
+		r, err := c.Read(ctx, ams.NewReadRequest(target, sender, group, 0, n))
+		if err != nil {
+			return nil, err
+		}
+		if r.Result != ams.NoError {
+			return nil, &ADSError{Code: r.Result}
+		}
+		return r.Data, nil
+	}
+
+	info, err := read(ams.IdxSymUploadInfo2, 24)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload info: %w", err)
+	}
+	// only the sizes of the uploads are used and older targets
+	// may leave out the fields after them
+	if len(info) < 16 {
+		return nil, fmt.Errorf("failed to read upload info: got %d bytes want at least 16", len(info))
+	}
+	symSize := binary.LittleEndian.Uint32(info[4:])
+	typeSize := binary.LittleEndian.Uint32(info[12:])
+
+	b, err := read(ams.IdxSymUpload, symSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload symbols: %w", err)
+	}
+	symbols, err := DecodeSymbols(b)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err = read(ams.IdxDataTypeUpload, typeSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload data types: %w", err)
+	}
+	types, err := DecodeDataTypes(b)
+	if err != nil {
+		return nil, err
+	}
+	return NewSymbolTable(symbols, types), nil
+}
+
+// entries splits the data of a symbol or data type upload into the
+// entries which start with their length.
+func entries(b []byte) ([][]byte, error) {
+	var list [][]byte
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("truncated entry")
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if n < 4 || int(n) > len(b) {
+			return nil, fmt.Errorf("invalid entry length %d", n)
+		}
+		list = append(list, b[:n])
+		b = b[n:]
+	}
+	return list, nil
+}
+
+// readString reads a string of length n and its terminating zero.
+func readString(b *ams.Buffer, n int) string {
+	s := b.ReadN(n + 1)
+	if len(s) == 0 {
+		return ""
+	}
+	return string(s[:n])
+}
+
+// DecodeSymbols decodes the data of a symbol upload.
+func DecodeSymbols(data []byte) ([]*SymbolInfo, error) {
+	list, err := entries(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid symbol upload: %w", err)
+	}
+	var symbols []*SymbolInfo
+	for _, e := range list {
+		b := ams.NewBuffer(e)
+		b.ReadUint32() // entry length
+		s := &SymbolInfo{
+			IndexGroup:  b.ReadUint32(),
+			IndexOffset: b.ReadUint32(),
+			Size:        b.ReadUint32(),
+			DataType:    b.ReadUint32(),
+			Flags:       b.ReadUint32(),
+		}
+		nameLen, typeLen, commentLen := b.ReadUint16(), b.ReadUint16(), b.ReadUint16()
+		s.Name = readString(b, int(nameLen))
+		s.Type = readString(b, int(typeLen))
+		s.Comment = readString(b, int(commentLen))
+		if err := b.Err(); err != nil {
+			return nil, fmt.Errorf("invalid symbol entry %d: %w", len(symbols), err)
+		}
+		symbols = append(symbols, s)
+	}
+	return symbols, nil
+}
+
+// DecodeDataTypes decodes the data of a data type upload.
+func DecodeDataTypes(data []byte) ([]*TypeInfo, error) {
+	list, err := entries(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data type upload: %w", err)
+	}
+	var types []*TypeInfo
+	for _, e := range list {
+		t, err := decodeDataType(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data type entry %d: %w", len(types), err)
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// decodeDataType decodes a data type entry and its members.
+func decodeDataType(e []byte) (*TypeInfo, error) {
+	b := ams.NewBuffer(e)
+	b.ReadUint32() // entry length
+	b.ReadUint32() // version
+	b.ReadUint32() // hash value
+	b.ReadUint32() // type hash value
+	t := &TypeInfo{
+		Size:     b.ReadUint32(),
+		Offset:   b.ReadUint32(),
+		DataType: b.ReadUint32(),
+		Flags:    b.ReadUint32(),
+	}
+	nameLen, typeLen, commentLen := b.ReadUint16(), b.ReadUint16(), b.ReadUint16()
+	dims, members := b.ReadUint16(), b.ReadUint16()
+	t.Name = readString(b, int(nameLen))
+	t.Type = readString(b, int(typeLen))
+	t.Comment = readString(b, int(commentLen))
+	for i := 0; i < int(dims); i++ {
+		t.ArrayDims = append(t.ArrayDims, ArrayDim{
+			LowerBound: int32(b.ReadUint32()),
+			Elements:   b.ReadUint32(),
+		})
+	}
+	for i := 0; i < int(members); i++ {
+		n := b.ReadUint32()
+		body := b.ReadN(int(n) - 4)
+		if err := b.Err(); err != nil {
+			return nil, fmt.Errorf("member %d: %w", i, err)
+		}
+		m := make([]byte, 4, n)
+		binary.LittleEndian.PutUint32(m, n)
+		sub, err := decodeDataType(append(m, body...))
+		if err != nil {
+			return nil, fmt.Errorf("member %d: %w", i, err)
+		}
+		t.Members = append(t.Members, sub)
+	}
+
+	// the optional parts follow in the order of their flags
+	if t.Flags&TypeFlagTypeGUID != 0 {
+		b.ReadN(16)
+	}
+	if t.Flags&TypeFlagCopyMask != 0 {
+		b.ReadN(int(t.Size))
+	}
+	if t.Flags&TypeFlagMethodInfos != 0 {
+		n := b.ReadUint16()
+		for i := 0; i < int(n) && b.Err() == nil; i++ {
+			entryLen := b.ReadUint32()
+			m, err := decodeMethod(b.ReadN(int(entryLen) - 4))
+			if b.Err() == nil && err != nil {
+				return nil, fmt.Errorf("method %d: %w", i, err)
+			}
+			t.Methods = append(t.Methods, m)
+		}
+	}
+	if t.Flags&TypeFlagAttributes != 0 {
+		n := b.ReadUint16()
+		for i := 0; i < int(n); i++ {
+			nameLen, valueLen := b.ReadUint8(), b.ReadUint8()
+			t.Attributes = append(t.Attributes, Attribute{
+				Name:  readString(b, int(nameLen)),
+				Value: readString(b, int(valueLen)),
+			})
+		}
+	}
+	if t.Flags&TypeFlagEnumInfos != 0 {
+		n := b.ReadUint16()
+		for i := 0; i < int(n); i++ {
+			nameLen := b.ReadUint8()
+			name := readString(b, int(nameLen))
+			v, err := decodeInt(b.ReadN(int(t.Size)), t.DataType)
+			if b.Err() == nil && err != nil {
+				return nil, fmt.Errorf("enum %s: %w", name, err)
+			}
+			t.Enum = append(t.Enum, EnumValue{Name: name, Value: v})
+		}
+	}
+	if err := b.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// decodeMethod decodes a method entry without its length.
+func decodeMethod(e []byte) (*MethodInfo, error) {
+	b := ams.NewBuffer(e)
+	b.ReadUint32() // version
+	m := &MethodInfo{VTableIndex: b.ReadUint32(), ReturnSize: b.ReadUint32()}
+	b.ReadUint32() // return align size
+	b.ReadUint32() // reserved
+	b.ReadN(16)    // return type guid
+	m.ReturnDataType = b.ReadUint32()
+	m.Flags = b.ReadUint32()
+	nameLen, typeLen, commentLen, params := b.ReadUint16(), b.ReadUint16(), b.ReadUint16(), b.ReadUint16()
+	m.Name = readString(b, int(nameLen))
+	m.ReturnType = readString(b, int(typeLen))
+	m.Comment = readString(b, int(commentLen))
+	for i := 0; i < int(params) && b.Err() == nil; i++ {
+		n := b.ReadUint32()
+		p := ams.NewBuffer(b.ReadN(int(n) - 4))
+		param := &MethodParam{Size: p.ReadUint32()}
+		p.ReadUint32() // align size
+		param.DataType = p.ReadUint32()
+		param.Flags = p.ReadUint32()
+		p.ReadUint32() // reserved
+		p.ReadN(16)    // type guid
+		p.ReadUint16() // length is parameter
+		nameLen, typeLen, commentLen := p.ReadUint16(), p.ReadUint16(), p.ReadUint16()
+		param.Name = readString(p, int(nameLen))
+		param.Type = readString(p, int(typeLen))
+		param.Comment = readString(p, int(commentLen))
+		if err := p.Err(); err != nil {
+			return nil, fmt.Errorf("parameter %d: %w", i, err)
+		}
+		m.Params = append(m.Params, param)
+	}
+	if err := b.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// decodeInt decodes an integer of an ADS data type.
+func decodeInt(b []byte, dataType uint32) (int64, error) {
+	switch {
+	case dataType == DataTypeInt8 && len(b) == 1:
+		return int64(int8(b[0])), nil
+	case dataType == DataTypeUint8 && len(b) == 1:
+		return int64(b[0]), nil
+	case dataType == DataTypeInt16 && len(b) == 2:
+		return int64(int16(binary.LittleEndian.Uint16(b))), nil
+	case dataType == DataTypeUint16 && len(b) == 2:
+		return int64(binary.LittleEndian.Uint16(b)), nil
+	case dataType == DataTypeInt32 && len(b) == 4:
+		return int64(int32(binary.LittleEndian.Uint32(b))), nil
+	case dataType == DataTypeUint32 && len(b) == 4:
+		return int64(binary.LittleEndian.Uint32(b)), nil
+	case (dataType == DataTypeInt64 || dataType == DataTypeUint64) && len(b) == 8:
+		return int64(binary.LittleEndian.Uint64(b)), nil
+	}
+	return 0, fmt.Errorf("invalid integer of data type %d and size %d", dataType, len(b))
+}
@@ -0,0 +1,343 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// withLength prepends the entry length to b.
+func withLength(b []byte) []byte {
+	e := make([]byte, 4, len(b)+4)
+	binary.LittleEndian.PutUint32(e, uint32(len(b)+4))
+	return append(e, b...)
+}
+
+func writeString(b *ams.Buffer, s string) {
+	b.Write(append([]byte(s), 0))
+}
+
+// encodeSymbols encodes the symbols like a symbol upload.
+func encodeSymbols(symbols []*SymbolInfo) []byte {
+	var data []byte
+	for _, s := range symbols {
+		var b ams.Buffer
+		b.WriteUint32(s.IndexGroup)
+		b.WriteUint32(s.IndexOffset)
+		b.WriteUint32(s.Size)
+		b.WriteUint32(s.DataType)
+		b.WriteUint32(s.Flags)
+		b.WriteUint16(uint16(len(s.Name)))
+		b.WriteUint16(uint16(len(s.Type)))
+		b.WriteUint16(uint16(len(s.Comment)))
+		writeString(&b, s.Name)
+		writeString(&b, s.Type)
+		writeString(&b, s.Comment)
+		data = append(data, withLength(b.Bytes())...)
+	}
+	return data
+}
+
+// encodeDataType encodes a data type entry like a data type upload.
+func encodeDataType(t *TypeInfo) []byte {
+	var b ams.Buffer
+	b.WriteUint32(1) // version
+	b.WriteUint32(0) // hash value
+	b.WriteUint32(0) // type hash value
+	b.WriteUint32(t.Size)
+	b.WriteUint32(t.Offset)
+	b.WriteUint32(t.DataType)
+	b.WriteUint32(t.Flags)
+	b.WriteUint16(uint16(len(t.Name)))
+	b.WriteUint16(uint16(len(t.Type)))
+	b.WriteUint16(uint16(len(t.Comment)))
+	b.WriteUint16(uint16(len(t.ArrayDims)))
+	b.WriteUint16(uint16(len(t.Members)))
+	writeString(&b, t.Name)
+	writeString(&b, t.Type)
+	writeString(&b, t.Comment)
+	for _, d := range t.ArrayDims {
+		b.WriteUint32(uint32(d.LowerBound))
+		b.WriteUint32(d.Elements)
+	}
+	for _, m := range t.Members {
+		b.Write(encodeDataType(m))
+	}
+	if t.Flags&TypeFlagTypeGUID != 0 {
+		b.Write(make([]byte, 16))
+	}
+	if t.Flags&TypeFlagMethodInfos != 0 {
+		b.WriteUint16(uint16(len(t.Methods)))
+		for _, m := range t.Methods {
+			b.Write(encodeMethod(m))
+		}
+	}
+	if t.Flags&TypeFlagAttributes != 0 {
+		b.WriteUint16(uint16(len(t.Attributes)))
+		for _, a := range t.Attributes {
+			b.WriteUint8(uint8(len(a.Name)))
+			b.WriteUint8(uint8(len(a.Value)))
+			writeString(&b, a.Name)
+			writeString(&b, a.Value)
+		}
+	}
+	if t.Flags&TypeFlagEnumInfos != 0 {
+		b.WriteUint16(uint16(len(t.Enum)))
+		for _, e := range t.Enum {
+			b.WriteUint8(uint8(len(e.Name)))
+			writeString(&b, e.Name)
+			v := make([]byte, 8)
+			binary.LittleEndian.PutUint64(v, uint64(e.Value))
+			b.Write(v[:t.Size])
+		}
+	}
+	return withLength(b.Bytes())
+}
+
+func encodeMethod(m *MethodInfo) []byte {
+	var b ams.Buffer
+	b.WriteUint32(1) // version
+	b.WriteUint32(m.VTableIndex)
+	b.WriteUint32(m.ReturnSize)
+	b.WriteUint32(m.ReturnSize) // align size
+	b.WriteUint32(0)            // reserved
+	b.Write(make([]byte, 16))
+	b.WriteUint32(m.ReturnDataType)
+	b.WriteUint32(m.Flags)
+	b.WriteUint16(uint16(len(m.Name)))
+	b.WriteUint16(uint16(len(m.ReturnType)))
+	b.WriteUint16(uint16(len(m.Comment)))
+	b.WriteUint16(uint16(len(m.Params)))
+	writeString(&b, m.Name)
+	writeString(&b, m.ReturnType)
+	writeString(&b, m.Comment)
+	for _, p := range m.Params {
+		var pb ams.Buffer
+		pb.WriteUint32(p.Size)
+		pb.WriteUint32(p.Size) // align size
+		pb.WriteUint32(p.DataType)
+		pb.WriteUint32(p.Flags)
+		pb.WriteUint32(0) // reserved
+		pb.Write(make([]byte, 16))
+		pb.WriteUint16(0) // length is parameter
+		pb.WriteUint16(uint16(len(p.Name)))
+		pb.WriteUint16(uint16(len(p.Type)))
+		pb.WriteUint16(uint16(len(p.Comment)))
+		writeString(&pb, p.Name)
+		writeString(&pb, p.Type)
+		writeString(&pb, p.Comment)
+		b.Write(withLength(pb.Bytes()))
+	}
+	return withLength(b.Bytes())
+}
+
+func encodeDataTypes(types []*TypeInfo) []byte {
+	var data []byte
+	for _, t := range types {
+		data = append(data, encodeDataType(t)...)
+	}
+	return data
+}
+
+// serveSymbols makes the symbols and types available for upload on
+// the test server.
+func (s *testServer) serveSymbols(symbols []*SymbolInfo, types []*TypeInfo) {
+	syms, typs := encodeSymbols(symbols), encodeDataTypes(types)
+	var info ams.Buffer
+	info.WriteUint32(uint32(len(symbols)))
+	info.WriteUint32(uint32(len(syms)))
+	info.WriteUint32(uint32(len(types)))
+	info.WriteUint32(uint32(len(typs)))
+	info.WriteUint32(0)
+	info.WriteUint32(0)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mem[ams.IdxSymUploadInfo2] = info.Bytes()
+	s.mem[ams.IdxSymUpload] = syms
+	s.mem[ams.IdxDataTypeUpload] = typs
+}
+
+// testSymbols returns the symbols and types of a test PLC program.
+func testSymbols() ([]*SymbolInfo, []*TypeInfo) {
+	symbols := []*SymbolInfo{
+		{Name: "MAIN.nValue", Type: "INT", IndexGroup: 0x4020, IndexOffset: 0, Size: 2, DataType: DataTypeInt16},
+		{Name: "MAIN.arr", Type: "ARRAY [1..10] OF ST_Point", IndexGroup: 0x4020, IndexOffset: 100, Size: 80},
+		{Name: "MAIN.grid", Type: "ARRAY [-1..1,0..3] OF INT", IndexGroup: 0x4020, IndexOffset: 200, Size: 24},
+		{Name: "MAIN.pPoint", Type: "POINTER TO ST_Point", IndexGroup: 0x4020, IndexOffset: 300, Size: 8, DataType: DataTypeUint64},
+		{Name: "MAIN.refPoint", Type: "REFERENCE TO ST_Point", IndexGroup: 0x4020, IndexOffset: 308, Size: 8, DataType: DataTypeUint64},
+		{Name: "MAIN.fb", Type: "FB_Motor", IndexGroup: 0x4020, IndexOffset: 400, Size: 24},
+		{Name: "GVL.sName", Type: "STRING(20)", IndexGroup: 0x4040, IndexOffset: 0, Size: 21, DataType: DataTypeString},
+		{Name: "GVL.wsName", Type: "WSTRING(3)", IndexGroup: 0x4040, IndexOffset: 22, Size: 8, DataType: DataTypeWString},
+	}
+	types := []*TypeInfo{
+		{
+			Name: "ST_Point", Size: 8, DataType: DataTypeBig, Flags: TypeFlagDataType,
+			Members: []*TypeInfo{
+				{Name: "x", Type: "DINT", Size: 4, Offset: 0, DataType: DataTypeInt32, Flags: TypeFlagDataItem},
+				{Name: "y", Type: "DINT", Size: 4, Offset: 4, DataType: DataTypeInt32, Flags: TypeFlagDataItem},
+			},
+		},
+		{
+			Name: "ARRAY [1..10] OF ST_Point", Type: "ST_Point", Size: 80, DataType: DataTypeBig, Flags: TypeFlagDataType,
+			ArrayDims: []ArrayDim{{LowerBound: 1, Elements: 10}},
+		},
+		{
+			Name: "FB_Motor", Size: 24, DataType: DataTypeBig, Flags: TypeFlagDataType | TypeFlagMethodInfos | TypeFlagAttributes,
+			Members: []*TypeInfo{
+				{Name: "bOn", Type: "BOOL", Size: 1, Offset: 0, DataType: DataTypeBit, Flags: TypeFlagDataItem},
+				{Name: "eState", Type: "E_State", Size: 2, Offset: 2, DataType: DataTypeInt16, Flags: TypeFlagDataItem},
+				{Name: "aSpeeds", Type: "ARRAY [0..1] OF LREAL", Size: 16, Offset: 8, DataType: DataTypeReal64, Flags: TypeFlagDataItem},
+			},
+			Methods: []*MethodInfo{
+				{
+					Name: "Start", Comment: "starts the motor", ReturnType: "BOOL", ReturnSize: 1, ReturnDataType: DataTypeBit,
+					Params: []*MethodParam{
+						{Name: "fSpeed", Type: "LREAL", Size: 8, DataType: DataTypeReal64, Flags: MethodParamIn},
+						{Name: "nRamp", Type: "UINT", Size: 2, DataType: DataTypeUint16, Flags: MethodParamIn},
+						{Name: "eState", Type: "E_State", Size: 2, DataType: DataTypeInt16, Flags: MethodParamOut},
+					},
+				},
+				{Name: "Reset", VTableIndex: 1},
+			},
+			Attributes: []Attribute{{Name: "reflection", Value: ""}},
+		},
+		{
+			Name: "E_State", Type: "INT", Size: 2, DataType: DataTypeInt16, Flags: TypeFlagDataType | TypeFlagEnumInfos,
+			Enum: []EnumValue{{Name: "Idle", Value: 0}, {Name: "Running", Value: 1}, {Name: "Fault", Value: -1}},
+		},
+	}
+	return symbols, types
+}
+
+func TestUploadSymbols(t *testing.T) {
+	symbols, types := testSymbols()
+	s := newTestServer(t)
+	s.serveSymbols(symbols, types)
+	c := s.dial(t)
+
+	table, err := c.UploadSymbols(context.Background(), testTarget, testSender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "symbols", table.Symbols, symbols)
+	verify.Values(t, "types", table.Types, types)
+	verify.Values(t, "symbol", table.Symbol("main.NVALUE"), symbols[0])
+	verify.Values(t, "type", table.Type("array[1..10] of st_point"), types[1])
+}
+
+func TestUploadSymbolsShortInfo(t *testing.T) {
+	symbols, types := testSymbols()
+	s := newTestServer(t)
+	s.serveSymbols(symbols, types)
+	n := 16
+	s.setHandler(func(req ams.Packet) ams.Packet {
+		r, ok := req.(*ams.ReadRequest)
+		if !ok || r.IndexGroup != ams.IdxSymUploadInfo2 {
+			return s.respond(req)
+		}
+		s.mu.Lock()
+		info := s.read(r.IndexGroup, 0, uint32(n))
+		s.mu.Unlock()
+		return ams.NewReadResponse(r.Header().Sender, r.Header().Target, ams.NoError, info)
+	})
+	c := s.dial(t)
+	ctx := context.Background()
+
+	table, err := c.UploadSymbols(ctx, testTarget, testSender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "symbols", table.Symbols, symbols)
+
+	s.mu.Lock()
+	n = 12
+	s.mu.Unlock()
+	_, err = c.UploadSymbols(ctx, testTarget, testSender)
+	if err == nil || err.Error() != "failed to read upload info: got 12 bytes want at least 16" {
+		t.Errorf("got %v want short upload info", err)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	symbols, types := testSymbols()
+	syms, typs := encodeSymbols(symbols), encodeDataTypes(types)
+	if _, err := DecodeSymbols(syms[:len(syms)-1]); err == nil {
+		t.Error("truncated symbols decoded")
+	}
+	if _, err := DecodeDataTypes(typs[:len(typs)-1]); err == nil {
+		t.Error("truncated types decoded")
+	}
+	// a member is longer than its type entry
+	b := encodeDataType(types[0])
+	binary.LittleEndian.PutUint32(b[42+len("ST_Point")+3:], 1000)
+	if _, err := DecodeDataTypes(b); err == nil {
+		t.Error("invalid member decoded")
+	}
+}
+
+// methodEntry is a method entry of a data type upload with a length
+// prefix. No capture of a real TwinCAT upload is at hand, so it was
+// built by hand from the documented layout of AdsMethodEntry and
+// AdsMethodParaEntry. Unlike encodeMethod it has GUIDs and pads the
+// entries to four bytes.
+var methodEntry = []byte{
+	0x88, 0x00, 0x00, 0x00, // entry length 136
+	0x01, 0x00, 0x00, 0x00, // version
+	0x0B, 0x00, 0x00, 0x00, // vtable index
+	0x01, 0x00, 0x00, 0x00, // return size
+	0x01, 0x00, 0x00, 0x00, // return align size
+	0x00, 0x00, 0x00, 0x00, // reserved
+	0x5C, 0x86, 0x3F, 0x95, 0xA1, 0x4B, 0x7C, 0x43, // return type guid
+	0x8D, 0x5E, 0x31, 0x0A, 0x3E, 0x6B, 0x2F, 0x90,
+	0x21, 0x00, 0x00, 0x00, // return data type BIT
+	0x01, 0x00, 0x00, 0x00, // flags PLC calling convention
+	0x06, 0x00, // name length
+	0x04, 0x00, // return type length
+	0x00, 0x00, // comment length
+	0x01, 0x00, // parameters
+	'M', '_', 'M', 'o', 'v', 'e', 0x00,
+	'B', 'O', 'O', 'L', 0x00,
+	0x00,
+
+	0x40, 0x00, 0x00, 0x00, // parameter entry length 64
+	0x08, 0x00, 0x00, 0x00, // size
+	0x08, 0x00, 0x00, 0x00, // align size
+	0x05, 0x00, 0x00, 0x00, // data type REAL64
+	0x01, 0x00, 0x00, 0x00, // flags input
+	0x00, 0x00, 0x00, 0x00, // reserved
+	0x1B, 0x0E, 0x59, 0x2D, 0x7E, 0xC3, 0x46, 0x4F, // type guid
+	0x9A, 0x11, 0x66, 0xB0, 0x2C, 0x85, 0xD4, 0x73,
+	0x00, 0x00, // length is parameter
+	0x04, 0x00, // name length
+	0x05, 0x00, // type length
+	0x00, 0x00, // comment length
+	'f', 'P', 'o', 's', 0x00,
+	'L', 'R', 'E', 'A', 'L', 0x00,
+	0x00,
+	0x00, 0x00, 0x00, 0x00, // padding
+
+	0x00, 0x00, 0x00, // padding
+}
+
+func TestDecodeMethod(t *testing.T) {
+	n := binary.LittleEndian.Uint32(methodEntry)
+	verify.Values(t, "entry length", int(n), len(methodEntry))
+	m, err := decodeMethod(methodEntry[4:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "method", m, &MethodInfo{
+		Name: "M_Move", ReturnType: "BOOL", ReturnSize: 1, ReturnDataType: DataTypeBit, VTableIndex: 11, Flags: 1,
+		Params: []*MethodParam{
+			{Name: "fPos", Type: "LREAL", Size: 8, DataType: DataTypeReal64, Flags: MethodParamIn},
+		},
+	})
+}
@@ -0,0 +1,234 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+)
+
+// ErrFrameTooLarge is returned when a frame does not fit into
+// a single datagram.
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// UDPDialer is a Dialer for ADS over UDP. AMS packets are sent as single
+// datagrams without the AMS/TCP header and with the StateUDPCommand
+// flag set.
+//
+// UDP does not guarantee delivery. Requests without a response are
+// sent again until a response arrives or the retransmits are used
+// up. The request timeout of the client still applies.
+type UDPDialer struct {
+	// RetransmitInterval is the time after which a request without
+	// a response is sent again. If zero, 200ms is used.
+	RetransmitInterval time.Duration
+
+	// MaxRetransmits is the number of times a request is sent again.
+	// If zero, 3 is used. Use a negative value to disable
+	// retransmits.
+	MaxRetransmits int
+
+	// MaxFrameLen is the maximum length of a datagram. Larger
+	// requests fail with ErrFrameTooLarge. If zero, 1472 bytes
+	// are used which fit into a single ethernet frame.
+	MaxFrameLen int
+}
+
+// DialContext implements Dialer. Addr usually uses port 48899.
+func (d *UDPDialer) DialContext(ctx context.Context, addr string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return newUDPConn(conn, d), nil
+}
+
+// maxDatagramLen is the maximum payload of a UDP datagram.
+const maxDatagramLen = 65507
+
+// tcpHeaderLen is the length of the AMS/TCP header which is
+// not sent with UDP.
+const tcpHeaderLen = ams.HeaderLen - 32
+
+// offsets in an AMS/TCP frame
+const (
+	stateFlagsOffset = tcpHeaderLen + 18
+	invokeIDOffset   = tcpHeaderLen + 28
+)
+
+// udpConn converts between the AMS/TCP frames of the client and
+// AMS/UDP datagrams and retransmits requests.
+type udpConn struct {
+	net.Conn
+
+	interval       time.Duration
+	maxRetransmits int
+	maxFrameLen    int
+
+	// buf holds the rest of the last datagram which
+	// has not been read yet.
+	buf []byte
+
+	mu      sync.Mutex
+	pending map[uint32]*udpRequest
+	closed  bool
+}
+
+// udpRequest is a request which is sent again until a response
+// arrives or the retransmits are used up.
+type udpRequest struct {
+	d     []byte
+	left  int
+	timer *time.Timer
+}
+
+func newUDPConn(conn net.Conn, d *UDPDialer) *udpConn {
+	c := &udpConn{
+		Conn:           conn,
+		interval:       d.RetransmitInterval,
+		maxRetransmits: d.MaxRetransmits,
+		maxFrameLen:    d.MaxFrameLen,
+		pending:        map[uint32]*udpRequest{},
+	}
+	if c.interval == 0 {
+		c.interval = 200 * time.Millisecond
+	}
+	if c.maxRetransmits == 0 {
+		c.maxRetransmits = 3
+	}
+	if c.maxFrameLen == 0 {
+		c.maxFrameLen = 1472
+	}
+	if c.maxFrameLen > maxDatagramLen {
+		c.maxFrameLen = maxDatagramLen
+	}
+	return c
+}
+
+// Write sends a single AMS/TCP frame as datagram.
+func (c *udpConn) Write(b []byte) (int, error) {
+	if len(b) < ams.HeaderLen {
+		return 0, fmt.Errorf("udp: short frame: %d bytes", len(b))
+	}
+	if n := len(b) - tcpHeaderLen; n > c.maxFrameLen {
+		return 0, fmt.Errorf("%w: %d bytes exceed %d bytes", ErrFrameTooLarge, n, c.maxFrameLen)
+	}
+
+	d := make([]byte, len(b)-tcpHeaderLen)
+	copy(d, b[tcpHeaderLen:])
+	flags := binary.LittleEndian.Uint16(b[stateFlagsOffset:]) | ams.StateUDPCommand
+	binary.LittleEndian.PutUint16(d[stateFlagsOffset-tcpHeaderLen:], flags)
+
+	// register the retransmit before the datagram is sent so that
+	// an early response finds and stops it.
+	invokeID := binary.LittleEndian.Uint32(b[invokeIDOffset:])
+	retransmit := flags&ams.StateResponse == 0 && c.maxRetransmits > 0
+	if retransmit {
+		c.retransmit(invokeID, d, c.maxRetransmits)
+	}
+	if _, err := c.Conn.Write(d); err != nil {
+		if retransmit {
+			c.cancel(invokeID)
+		}
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// cancel stops the retransmits of a request. It is called by the
+// client for requests which were canceled or timed out.
+func (c *udpConn) cancel(invokeID uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r := c.pending[invokeID]; r != nil {
+		r.timer.Stop()
+		delete(c.pending, invokeID)
+	}
+}
+
+// retransmit sends the datagram again after the retransmit
+// interval unless a response has arrived.
+func (c *udpConn) retransmit(invokeID uint32, d []byte, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	r := &udpRequest{d: d, left: n}
+	r.timer = time.AfterFunc(c.interval, func() { c.resend(invokeID, r) })
+	c.pending[invokeID] = r
+}
+
+// resend sends the datagram of a request again. The request stays
+// pending during the write so that a response which arrives
+// meanwhile stops the retransmits.
+func (c *udpConn) resend(invokeID uint32, r *udpRequest) {
+	c.mu.Lock()
+	ok := c.pending[invokeID] == r
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	_, err := c.Conn.Write(r.d)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending[invokeID] != r {
+		return
+	}
+	r.left--
+	if err != nil || r.left == 0 {
+		delete(c.pending, invokeID)
+		return
+	}
+	r.timer.Reset(c.interval)
+}
+
+// Read returns the next datagram as AMS/TCP frame.
+func (c *udpConn) Read(b []byte) (int, error) {
+	for len(c.buf) == 0 {
+		d := make([]byte, maxDatagramLen)
+		n, err := c.Conn.Read(d)
+		if err != nil {
+			return 0, err
+		}
+		if n < ams.HeaderLen-tcpHeaderLen {
+			// ignore datagrams without a complete AMS header
+			continue
+		}
+		d = d[:n]
+
+		if binary.LittleEndian.Uint16(d[stateFlagsOffset-tcpHeaderLen:])&ams.StateResponse != 0 {
+			c.cancel(binary.LittleEndian.Uint32(d[invokeIDOffset-tcpHeaderLen:]))
+		}
+
+		c.buf = make([]byte, tcpHeaderLen+n)
+		binary.LittleEndian.PutUint32(c.buf[2:], uint32(n))
+		copy(c.buf[tcpHeaderLen:], d)
+	}
+
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Close stops all retransmits and closes the connection.
+func (c *udpConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	for id, r := range c.pending {
+		r.timer.Stop()
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
@@ -0,0 +1,239 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gotwincat/twincat/ams"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+// udpServer answers AMS/UDP datagrams with the responses of a
+// test server.
+type udpServer struct {
+	s    *testServer
+	conn net.PacketConn
+
+	mu sync.Mutex
+	// drop returns true if the n-th request is dropped.
+	drop  func(n int) bool
+	n     int
+	flags []uint16
+}
+
+func newUDPServer(t *testing.T, drop func(n int) bool) *udpServer {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &udpServer{s: newTestServer(t), conn: conn, drop: drop}
+	go u.serve()
+	t.Cleanup(func() { conn.Close() })
+	return u
+}
+
+func (u *udpServer) dial(t *testing.T, d *UDPDialer) *Client {
+	t.Helper()
+	c := &Client{Addr: u.conn.LocalAddr().String(), ReadTimeout: time.Second, Dialer: d}
+	if err := c.Dial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func (u *udpServer) serve() {
+	d := make([]byte, maxDatagramLen)
+	for {
+		n, addr, err := u.conn.ReadFrom(d)
+		if err != nil {
+			return
+		}
+		frame := make([]byte, tcpHeaderLen+n)
+		binary.LittleEndian.PutUint32(frame[2:], uint32(n))
+		copy(frame[tcpHeaderLen:], d[:n])
+		req, err := ams.Decode(frame)
+		if err != nil {
+			u.s.t.Errorf("server: decode: %s", err)
+			return
+		}
+
+		u.mu.Lock()
+		u.n++
+		u.flags = append(u.flags, req.Header().StateFlags)
+		drop := u.drop != nil && u.drop(u.n)
+		u.mu.Unlock()
+		if drop {
+			continue
+		}
+
+		resp := u.s.respond(req)
+		resp.Header().InvokeID = req.Header().InvokeID
+		resp.Header().StateFlags |= ams.StateUDPCommand
+		var b ams.Buffer
+		if err := resp.Encode(&b); err != nil {
+			u.s.t.Errorf("server: encode: %s", err)
+			return
+		}
+		if _, err := u.conn.WriteTo(b.Bytes()[tcpHeaderLen:], addr); err != nil {
+			return
+		}
+	}
+}
+
+func (u *udpServer) requests() []uint16 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]uint16(nil), u.flags...)
+}
+
+func TestUDP(t *testing.T) {
+	u := newUDPServer(t, nil)
+	c := u.dial(t, &UDPDialer{})
+
+	ctx := context.Background()
+	if _, err := c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, 0x4020, 2, []byte{1, 2, 3})); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Read(ctx, ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "data", resp.Data, []byte{0, 0, 1, 2, 3})
+
+	flags := ams.StateADSCommand | ams.StateUDPCommand
+	verify.Values(t, "flags", u.requests(), []uint16{uint16(flags), uint16(flags)})
+}
+
+func TestUDPRetransmit(t *testing.T) {
+	// drop the first two requests
+	u := newUDPServer(t, func(n int) bool { return n <= 2 })
+	c := u.dial(t, &UDPDialer{RetransmitInterval: 20 * time.Millisecond})
+
+	resp, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "data", resp.Data, []byte{0})
+	if got, want := len(u.requests()), 3; got != want {
+		t.Fatalf("got %d requests want %d", got, want)
+	}
+
+	// no more retransmits after the response
+	time.Sleep(60 * time.Millisecond)
+	if got, want := len(u.requests()), 3; got != want {
+		t.Fatalf("got %d requests want %d", got, want)
+	}
+}
+
+func TestUDPRetransmitExhausted(t *testing.T) {
+	u := newUDPServer(t, func(int) bool { return true })
+	c := u.dial(t, &UDPDialer{RetransmitInterval: 10 * time.Millisecond, MaxRetransmits: 2})
+	c.ReadTimeout = 100 * time.Millisecond
+
+	_, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1))
+	if err != ErrTimeout {
+		t.Fatalf("got %v want %v", err, ErrTimeout)
+	}
+	if got, want := len(u.requests()), 3; got != want {
+		t.Fatalf("got %d requests want %d", got, want)
+	}
+}
+
+func TestUDPRetransmitCanceled(t *testing.T) {
+	u := newUDPServer(t, func(int) bool { return true })
+	c := u.dial(t, &UDPDialer{RetransmitInterval: 20 * time.Millisecond, MaxRetransmits: 10})
+	c.ReadTimeout = 30 * time.Millisecond
+
+	_, err := c.Read(context.Background(), ams.NewReadRequest(testTarget, testSender, 0x4020, 0, 1))
+	if err != ErrTimeout {
+		t.Fatalf("got %v want %v", err, ErrTimeout)
+	}
+	n := len(u.requests())
+
+	// the timeout stops the retransmits
+	time.Sleep(100 * time.Millisecond)
+	if got := len(u.requests()); got != n {
+		t.Fatalf("got %d requests want %d", got, n)
+	}
+}
+
+// hookConn is a datagram connection which calls write with every
+// datagram and returns the datagrams of in on read.
+type hookConn struct {
+	net.Conn
+	in chan []byte
+
+	mu     sync.Mutex
+	writes int
+	write  func(n int, d []byte)
+}
+
+func (c *hookConn) Write(d []byte) (int, error) {
+	c.mu.Lock()
+	c.writes++
+	n := c.writes
+	c.mu.Unlock()
+	c.write(n, d)
+	return len(d), nil
+}
+
+func (c *hookConn) Read(b []byte) (int, error) {
+	return copy(b, <-c.in), nil
+}
+
+func TestUDPRetransmitResponse(t *testing.T) {
+	conn := &hookConn{in: make(chan []byte, 1)}
+	u := newUDPConn(conn, &UDPDialer{RetransmitInterval: 10 * time.Millisecond, MaxRetransmits: 5})
+	conn.write = func(n int, d []byte) {
+		if n != 2 {
+			return
+		}
+		// the response arrives while the request is sent again
+		resp := append([]byte(nil), d...)
+		flags := binary.LittleEndian.Uint16(resp[stateFlagsOffset-tcpHeaderLen:])
+		binary.LittleEndian.PutUint16(resp[stateFlagsOffset-tcpHeaderLen:], flags|ams.StateResponse)
+		conn.in <- resp
+		if _, err := u.Read(make([]byte, ams.HeaderLen)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	var b ams.Buffer
+	if err := ams.NewWriteRequest(testTarget, testSender, 0x4020, 0, []byte{1}).Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Write(b.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	verify.Values(t, "writes", conn.writes, 2)
+}
+
+func TestUDPFrameTooLarge(t *testing.T) {
+	u := newUDPServer(t, nil)
+	c := u.dial(t, &UDPDialer{MaxFrameLen: 100})
+
+	ctx := context.Background()
+	_, err := c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, 0x4020, 0, make([]byte, 100)))
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("got %v want %v", err, ErrFrameTooLarge)
+	}
+
+	// 32 bytes AMS header + 12 bytes write header + data
+	if _, err := c.Write(ctx, ams.NewWriteRequest(testTarget, testSender, 0x4020, 0, make([]byte, 100-44))); err != nil {
+		t.Fatal(err)
+	}
+}
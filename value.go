@@ -0,0 +1,616 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ValueError describes a value which cannot be decoded or encoded.
+// Path is the position of the value within the top level value like
+// .member[2].
+type ValueError struct {
+	Path   string
+	Type   string
+	Reason string
+}
+
+func (e *ValueError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s value: %s", e.Type, e.Reason)
+	}
+	return fmt.Sprintf("%s value at %s: %s", e.Type, e.Path, e.Reason)
+}
+
+// baseDataTypes maps the names of the IEC base types to their ADS
+// data types.
+var baseDataTypes = map[string]uint32{
+	"bool": DataTypeBit, "byte": DataTypeUint8, "usint": DataTypeUint8, "sint": DataTypeInt8,
+	"word": DataTypeUint16, "uint": DataTypeUint16, "int": DataTypeInt16,
+	"dword": DataTypeUint32, "udint": DataTypeUint32, "dint": DataTypeInt32,
+	"lword": DataTypeUint64, "ulint": DataTypeUint64, "lint": DataTypeInt64,
+	"real": DataTypeReal32, "lreal": DataTypeReal64,
+	"time": DataTypeUint32, "tod": DataTypeUint32, "time_of_day": DataTypeUint32,
+	"date": DataTypeUint32, "dt": DataTypeUint32, "date_and_time": DataTypeUint32,
+	"ltime": DataTypeUint64,
+}
+
+// dataTypeSizes are the sizes of the scalar ADS data types.
+var dataTypeSizes = map[uint32]uint32{
+	DataTypeBit: 1, DataTypeInt8: 1, DataTypeUint8: 1, DataTypeInt16: 2, DataTypeUint16: 2,
+	DataTypeInt32: 4, DataTypeUint32: 4, DataTypeInt64: 8, DataTypeUint64: 8,
+	DataTypeReal32: 4, DataTypeReal64: 8,
+}
+
+// valueType is the resolved type of a value.
+type valueType struct {
+	name     string
+	info     *TypeInfo
+	dataType uint32
+	size     uint32
+
+	dims     []ArrayDim // arrays
+	elemType string
+
+	strLen   uint32 // STRING(n) and WSTRING(n)
+	wide     bool
+	isString bool
+}
+
+// valueType resolves a type by its name. dataType and size are used
+// for types which are neither uploaded nor base types.
+func (t *SymbolTable) valueType(name string, dataType, size uint32) (*valueType, error) {
+	v := &valueType{name: name, dataType: dataType, size: size}
+	if info := t.Type(name); info != nil {
+		v.info, v.dataType, v.size = info, info.DataType, info.Size
+		switch {
+		case len(info.ArrayDims) > 0:
+			v.dims, v.elemType = info.ArrayDims, info.Type
+		case len(info.Members) > 0, len(info.Enum) > 0:
+		case info.Type != "" && !strings.EqualFold(info.Type, name):
+			// alias of another type
+			a, err := t.valueType(info.Type, info.DataType, info.Size)
+			if err != nil {
+				return nil, err
+			}
+			a.name = name
+			return a, nil
+		}
+		if err := v.checkSize(); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	if dims, elemType, ok := t.arrayType(name); ok {
+		v.dims, v.elemType = dims, elemType
+		if elemSize, ok := t.typeSize(elemType); ok {
+			v.size = elemSize
+			for _, d := range dims {
+				v.size *= d.Elements
+			}
+		}
+		return v, nil
+	}
+	key := typeKey(name)
+	if dt, ok := baseDataTypes[key]; ok {
+		v.dataType, v.size = dt, baseTypeSizes[key]
+		return v, nil
+	}
+	if strings.HasPrefix(key, "string") || strings.HasPrefix(key, "wstring") {
+		n, ok := t.typeSize(name)
+		if !ok {
+			return nil, &ValueError{Type: name, Reason: "invalid string type"}
+		}
+		v.isString, v.wide, v.size = true, strings.HasPrefix(key, "wstring"), n
+		v.strLen = n - 1
+		if v.wide {
+			v.strLen = n/2 - 1
+		}
+		return v, nil
+	}
+	switch dataType {
+	case DataTypeBit, DataTypeInt8, DataTypeUint8, DataTypeInt16, DataTypeUint16,
+		DataTypeInt32, DataTypeUint32, DataTypeInt64, DataTypeUint64, DataTypeReal32, DataTypeReal64:
+		// e.g. pointers
+		if err := v.checkSize(); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return nil, &ValueError{Type: name, Reason: "unsupported type"}
+}
+
+// checkSize returns an error if the size of a scalar type does not
+// match its data type. The sizes come from the target and must not
+// make the decoder read beyond the value. BIT types may be larger.
+func (v *valueType) checkSize() error {
+	if v.dims != nil || v.isString || (v.info != nil && len(v.info.Members) > 0) {
+		return nil
+	}
+	want, ok := dataTypeSizes[v.dataType]
+	if !ok || v.size == want || (v.dataType == DataTypeBit && v.size > want) {
+		return nil
+	}
+	return &ValueError{Type: v.name, Reason: fmt.Sprintf("size %d does not match data type %d", v.size, v.dataType)}
+}
+
+// Decode decodes the raw bytes of a value of the named type into a
+// tree of Go values. Structs and function blocks are decoded as
+// map[string]interface{}, arrays as []interface{} with nested slices
+// for multi-dimensional arrays, enums as the name of their value and
+// strings as string. BOOL is decoded as bool, integers as int64 or
+// uint64 and floating point numbers as float64.
+func (t *SymbolTable) Decode(typeName string, b []byte) (interface{}, error) {
+	typ, err := t.valueType(typeName, DataTypeVoid, uint32(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	return t.decode(typ, b, "")
+}
+
+// DecodeType is like Decode for an uploaded type.
+func (t *SymbolTable) DecodeType(info *TypeInfo, b []byte) (interface{}, error) {
+	return t.Decode(info.Name, b)
+}
+
+func (t *SymbolTable) decode(typ *valueType, b []byte, path string) (interface{}, error) {
+	fail := func(format string, args ...interface{}) (interface{}, error) {
+		return nil, &ValueError{Path: path, Type: typ.name, Reason: fmt.Sprintf(format, args...)}
+	}
+	if uint32(len(b)) < typ.size {
+		return fail("got %d bytes want %d", len(b), typ.size)
+	}
+	b = b[:typ.size]
+
+	switch {
+	case typ.dims != nil:
+		elem, err := t.valueType(typ.elemType, DataTypeVoid, 0)
+		if err != nil {
+			return nil, err
+		}
+		return t.decodeArray(typ.dims, elem, b, path)
+
+	case typ.info != nil && len(typ.info.Members) > 0:
+		m := make(map[string]interface{}, len(typ.info.Members))
+		for _, member := range typ.info.Members {
+			mt, err := t.valueType(member.Type, member.DataType, member.Size)
+			if err != nil {
+				return nil, err
+			}
+			if uint64(member.Offset)+uint64(mt.size) > uint64(len(b)) {
+				return fail("member %s exceeds the type", member.Name)
+			}
+			v, err := t.decode(mt, b[member.Offset:], path+"."+member.Name)
+			if err != nil {
+				return nil, err
+			}
+			m[member.Name] = v
+		}
+		return m, nil
+
+	case typ.info != nil && len(typ.info.Enum) > 0:
+		n, err := decodeInt(b, typ.dataType)
+		if err != nil {
+			return fail("%s", err)
+		}
+		for _, e := range typ.info.Enum {
+			if e.Value == n {
+				return e.Name, nil
+			}
+		}
+		// values without a name are kept as numbers
+		return n, nil
+
+	case typ.isString:
+		if typ.wide {
+			u := make([]uint16, 0, len(b)/2)
+			for i := 0; i+1 < len(b); i += 2 {
+				c := binary.LittleEndian.Uint16(b[i:])
+				if c == 0 {
+					break
+				}
+				u = append(u, c)
+			}
+			return string(utf16.Decode(u)), nil
+		}
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return string(b), nil
+	}
+
+	switch typ.dataType {
+	case DataTypeBit:
+		return b[0] != 0, nil
+	case DataTypeReal32:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), nil
+	case DataTypeReal64:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case DataTypeUint8, DataTypeUint16, DataTypeUint32:
+		n, err := decodeInt(b, typ.dataType)
+		if err != nil {
+			return fail("%s", err)
+		}
+		return uint64(n), nil
+	case DataTypeUint64:
+		return binary.LittleEndian.Uint64(b), nil
+	default:
+		n, err := decodeInt(b, typ.dataType)
+		if err != nil {
+			return fail("%s", err)
+		}
+		return n, nil
+	}
+}
+
+func (t *SymbolTable) decodeArray(dims []ArrayDim, elem *valueType, b []byte, path string) (interface{}, error) {
+	d := dims[0]
+	n := uint64(d.Elements)
+	if n == 0 {
+		return []interface{}{}, nil
+	}
+	stride := uint64(len(b)) / n
+	list := make([]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		p := fmt.Sprintf("%s[%d]", path, int64(d.LowerBound)+int64(i))
+		part := b[i*stride : (i+1)*stride]
+		var err error
+		if len(dims) > 1 {
+			list[i], err = t.decodeArray(dims[1:], elem, part, p)
+		} else {
+			list[i], err = t.decode(elem, part, p)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// EncodeJSON encodes a JSON value as the raw bytes of a value of the
+// named type. See Encode for the rules.
+func (t *SymbolTable) EncodeJSON(typeName string, data []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, &ValueError{Type: typeName, Reason: err.Error()}
+	}
+	if d.More() {
+		return nil, &ValueError{Type: typeName, Reason: "data after the value"}
+	}
+	return t.Encode(typeName, v)
+}
+
+// Encode encodes a tree of Go values like the ones of Decode or of
+// json.Unmarshal as the raw bytes of a value of the named type. The
+// value must match the type exactly. Structs need all members,
+// arrays all elements, integers must be in the range of their type
+// and strings must fit into their length. Enums accept the name or
+// the number of a defined value. Numbers can be Go numbers or
+// json.Number.
+func (t *SymbolTable) Encode(typeName string, v interface{}) ([]byte, error) {
+	typ, err := t.valueType(typeName, DataTypeVoid, 0)
+	if err != nil {
+		return nil, err
+	}
+	if typ.size == 0 {
+		return nil, &ValueError{Type: typeName, Reason: "unknown size"}
+	}
+	b := make([]byte, typ.size)
+	if err := t.encode(typ, v, b, ""); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (t *SymbolTable) encode(typ *valueType, v interface{}, b []byte, path string) error {
+	fail := func(format string, args ...interface{}) error {
+		return &ValueError{Path: path, Type: typ.name, Reason: fmt.Sprintf(format, args...)}
+	}
+	if uint32(len(b)) < typ.size {
+		return fail("got %d bytes want %d", len(b), typ.size)
+	}
+
+	switch {
+	case typ.dims != nil:
+		elem, err := t.valueType(typ.elemType, DataTypeVoid, 0)
+		if err != nil {
+			return err
+		}
+		return t.encodeArray(typ.dims, elem, v, b[:typ.size], path, typ.name)
+
+	case typ.info != nil && len(typ.info.Members) > 0:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fail("got %T want object", v)
+		}
+		seen := map[string]bool{}
+		for _, member := range typ.info.Members {
+			var mv interface{}
+			key := ""
+			for k, x := range m {
+				if !strings.EqualFold(k, member.Name) {
+					continue
+				}
+				if key != "" {
+					return fail("member %s given more than once", member.Name)
+				}
+				mv, key = x, k
+				seen[k] = true
+			}
+			if key == "" {
+				return fail("missing member %s", member.Name)
+			}
+			mt, err := t.valueType(member.Type, member.DataType, member.Size)
+			if err != nil {
+				return err
+			}
+			if uint64(member.Offset)+uint64(mt.size) > uint64(len(b)) {
+				return fail("member %s exceeds the type", member.Name)
+			}
+			if err := t.encode(mt, mv, b[member.Offset:], path+"."+member.Name); err != nil {
+				return err
+			}
+		}
+		for k := range m {
+			if !seen[k] {
+				return fail("unknown member %s", k)
+			}
+		}
+		return nil
+
+	case typ.info != nil && len(typ.info.Enum) > 0:
+		var n int64
+		found := false
+		switch x := v.(type) {
+		case string:
+			for _, e := range typ.info.Enum {
+				if strings.EqualFold(e.Name, x) {
+					n, found = e.Value, true
+				}
+			}
+			if !found {
+				return fail("unknown value %q", x)
+			}
+		default:
+			i, err := toInt(v, 64)
+			if err != nil {
+				return fail("%s", err)
+			}
+			for _, e := range typ.info.Enum {
+				if e.Value == i {
+					n, found = i, true
+				}
+			}
+			if !found {
+				return fail("undefined value %d", i)
+			}
+		}
+		return encodeInt(b, typ.dataType, n, fail)
+
+	case typ.isString:
+		s, ok := v.(string)
+		if !ok {
+			return fail("got %T want string", v)
+		}
+		for i := range b[:typ.size] {
+			b[i] = 0
+		}
+		if typ.wide {
+			u := utf16.Encode([]rune(s))
+			if uint32(len(u)) > typ.strLen {
+				return fail("string has %d characters, maximum is %d", len(u), typ.strLen)
+			}
+			for i, c := range u {
+				binary.LittleEndian.PutUint16(b[2*i:], c)
+			}
+			return nil
+		}
+		if uint32(len(s)) > typ.strLen {
+			return fail("string has %d bytes, maximum is %d", len(s), typ.strLen)
+		}
+		copy(b, s)
+		return nil
+	}
+
+	switch typ.dataType {
+	case DataTypeBit:
+		x, ok := v.(bool)
+		if !ok {
+			return fail("got %T want bool", v)
+		}
+		b[0] = 0
+		if x {
+			b[0] = 1
+		}
+		return nil
+	case DataTypeReal32, DataTypeReal64:
+		f, err := toFloat(v)
+		if err != nil {
+			return fail("%s", err)
+		}
+		if typ.dataType == DataTypeReal64 {
+			binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+			return nil
+		}
+		if math.Abs(f) > math.MaxFloat32 && !math.IsInf(f, 0) {
+			return fail("%v out of range", f)
+		}
+		binary.LittleEndian.PutUint32(b, math.Float32bits(float32(f)))
+		return nil
+	case DataTypeUint8, DataTypeUint16, DataTypeUint32, DataTypeUint64:
+		n, err := toUint(v, 8*int(typ.size))
+		if err != nil {
+			return fail("%s", err)
+		}
+		return encodeInt(b, typ.dataType, int64(n), fail)
+	case DataTypeInt8, DataTypeInt16, DataTypeInt32, DataTypeInt64:
+		n, err := toInt(v, 8*int(typ.size))
+		if err != nil {
+			return fail("%s", err)
+		}
+		return encodeInt(b, typ.dataType, n, fail)
+	}
+	return fail("unsupported data type %d", typ.dataType)
+}
+
+func (t *SymbolTable) encodeArray(dims []ArrayDim, elem *valueType, v interface{}, b []byte, path, typeName string) error {
+	d := dims[0]
+	list, ok := v.([]interface{})
+	if !ok {
+		return &ValueError{Path: path, Type: typeName, Reason: fmt.Sprintf("got %T want array", v)}
+	}
+	if len(list) != int(d.Elements) {
+		return &ValueError{Path: path, Type: typeName, Reason: fmt.Sprintf("got %d elements want %d", len(list), d.Elements)}
+	}
+	if d.Elements == 0 {
+		return nil
+	}
+	stride := len(b) / len(list)
+	for i, x := range list {
+		p := fmt.Sprintf("%s[%d]", path, int64(d.LowerBound)+int64(i))
+		part := b[i*stride : (i+1)*stride]
+		var err error
+		if len(dims) > 1 {
+			err = t.encodeArray(dims[1:], elem, x, part, p, typeName)
+		} else {
+			err = t.encode(elem, x, part, p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeInt writes n with the size of the data type.
+func encodeInt(b []byte, dataType uint32, n int64, fail func(string, ...interface{}) error) error {
+	switch dataType {
+	case DataTypeInt8, DataTypeUint8:
+		b[0] = byte(n)
+	case DataTypeInt16, DataTypeUint16:
+		binary.LittleEndian.PutUint16(b, uint16(n))
+	case DataTypeInt32, DataTypeUint32:
+		binary.LittleEndian.PutUint32(b, uint32(n))
+	case DataTypeInt64, DataTypeUint64:
+		binary.LittleEndian.PutUint64(b, uint64(n))
+	default:
+		return fail("unsupported data type %d", dataType)
+	}
+	return nil
+}
+
+// toInt converts a number to an integer with the given bit size.
+func toInt(v interface{}, bits int) (int64, error) {
+	var s string
+	switch x := v.(type) {
+	case json.Number:
+		s = string(x)
+	case int64:
+		s = strconv.FormatInt(x, 10)
+	case int:
+		s = strconv.Itoa(x)
+	case uint64:
+		s = strconv.FormatUint(x, 10)
+	case float64:
+		s = strconv.FormatFloat(x, 'f', -1, 64)
+	default:
+		return 0, fmt.Errorf("got %T want integer", v)
+	}
+	n, err := strconv.ParseInt(s, 10, bits)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a %d bit integer", s, bits)
+	}
+	return n, nil
+}
+
+// toUint converts a number to an unsigned integer with the given bit
+// size.
+func toUint(v interface{}, bits int) (uint64, error) {
+	var s string
+	switch x := v.(type) {
+	case json.Number:
+		s = string(x)
+	case int64:
+		s = strconv.FormatInt(x, 10)
+	case int:
+		s = strconv.Itoa(x)
+	case uint64:
+		s = strconv.FormatUint(x, 10)
+	case float64:
+		s = strconv.FormatFloat(x, 'f', -1, 64)
+	default:
+		return 0, fmt.Errorf("got %T want integer", v)
+	}
+	n, err := strconv.ParseUint(s, 10, bits)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not an unsigned %d bit integer", s, bits)
+	}
+	return n, nil
+}
+
+// jsonValue replaces the floats in a tree of values of Decode which
+// JSON cannot represent with the strings NaN, +Inf and -Inf.
+func jsonValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case float64:
+		switch {
+		case math.IsNaN(x):
+			return "NaN"
+		case math.IsInf(x, 1):
+			return "+Inf"
+		case math.IsInf(x, -1):
+			return "-Inf"
+		}
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			m[k] = jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(x))
+		for i, e := range x {
+			list[i] = jsonValue(e)
+		}
+		return list
+	}
+	return v
+}
+
+// toFloat converts a number to a float64. The strings of jsonValue
+// are accepted for the values which JSON cannot represent.
+func toFloat(v interface{}) (float64, error) {
+	switch x := v.(type) {
+	case string:
+		switch x {
+		case "NaN":
+			return math.NaN(), nil
+		case "+Inf":
+			return math.Inf(1), nil
+		case "-Inf":
+			return math.Inf(-1), nil
+		}
+	case json.Number:
+		return x.Float64()
+	case float64:
+		return x, nil
+	case int64:
+		return float64(x), nil
+	case int:
+		return float64(x), nil
+	case uint64:
+		return float64(x), nil
+	}
+	return 0, fmt.Errorf("got %T want number", v)
+}
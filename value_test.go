@@ -0,0 +1,177 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestValueDecode(t *testing.T) {
+	table := testSymbolTable()
+
+	fb := make([]byte, 24)
+	fb[0] = 1
+	binary.LittleEndian.PutUint16(fb[2:], 0xFFFF)
+	binary.LittleEndian.PutUint64(fb[8:], math.Float64bits(1.5))
+	binary.LittleEndian.PutUint64(fb[16:], math.Float64bits(-2))
+
+	grid := make([]byte, 24)
+	for i := 0; i < 12; i++ {
+		binary.LittleEndian.PutUint16(grid[2*i:], uint16(i))
+	}
+
+	tests := []struct {
+		typ  string
+		b    []byte
+		want interface{}
+	}{
+		{"INT", []byte{0xFE, 0xFF}, int64(-2)},
+		{"UDINT", []byte{1, 0, 0, 0x80}, uint64(0x80000001)},
+		{"BOOL", []byte{1}, true},
+		{"REAL", []byte{0, 0, 0xC0, 0x3F}, 1.5},
+		{"STRING(20)", append([]byte("hello\x00junk"), make([]byte, 11)...), "hello"},
+		{"WSTRING(3)", []byte{'a', 0, 0xAC, 0x20, 0, 0, 0, 0}, "a€"},
+		{"E_State", []byte{1, 0}, "Running"},
+		{"E_State", []byte{7, 0}, int64(7)},
+		{"FB_Motor", fb, map[string]interface{}{
+			"bOn":     true,
+			"eState":  "Fault",
+			"aSpeeds": []interface{}{1.5, -2.0},
+		}},
+		{"ARRAY [-1..1,0..3] OF INT", grid, []interface{}{
+			[]interface{}{int64(0), int64(1), int64(2), int64(3)},
+			[]interface{}{int64(4), int64(5), int64(6), int64(7)},
+			[]interface{}{int64(8), int64(9), int64(10), int64(11)},
+		}},
+	}
+	for _, tt := range tests {
+		v, err := table.Decode(tt.typ, tt.b)
+		if err != nil {
+			t.Errorf("%s: %v", tt.typ, err)
+			continue
+		}
+		verify.Values(t, tt.typ, v, tt.want)
+	}
+
+	_, err := table.Decode("FB_Motor", fb[:10])
+	verify.Values(t, "short", err, &ValueError{Type: "FB_Motor", Reason: "got 10 bytes want 24"})
+}
+
+func TestValueEncode(t *testing.T) {
+	table := testSymbolTable()
+
+	b, err := table.EncodeJSON("ARRAY [1..10] OF ST_Point",
+		[]byte(`[{"x":1,"y":-1},{"X":2,"Y":-2},{"x":3,"y":-3},{"x":4,"y":-4},{"x":5,"y":-5},
+		{"x":6,"y":-6},{"x":7,"y":-7},{"x":8,"y":-8},{"x":9,"y":-9},{"x":2147483647,"y":-2147483648}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "arr[2].y", int32(binary.LittleEndian.Uint32(b[12:])), int32(-2))
+	verify.Values(t, "arr[10].x", int32(binary.LittleEndian.Uint32(b[72:])), int32(math.MaxInt32))
+
+	// decoded values encode to the same bytes
+	fb := []byte(`{"bOn":true,"eState":"running","aSpeeds":[0.25,3]}`)
+	b, err = table.EncodeJSON("FB_Motor", fb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := table.Decode("FB_Motor", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := table.Encode("FB_Motor", v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "bytes", b2, b)
+	verify.Values(t, "value", v, map[string]interface{}{
+		"bOn":     true,
+		"eState":  "Running",
+		"aSpeeds": []interface{}{0.25, 3.0},
+	})
+
+	b, err = table.EncodeJSON("WSTRING(3)", []byte(`"a€b"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "wstring", b, []byte{'a', 0, 0xAC, 0x20, 'b', 0, 0, 0})
+}
+
+func TestValueEncodeErrors(t *testing.T) {
+	tests := []struct {
+		typ  string
+		json string
+		err  string
+	}{
+		{"INT", `32768`, "INT value: 32768 is not a 16 bit integer"},
+		{"USINT", `-1`, "USINT value: -1 is not an unsigned 8 bit integer"},
+		{"DINT", `1.5`, "DINT value: 1.5 is not a 32 bit integer"},
+		{"INT", `"1"`, "INT value: got string want integer"},
+		{"BOOL", `1`, "BOOL value: got json.Number want bool"},
+		{"REAL", `1e39`, "REAL value: 1e+39 out of range"},
+		{"STRING(20)", `"abcdefghijklmnopqrstu"`, "STRING(20) value: string has 21 bytes, maximum is 20"},
+		{"WSTRING(3)", `"abcd"`, "WSTRING(3) value: string has 4 characters, maximum is 3"},
+		{"E_State", `"Stopped"`, `E_State value: unknown value "Stopped"`},
+		{"E_State", `5`, "E_State value: undefined value 5"},
+		{"ST_Point", `{"x":1}`, "ST_Point value: missing member y"},
+		{"ST_Point", `{"x":1,"y":2,"z":3}`, "ST_Point value: unknown member z"},
+		{"ST_Point", `{"x":1,"X":2,"y":2}`, "ST_Point value: member x given more than once"},
+		{"ST_Point", `[1,2]`, "ST_Point value: got []interface {} want object"},
+		{"ARRAY [0..1] OF LREAL", `[1]`, "ARRAY [0..1] OF LREAL value: got 1 elements want 2"},
+		{"FB_Motor", `{"bOn":false,"eState":"Idle","aSpeeds":[1,"x"]}`, "LREAL value at .aSpeeds[1]: got string want number"},
+		{"ARRAY [-1..1,0..3] OF INT", `[[0,0,0,0],[0,0,0,0],[0,0,0,70000]]`, "INT value at [1][3]: 70000 is not a 16 bit integer"},
+		{"INT", `1 2`, "INT value: data after the value"},
+		{"T_Unknown", `1`, "T_Unknown value: unsupported type"},
+	}
+	table := testSymbolTable()
+	for _, tt := range tests {
+		_, err := table.EncodeJSON(tt.typ, []byte(tt.json))
+		var e *ValueError
+		if !errors.As(err, &e) {
+			t.Errorf("%s %s: got error %v want *ValueError", tt.typ, tt.json, err)
+			continue
+		}
+		verify.Values(t, tt.typ+" "+tt.json, err.Error(), tt.err)
+	}
+}
+
+func TestValueInvalidSize(t *testing.T) {
+	table := NewSymbolTable(nil, []*TypeInfo{
+		{Name: "T_Real", DataType: DataTypeReal32},
+		{Name: "T_LReal", Size: 4, DataType: DataTypeReal64},
+		{Name: "T_Bool", DataType: DataTypeBit},
+		{Name: "E_Short", Size: 1, DataType: DataTypeInt16, Enum: []EnumValue{{Name: "A", Value: 0}}},
+	})
+	tests := []struct {
+		typ string
+		v   interface{}
+		err string
+	}{
+		{"T_Real", 1.5, "T_Real value: size 0 does not match data type 4"},
+		{"T_LReal", 1.5, "T_LReal value: size 4 does not match data type 5"},
+		{"T_Bool", true, "T_Bool value: size 0 does not match data type 33"},
+		{"E_Short", "A", "E_Short value: size 1 does not match data type 2"},
+	}
+	for _, tt := range tests {
+		_, err := table.Decode(tt.typ, nil)
+		var e *ValueError
+		if !errors.As(err, &e) {
+			t.Errorf("decode %s: got error %v want *ValueError", tt.typ, err)
+		} else {
+			verify.Values(t, "decode "+tt.typ, err.Error(), tt.err)
+		}
+		_, err = table.Encode(tt.typ, tt.v)
+		if !errors.As(err, &e) {
+			t.Errorf("encode %s: got error %v want *ValueError", tt.typ, err)
+		} else {
+			verify.Values(t, "encode "+tt.typ, err.Error(), tt.err)
+		}
+	}
+}
@@ -0,0 +1,602 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snapshots can also be stored as YAML. The package only supports the
+// subset of YAML 1.2 which a snapshot needs: block mappings and
+// sequences, flow mappings and sequences on a single line, plain,
+// single-quoted and double-quoted scalars and comments. Anchors, tags,
+// block scalars and multiple documents are not supported. A YAML
+// snapshot is converted to JSON and read like a JSON snapshot.
+
+// ReadSnapshotYAML reads a snapshot which was written with WriteYAML.
+func ReadSnapshotYAML(r io.Reader) (*Snapshot, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	v, err := parseYAML(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	return ReadSnapshot(bytes.NewReader(j))
+}
+
+// WriteYAML writes the snapshot as YAML. Strings are double-quoted
+// and the members of structures are sorted by name.
+func (s *Snapshot) WriteYAML(w io.Writer) (int64, error) {
+	doc := yamlMap{
+		{"version", json.Number(strconv.Itoa(s.Version))},
+		{"time", s.Time.Format(time.RFC3339Nano)},
+	}
+	if s.Target != "" {
+		doc = append(doc, yamlField{"target", s.Target})
+	}
+	items := make([]interface{}, len(s.Items))
+	for i, item := range s.Items {
+		v, err := normalizeJSON(jsonValue(item.Value))
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", item.Path, err)
+		}
+		items[i] = yamlMap{
+			{"path", item.Path},
+			{"type", item.Type},
+			{"size", json.Number(strconv.FormatUint(uint64(item.Size), 10))},
+			{"value", v},
+		}
+	}
+	doc = append(doc, yamlField{"items", items})
+
+	var b bytes.Buffer
+	writeYAMLBlock(&b, 0, doc, false)
+	n, err := w.Write(b.Bytes())
+	return int64(n), err
+}
+
+// normalizeJSON converts a value to the types of a JSON decoder with
+// UseNumber.
+func normalizeJSON(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var x interface{}
+	if err := d.Decode(&x); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// yamlMap is a mapping whose keys are written in order.
+type yamlMap []yamlField
+
+type yamlField struct {
+	key   string
+	value interface{}
+}
+
+// writeYAMLNode writes v after a key or a sequence indicator. indent
+// is the indentation of the entries of a collection. Collections in a
+// sequence start on the line of the indicator.
+func writeYAMLNode(b *bytes.Buffer, indent int, v interface{}, inSeq bool) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		if len(x) == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		m := make(yamlMap, len(keys))
+		for i, k := range keys {
+			m[i] = yamlField{k, x[k]}
+		}
+		v = m
+	case yamlMap:
+		if len(x) == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+	case []interface{}:
+		if len(x) == 0 {
+			b.WriteString(" []\n")
+			return
+		}
+	default:
+		b.WriteByte(' ')
+		b.WriteString(yamlScalar(v))
+		b.WriteByte('\n')
+		return
+	}
+	if inSeq {
+		b.WriteByte(' ')
+	} else {
+		b.WriteByte('\n')
+	}
+	writeYAMLBlock(b, indent, v, inSeq)
+}
+
+// writeYAMLBlock writes the entries of a non-empty yamlMap or
+// sequence. If inline is set, the first entry continues the current
+// line.
+func writeYAMLBlock(b *bytes.Buffer, indent int, v interface{}, inline bool) {
+	prefix := strings.Repeat(" ", indent)
+	switch x := v.(type) {
+	case yamlMap:
+		for i, f := range x {
+			if i > 0 || !inline {
+				b.WriteString(prefix)
+			}
+			b.WriteString(yamlKey(f.key))
+			b.WriteByte(':')
+			writeYAMLNode(b, indent+2, f.value, false)
+		}
+	case []interface{}:
+		for i, item := range x {
+			if i > 0 || !inline {
+				b.WriteString(prefix)
+			}
+			b.WriteByte('-')
+			writeYAMLNode(b, indent+2, item, true)
+		}
+	}
+}
+
+// yamlScalar returns the YAML form of a decoded JSON scalar. Strings
+// are double-quoted since the escapes of JSON are valid in YAML.
+func yamlScalar(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(x)
+	case json.Number:
+		return x.String()
+	case string:
+		var b bytes.Buffer
+		e := json.NewEncoder(&b)
+		e.SetEscapeHTML(false)
+		e.Encode(x)
+		return strings.TrimSuffix(b.String(), "\n")
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// yamlKey returns a key plain if it is an identifier and quoted
+// otherwise.
+func yamlKey(k string) string {
+	if k == "" || resolvePlain(k) != k {
+		return yamlScalar(k)
+	}
+	for i, r := range k {
+		switch {
+		case r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z':
+		case i > 0 && '0' <= r && r <= '9':
+		default:
+			return yamlScalar(k)
+		}
+	}
+	return k
+}
+
+// yamlLine is a line of a YAML document without its indentation and
+// comment.
+type yamlLine struct {
+	n      int // line number
+	indent int
+	text   string
+}
+
+// yamlParser parses the block structure of a YAML document. Values
+// are returned as the types of a JSON decoder with UseNumber.
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+func parseYAML(b []byte) (interface{}, error) {
+	var lines []yamlLine
+	for i, s := range strings.Split(string(b), "\n") {
+		s = strings.TrimRight(stripYAMLComment(strings.TrimSuffix(s, "\r")), " \t")
+		text := strings.TrimLeft(s, " ")
+		if text == "" {
+			continue
+		}
+		if text[0] == '\t' {
+			return nil, fmt.Errorf("yaml: line %d: tabs are not allowed for indentation", i+1)
+		}
+		if s == "---" || s == "..." {
+			if len(lines) > 0 || s == "..." {
+				return nil, fmt.Errorf("yaml: line %d: multiple documents are not supported", i+1)
+			}
+			continue
+		}
+		lines = append(lines, yamlLine{n: i + 1, indent: len(s) - len(text), text: text})
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	p := &yamlParser{lines: lines}
+	v, err := p.node(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return v, nil
+}
+
+// stripYAMLComment removes a comment which starts with '#' at the
+// beginning of the line or after a space outside of quotes.
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote == '\'' && c == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.IndexByte(" \t[{,", s[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#':
+			if i == 0 || s[i-1] == ' ' || s[i-1] == '\t' {
+				return s[:i]
+			}
+		}
+	}
+	return s
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	n := p.lines[len(p.lines)-1].n
+	if p.i < len(p.lines) {
+		n = p.lines[p.i].n
+	}
+	return fmt.Errorf("yaml: line %d: %s", n, fmt.Sprintf(format, args...))
+}
+
+// node parses the node at the current line. Its indentation must be
+// at least min.
+func (p *yamlParser) node(min int) (interface{}, error) {
+	l := p.lines[p.i]
+	if l.indent < min {
+		return nil, nil
+	}
+	if isSeqEntry(l.text) {
+		return p.seq(l.indent)
+	}
+	if _, _, ok, err := splitYAMLKey(l.text); err != nil {
+		return nil, p.errorf("%s", err)
+	} else if ok {
+		return p.mapping(l.indent)
+	}
+	p.i++
+	v, err := parseFlow(l.text)
+	if err != nil {
+		return nil, fmt.Errorf("yaml: line %d: %s", l.n, err)
+	}
+	return v, nil
+}
+
+func isSeqEntry(s string) bool {
+	return s == "-" || strings.HasPrefix(s, "- ")
+}
+
+// seq parses a block sequence whose indicators are at indent.
+func (p *yamlParser) seq(indent int) (interface{}, error) {
+	list := []interface{}{}
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent && isSeqEntry(p.lines[p.i].text) {
+		l := p.lines[p.i]
+		rest := strings.TrimLeft(l.text[1:], " ")
+		var v interface{}
+		var err error
+		if rest == "" {
+			p.i++
+			if p.i < len(p.lines) {
+				v, err = p.node(indent + 1)
+			}
+		} else {
+			// parse the rest as a line of its own so that
+			// a mapping can continue on the next lines.
+			p.lines[p.i] = yamlLine{n: l.n, indent: indent + len(l.text) - len(rest), text: rest}
+			v, err = p.node(indent + 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	if p.i < len(p.lines) && p.lines[p.i].indent > indent {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return list, nil
+}
+
+// mapping parses a block mapping whose keys are at indent.
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent {
+		l := p.lines[p.i]
+		if isSeqEntry(l.text) {
+			return nil, p.errorf("unexpected sequence entry")
+		}
+		key, rest, ok, err := splitYAMLKey(l.text)
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		}
+		if !ok {
+			return nil, p.errorf("want key: value")
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		p.i++
+
+		var v interface{}
+		switch {
+		case rest != "":
+			if v, err = parseFlow(rest); err != nil {
+				return nil, fmt.Errorf("yaml: line %d: %s", l.n, err)
+			}
+		case p.i == len(p.lines):
+		case p.lines[p.i].indent > indent:
+			v, err = p.node(indent + 1)
+		case p.lines[p.i].indent == indent && isSeqEntry(p.lines[p.i].text):
+			// a sequence may have the indentation of its key
+			v, err = p.seq(indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	if p.i < len(p.lines) && p.lines[p.i].indent > indent {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return m, nil
+}
+
+// splitYAMLKey splits a line into the key and the value of a mapping
+// entry. ok is false if the line is not a mapping entry.
+func splitYAMLKey(s string) (key, rest string, ok bool, err error) {
+	if s[0] == '"' || s[0] == '\'' {
+		f := &flowParser{s: s}
+		v, err := f.quoted()
+		if err != nil {
+			return "", "", false, err
+		}
+		after := s[f.i:]
+		if !strings.HasPrefix(after, ":") || len(after) > 1 && after[1] != ' ' {
+			return "", "", false, nil
+		}
+		return v, strings.TrimSpace(after[1:]), true, nil
+	}
+	if s[0] == '[' || s[0] == '{' {
+		return "", "", false, nil
+	}
+	i := strings.Index(s, ": ")
+	if i < 0 {
+		if !strings.HasSuffix(s, ":") {
+			return "", "", false, nil
+		}
+		i = len(s) - 1
+	}
+	return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]), true, nil
+}
+
+// flowParser parses a scalar or a flow collection on a single line.
+type flowParser struct {
+	s string
+	i int
+}
+
+func parseFlow(s string) (interface{}, error) {
+	f := &flowParser{s: s}
+	v, err := f.value(false)
+	if err != nil {
+		return nil, err
+	}
+	f.space()
+	if f.i < len(f.s) {
+		return nil, fmt.Errorf("unexpected %q", f.s[f.i:])
+	}
+	return v, nil
+}
+
+func (f *flowParser) space() {
+	for f.i < len(f.s) && f.s[f.i] == ' ' {
+		f.i++
+	}
+}
+
+// value parses the next value. In a flow collection plain scalars end
+// before a flow indicator.
+func (f *flowParser) value(inFlow bool) (interface{}, error) {
+	f.space()
+	if f.i == len(f.s) {
+		return nil, nil
+	}
+	switch c := f.s[f.i]; c {
+	case '[':
+		return f.seq()
+	case '{':
+		return f.mapping()
+	case '"', '\'':
+		return f.quoted()
+	case '&', '*', '!', '|', '>', '%', '@', '`':
+		return nil, fmt.Errorf("%q is not supported", c)
+	}
+	return resolvePlain(f.plain(inFlow)), nil
+}
+
+// plain returns the text of a plain scalar.
+func (f *flowParser) plain(inFlow bool) string {
+	start := f.i
+	for ; f.i < len(f.s); f.i++ {
+		if !inFlow {
+			continue
+		}
+		c := f.s[f.i]
+		if strings.IndexByte(",[]{}", c) >= 0 {
+			break
+		}
+		if c == ':' && (f.i+1 == len(f.s) || strings.IndexByte(" ,[]{}", f.s[f.i+1]) >= 0) {
+			break
+		}
+	}
+	return strings.TrimSpace(f.s[start:f.i])
+}
+
+// quoted parses a single-quoted or double-quoted scalar. Double-quoted
+// scalars support the escapes of JSON.
+func (f *flowParser) quoted() (string, error) {
+	q := f.s[f.i]
+	for j := f.i + 1; j < len(f.s); j++ {
+		switch {
+		case q == '"' && f.s[j] == '\\':
+			j++
+		case f.s[j] == q && q == '\'' && j+1 < len(f.s) && f.s[j+1] == '\'':
+			j++
+		case f.s[j] == q:
+			raw := f.s[f.i : j+1]
+			f.i = j + 1
+			if q == '\'' {
+				return strings.ReplaceAll(raw[1:len(raw)-1], "''", "'"), nil
+			}
+			var s string
+			if err := json.Unmarshal([]byte(raw), &s); err != nil {
+				return "", fmt.Errorf("invalid string %s", raw)
+			}
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("unterminated string %s", f.s[f.i:])
+}
+
+func (f *flowParser) seq() (interface{}, error) {
+	f.i++ // [
+	list := []interface{}{}
+	for {
+		f.space()
+		if f.i < len(f.s) && f.s[f.i] == ']' {
+			f.i++
+			return list, nil
+		}
+		v, err := f.value(true)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+		if err := f.next(']'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f *flowParser) mapping() (interface{}, error) {
+	f.i++ // {
+	m := map[string]interface{}{}
+	for {
+		f.space()
+		if f.i < len(f.s) && f.s[f.i] == '}' {
+			f.i++
+			return m, nil
+		}
+		var key string
+		var err error
+		if f.i < len(f.s) && (f.s[f.i] == '"' || f.s[f.i] == '\'') {
+			key, err = f.quoted()
+		} else {
+			key = f.plain(true)
+		}
+		if err != nil {
+			return nil, err
+		}
+		f.space()
+		if f.i == len(f.s) || f.s[f.i] != ':' {
+			return nil, fmt.Errorf("missing ':' after key %q", key)
+		}
+		f.i++
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("duplicate key %q", key)
+		}
+		if m[key], err = f.value(true); err != nil {
+			return nil, err
+		}
+		if err := f.next('}'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// next consumes the ',' between the entries of a flow collection. The
+// end of the collection is left for the caller.
+func (f *flowParser) next(end byte) error {
+	f.space()
+	switch {
+	case f.i == len(f.s):
+		return fmt.Errorf("missing %q", end)
+	case f.s[f.i] == ',':
+		f.i++
+		return nil
+	case f.s[f.i] == end:
+		return nil
+	default:
+		return fmt.Errorf("unexpected %q", f.s[f.i:])
+	}
+}
+
+// resolvePlain returns the value of a plain scalar like the core
+// schema of YAML 1.2 but numbers must use the JSON syntax. Infinity
+// and NaN become the strings of jsonValue.
+func resolvePlain(s string) interface{} {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	case ".nan", ".NaN", ".NAN":
+		return "NaN"
+	case ".inf", ".Inf", ".INF", "+.inf", "+.Inf", "+.INF":
+		return "+Inf"
+	case "-.inf", "-.Inf", "-.INF":
+		return "-Inf"
+	}
+	if (s[0] == '-' || '0' <= s[0] && s[0] <= '9') && json.Valid([]byte(s)) {
+		return json.Number(s)
+	}
+	return s
+}
@@ -0,0 +1,150 @@
+// Copyright 2021 gotwincat authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be
+// found in the LICENSE file.
+
+package twincat
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestSnapshotYAML(t *testing.T) {
+	snap := &Snapshot{
+		Version: SnapshotVersion,
+		Time:    time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+		Target:  "1.2.3.4.5.6:851",
+		Items: []*SnapshotItem{
+			{Path: "MAIN.nValue", Type: "INT", Size: 2, Value: int64(-2)},
+			{Path: "GVL.sName", Type: "STRING(20)", Size: 21, Value: "a \"b\" # c"},
+			{Path: "MAIN.pt", Type: "ST_Point", Size: 8, Value: map[string]interface{}{"y": 1.5, "x": int64(1), "null": true}},
+			{Path: "MAIN.grid", Type: "ARRAY [0..1,0..1] OF INT", Size: 8, Value: []interface{}{
+				[]interface{}{int64(1), int64(2)},
+				[]interface{}{int64(3), int64(4)},
+			}},
+			{Path: "MAIN.arr", Type: "ARRAY [0..1] OF ST_Point", Size: 16, Value: []interface{}{
+				map[string]interface{}{"x": int64(1), "y": int64(2)},
+				map[string]interface{}{},
+			}},
+			{Path: "MAIN.empty", Type: "ARRAY [0..-1] OF INT", Size: 0, Value: []interface{}{}},
+		},
+	}
+	var buf bytes.Buffer
+	if _, err := snap.WriteYAML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `version: 1
+time: "2021-06-01T12:00:00Z"
+target: "1.2.3.4.5.6:851"
+items:
+  - path: "MAIN.nValue"
+    type: "INT"
+    size: 2
+    value: -2
+  - path: "GVL.sName"
+    type: "STRING(20)"
+    size: 21
+    value: "a \"b\" # c"
+  - path: "MAIN.pt"
+    type: "ST_Point"
+    size: 8
+    value:
+      "null": true
+      x: 1
+      y: 1.5
+  - path: "MAIN.grid"
+    type: "ARRAY [0..1,0..1] OF INT"
+    size: 8
+    value:
+      - - 1
+        - 2
+      - - 3
+        - 4
+  - path: "MAIN.arr"
+    type: "ARRAY [0..1] OF ST_Point"
+    size: 16
+    value:
+      - x: 1
+        y: 2
+      - {}
+  - path: "MAIN.empty"
+    type: "ARRAY [0..-1] OF INT"
+    size: 0
+    value: []
+`
+	verify.Values(t, "yaml", buf.String(), want)
+
+	got, err := ReadSnapshotYAML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var j bytes.Buffer
+	if _, err := snap.WriteTo(&j); err != nil {
+		t.Fatal(err)
+	}
+	wantSnap, err := ReadSnapshot(&j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify.Values(t, "snapshot", got, wantSnap)
+}
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // JSON
+	}{
+		{"", "null"},
+		{"--- # document\na: 1\n", `{"a":1}`},
+		{"a: ~\nb: true\nc: False\nd: 1.5e3\ne: +1\nf: 0x10\n", `{"a":null,"b":true,"c":false,"d":1.5e3,"e":"+1","f":"0x10"}`},
+		{"a: 'it''s # x' # comment\nb: plain # text\n", `{"a":"it's # x","b":"plain"}`},
+		{"a: \"\\u00e4\\n\"\n", `{"a":"ä\n"}`},
+		{"a:\n- 1\n- b: 2\n  c: [3, 'x, y', {d: e}]\nf: {}\n", `{"a":[1,{"b":2,"c":[3,"x, y",{"d":"e"}]}],"f":{}}`},
+		{"- \n  - 1\n-\n- http://host:80\n", `[[1],null,"http://host:80"]`},
+		{"\"a: b\": c\n'd':\n", `{"a: b":"c","d":null}`},
+		{"[.nan, .inf, -.Inf, '.nan']", `["NaN","+Inf","-Inf",".nan"]`},
+	}
+	for _, tt := range tests {
+		v, err := parseYAML([]byte(tt.in))
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verify.Values(t, tt.in, string(b), tt.want)
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		in, err string
+	}{
+		{"a: 1\na: 2\n", "yaml: line 2: duplicate key \"a\""},
+		{"a: 1\n  b: 2\n", "yaml: line 2: unexpected indentation"},
+		{"a:\n\tb: 1\n", "yaml: line 2: tabs are not allowed for indentation"},
+		{"a: &x 1\n", "yaml: line 1: '&' is not supported"},
+		{"a: |\n  text\n", "yaml: line 1: '|' is not supported"},
+		{"a: [1, 2\n", "yaml: line 1: missing ']'"},
+		{"a: \"x\n", "yaml: line 1: unterminated string \"x"},
+		{"a: 1\n- 2\n", "yaml: line 2: unexpected sequence entry"},
+		{"a: 1\n---\nb: 2\n", "yaml: line 2: multiple documents are not supported"},
+	}
+	for _, tt := range tests {
+		_, err := parseYAML([]byte(tt.in))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: got %v want %s", tt.in, err, tt.err)
+		}
+	}
+
+	if _, err := ReadSnapshotYAML(strings.NewReader("version: 2\n")); err == nil || err.Error() != "unsupported snapshot version 2" {
+		t.Errorf("got %v want unsupported snapshot version", err)
+	}
+}